@@ -3,3 +3,19 @@ provider "xenserver" {
   username = "root"
   password = var.password
 }
+
+# Verify the host certificate with an internal CA
+provider "xenserver" {
+  alias    = "verified"
+  host     = "https://xenserver.example.com"
+  username = "root"
+  password = var.password
+  ca_file  = "/etc/pki/xenserver-ca.pem"
+}
+
+# Use a session created by a credential broker
+provider "xenserver" {
+  alias      = "brokered"
+  host       = "https://xenserver.example.com"
+  session_id = var.session_id
+}
@@ -1 +1,10 @@
-terraform import xenserver_network_vlan.vlan 00000000-0000-0000-0000-000000000000
\ No newline at end of file
+terraform import xenserver_network_vlan.vlan 00000000-0000-0000-0000-000000000000
+
+# or by name, which must match a single VLAN network
+terraform import xenserver_network_vlan.vlan name=vlan-100
+
+# or, since Terraform 1.12, by the resource identity in an import block
+#   import {
+#     to       = xenserver_network_vlan.vlan
+#     identity = { uuid = "00000000-0000-0000-0000-000000000000" }
+#   }
\ No newline at end of file
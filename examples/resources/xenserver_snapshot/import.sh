@@ -1 +1,10 @@
-terraform import xenserver_snapshot.snapshot 00000000-0000-0000-0000-000000000000
\ No newline at end of file
+terraform import xenserver_snapshot.snapshot 00000000-0000-0000-0000-000000000000
+
+# or by name, which must match a single snapshot
+terraform import xenserver_snapshot.snapshot vm=00000000-0000-0000-0000-000000000000/name=snapshot-1
+
+# or, since Terraform 1.12, by the resource identity in an import block
+#   import {
+#     to       = xenserver_snapshot.snapshot
+#     identity = { uuid = "00000000-0000-0000-0000-000000000000" }
+#   }
\ No newline at end of file
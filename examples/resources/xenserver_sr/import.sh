@@ -1 +1,10 @@
-terraform import xenserver_sr.local 00000000-0000-0000-0000-000000000000
\ No newline at end of file
+terraform import xenserver_sr.local 00000000-0000-0000-0000-000000000000
+
+# or by name, which must match a single SR
+terraform import xenserver_sr.local name=local-storage
+
+# or, since Terraform 1.12, by the resource identity in an import block
+#   import {
+#     to       = xenserver_sr.local
+#     identity = { uuid = "00000000-0000-0000-0000-000000000000" }
+#   }
\ No newline at end of file
@@ -1 +1,10 @@
-terraform import xenserver_sr_smb.smb_test 00000000-0000-0000-0000-000000000000
\ No newline at end of file
+terraform import xenserver_sr_smb.smb_test 00000000-0000-0000-0000-000000000000
+
+# or by name, which must match a single SMB SR
+terraform import xenserver_sr_smb.smb_test name=smb-storage
+
+# or, since Terraform 1.12, by the resource identity in an import block
+#   import {
+#     to       = xenserver_sr_smb.smb_test
+#     identity = { uuid = "00000000-0000-0000-0000-000000000000" }
+#   }
\ No newline at end of file
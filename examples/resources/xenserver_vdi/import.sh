@@ -1 +1,10 @@
-terraform import xenserver_vdi.vdi 00000000-0000-0000-0000-000000000000
\ No newline at end of file
+terraform import xenserver_vdi.vdi 00000000-0000-0000-0000-000000000000
+
+# or by name, which must match a single VDI
+terraform import xenserver_vdi.vdi sr=00000000-0000-0000-0000-000000000000/name=data
+
+# or, since Terraform 1.12, by the resource identity in an import block
+#   import {
+#     to       = xenserver_vdi.vdi
+#     identity = { uuid = "00000000-0000-0000-0000-000000000000" }
+#   }
\ No newline at end of file
@@ -1 +1,12 @@
-terraform import xenserver_vm.vm 00000000-0000-0000-0000-000000000000
\ No newline at end of file
+# the disks of the template are found again by the `base_template_name` key XAPI
+# sets in the VM other_config, and are not managed in `hard_drive`
+terraform import xenserver_vm.vm 00000000-0000-0000-0000-000000000000
+
+# or by name, which must match a single VM
+terraform import xenserver_vm.vm name=vm-1
+
+# or, since Terraform 1.12, by the resource identity in an import block
+#   import {
+#     to       = xenserver_vm.vm
+#     identity = { uuid = "00000000-0000-0000-0000-000000000000" }
+#   }
\ No newline at end of file
@@ -37,6 +37,10 @@ resource "xenserver_vm" "windows_vm" {
       bootable = false,
       mode     = "RO"
     },
+    {
+      size    = 50 * 1024 * 1024 * 1024,
+      sr_uuid = data.xenserver_sr.sr.data_items[0].uuid,
+    },
   ]
 
   network_interface = [
@@ -83,6 +87,8 @@ resource "xenserver_vm" "linux_vm" {
   static_mem_max   = 4 * 1024 * 1024 * 1024
   vcpus            = 4
   check_ip_timeout = 60 * 5
+  # Start the VM again when it is shut down outside of Terraform
+  power_state      = "running"
 
   # Don't need to set up a hard drive if the custom template includes it
 
@@ -114,6 +120,39 @@ variable "password" {
   sensitive   = true
 }
 
+# Create a Linux VM configured by cloud-init at its first boot
+resource "xenserver_vm" "cloud_init_vm" {
+  name_label       = "Cloud-init VM"
+  template_name    = "CustomCloudTemplate"
+  static_mem_max   = 4 * 1024 * 1024 * 1024
+  vcpus            = 2
+  check_ip_timeout = 60 * 5
+
+  network_interface = [
+    {
+      network_uuid = data.xenserver_network.network.data_items[0].uuid,
+      device       = "0"
+    },
+  ]
+
+  cloud_init = {
+    user_data = <<-EOT
+      #cloud-config
+      hostname: cloud-init-vm
+      ssh_authorized_keys:
+        - ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIExampleKey user@example.com
+    EOT
+    network_config = <<-EOT
+      version: 2
+      ethernets:
+        eth0:
+          dhcp4: true
+    EOT
+    # detach and destroy the config drive once the VM has an IP address
+    remove_after_boot = true
+  }
+}
+
 # Create multiple VMs
 locals {
   virtual_machines = {
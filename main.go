@@ -44,6 +44,8 @@ func main() {
 	}
 
 	err := providerserver.Serve(context.Background(), xenserver.New(version), opts)
+	// the XenServer API sessions are not needed anymore once the server stops
+	xenserver.LogoutSessions()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
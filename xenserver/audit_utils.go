@@ -0,0 +1,158 @@
+package xenserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maskedValue = "******"
+
+// sensitiveParams are the positions of the parameters holding credentials in
+// the XAPI calls, by method key (see xapiMethodKey).
+var sensitiveParams = map[string][]int{
+	"session.login_with_password": {1},
+	"session.change_password":     {1, 2},
+	"pool.join":                   {3},
+	"pool.join_force":             {3},
+	"secret.create":               {1},
+	"secret.set_value":            {2},
+}
+
+// xapiMethodKey returns the lower case name of the XAPI method without the
+// Async prefix, e.g. pool.join for Async.pool.join, as the asynchronous calls
+// take the same parameters.
+func xapiMethodKey(method string) string {
+	method = strings.ToLower(method)
+	return strings.TrimPrefix(method, "async.")
+}
+
+// auditEntry is one line of the audit log, written for each request the
+// relays send to a host, including the retries and the logins done again.
+type auditEntry struct {
+	Time       string `json:"time"`
+	Host       string `json:"host"`
+	Method     string `json:"method"`
+	Params     []any  `json:"params"`
+	DurationMs int64  `json:"durationMs"`
+	// Result is SUCCESS, the XAPI error code or ERROR when no response was
+	// received.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// jsonLinesFile appends JSON lines to a file, e.g. the audit log configured
+// with api_log_file. The file is shared by all the relays writing to it and
+// stays open until the provider exits.
+type jsonLinesFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var jsonLinesFiles = struct {
+	mu    sync.Mutex
+	files map[string]*jsonLinesFile
+}{files: map[string]*jsonLinesFile{}}
+
+func openJSONLinesFile(path string) (*jsonLinesFile, error) {
+	jsonLinesFiles.mu.Lock()
+	defer jsonLinesFiles.mu.Unlock()
+
+	if f, ok := jsonLinesFiles.files[path]; ok {
+		return f, nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) //nolint:gosec // the path is configured by the user
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	f := &jsonLinesFile{file: file}
+	jsonLinesFiles.files[path] = f
+
+	return f, nil
+}
+
+// write appends the value as one line. Failures to write are ignored so that
+// the logs never break an apply.
+func (f *jsonLinesFile) write(value any) {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, _ = f.file.Write(append(line, '\n'))
+}
+
+// newAuditEntry returns the entry of a request sent to the host.
+func newAuditEntry(upstream string, request *xapiRequest, start time.Time, resp *relayResponse, err error) auditEntry {
+	entry := auditEntry{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		Host:       upstream,
+		Method:     request.Method,
+		Params:     sanitizeParams(request.Method, request.Params),
+		DurationMs: time.Since(start).Milliseconds(),
+		Result:     "SUCCESS",
+	}
+	switch {
+	case err != nil:
+		entry.Result = "ERROR"
+		entry.Error = err.Error()
+	case getErrorCode(resp) != "":
+		entry.Result = getErrorCode(resp)
+	}
+
+	return entry
+}
+
+// sanitizeParams masks the session reference, the credentials and the values
+// of the map keys that look like passwords or secrets, e.g. the device config
+// of an SMB storage repository.
+func sanitizeParams(method string, params []json.RawMessage) []any {
+	sensitive := sensitiveParams[xapiMethodKey(method)]
+	sanitized := make([]any, 0, len(params))
+	for i, param := range params {
+		var value any
+		if json.Unmarshal(param, &value) != nil {
+			value = nil
+		}
+		switch {
+		case slices.Contains(sensitive, i):
+			value = maskedValue
+		case i == 0 && !slices.Contains(sessionLoginMethods, method):
+			// the session reference grants access to the pool
+			value = maskedValue
+		default:
+			value = maskSecrets(value)
+		}
+		sanitized = append(sanitized, value)
+	}
+
+	return sanitized
+}
+
+func maskSecrets(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if isSecretKey(key) {
+				v[key] = maskedValue
+			} else {
+				v[key] = maskSecrets(item)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = maskSecrets(item)
+		}
+	}
+	return value
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "password") || strings.Contains(key, "secret")
+}
@@ -0,0 +1,100 @@
+package xenserver
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"xenapi"
+)
+
+func TestAuditLog(t *testing.T) {
+	host := &fakeSessionHost{}
+	server := httptest.NewServer(host)
+	defer server.Close()
+
+	logFile := filepath.Join(t.TempDir(), "api.log")
+	session, err := loginServer(server.URL, "root", "password", &clientConf{APILogFile: logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.Pool.Join(session, "192.0.2.1", "root", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "\"password\"") || strings.Contains(string(content), "\"secret\"") || strings.Contains(string(content), host.valid) {
+		t.Fatalf("expected the credentials and the session to be masked, got: %s", content)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(lines))
+	}
+	var entry auditEntry
+	err = json.Unmarshal([]byte(lines[1]), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Method != "pool.join" || entry.Result != "SUCCESS" || entry.Host != server.URL {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if entry.Params[1] != "192.0.2.1" || entry.Params[3] != maskedValue {
+		t.Fatalf("unexpected params: %v", entry.Params)
+	}
+}
+
+func TestSanitizeParams(t *testing.T) {
+	params := []json.RawMessage{
+		json.RawMessage(`"OpaqueRef:session"`),
+		json.RawMessage(`"OpaqueRef:host"`),
+		json.RawMessage(`{"server":"//smb/share","cifspassword":"secret"}`),
+	}
+	sanitized := sanitizeParams("SR.create", params)
+	if sanitized[0] != maskedValue || sanitized[1] != "OpaqueRef:host" {
+		t.Fatalf("unexpected params: %v", sanitized)
+	}
+	deviceConfig, ok := sanitized[2].(map[string]any)
+	if !ok || deviceConfig["server"] != "//smb/share" || deviceConfig["cifspassword"] != maskedValue {
+		t.Fatalf("unexpected device config: %v", sanitized[2])
+	}
+}
+
+func TestAuditLogAsyncCall(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+
+	logFile := filepath.Join(t.TempDir(), "api.log")
+	session, err := loginServer(server.URL, "root", "password", &clientConf{APILogFile: logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.Pool.AsyncJoin(session, "192.0.2.1", "root", "coordinator-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "coordinator-password") {
+		t.Fatalf("expected the password of the asynchronous join to be masked, got: %s", content)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	var entry auditEntry
+	err = json.Unmarshal([]byte(lines[len(lines)-1]), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Method != "Async.pool.join" || entry.Params[1] != "192.0.2.1" || entry.Params[3] != maskedValue {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
@@ -0,0 +1,47 @@
+package xenserver
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// cassetteInteraction is one line of a cassette, the request sent to the host
+// and its response. The session references, the credentials and the secrets
+// are scrubbed from both, so that a cassette recorded against a real pool can
+// be attached to a bug report and replayed by the tests.
+type cassetteInteraction struct {
+	Method   string          `json:"method"`
+	Params   []any           `json:"params"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+func newCassetteInteraction(request *xapiRequest, resp *relayResponse) cassetteInteraction {
+	return cassetteInteraction{
+		Method:   request.Method,
+		Params:   sanitizeParams(request.Method, request.Params),
+		Status:   resp.status,
+		Response: scrubResponse(request.Method, resp.body),
+	}
+}
+
+// secretResults are the methods whose result is a secret or holds one.
+var secretResults = []string{"secret.get_value", "secret.get_record", "secret.get_all_records"}
+
+// scrubResponse masks the session reference returned by a login, the values
+// of the secrets and of the map keys that look like passwords or secrets.
+func scrubResponse(method string, body []byte) json.RawMessage {
+	var response map[string]any
+	if json.Unmarshal(body, &response) != nil {
+		return json.RawMessage("null")
+	}
+	_, ok := response["result"]
+	if ok && (slices.Contains(sessionLoginMethods, method) || slices.Contains(secretResults, xapiMethodKey(method))) {
+		response["result"] = maskedValue
+	}
+	scrubbed, err := json.Marshal(maskSecrets(response))
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return scrubbed
+}
@@ -0,0 +1,161 @@
+package xenserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"xenapi"
+)
+
+// cassetteReplayer answers the requests with the responses recorded in a
+// cassette. Requests are matched by method and scrubbed parameters, the
+// responses to the same request being replayed in the recorded order, the
+// last one repeated once all were served, e.g. while polling.
+type cassetteReplayer struct {
+	mu           sync.Mutex
+	interactions map[string][]cassetteInteraction
+	served       map[string]int
+}
+
+func newCassetteReplayer(path string) (*cassetteReplayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	replayer := &cassetteReplayer{interactions: map[string][]cassetteInteraction{}, served: map[string]int{}}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var interaction cassetteInteraction
+		err := json.Unmarshal(scanner.Bytes(), &interaction)
+		if err != nil {
+			return nil, err
+		}
+		key := cassetteKey(interaction.Method, interaction.Params)
+		replayer.interactions[key] = append(replayer.interactions[key], interaction)
+	}
+
+	return replayer, scanner.Err()
+}
+
+func cassetteKey(method string, params []any) string {
+	key, _ := json.Marshal(append([]any{method}, params...))
+	return string(key)
+}
+
+func (c *cassetteReplayer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request xapiRequest
+	if json.NewDecoder(r.Body).Decode(&request) != nil {
+		http.Error(w, "invalid JSON-RPC request", http.StatusBadRequest)
+		return
+	}
+	// normalize the parameters the way they were recorded
+	var params []any
+	paramsJSON, _ := json.Marshal(sanitizeParams(request.Method, request.Params))
+	_ = json.Unmarshal(paramsJSON, &params)
+	key := cassetteKey(request.Method, params)
+
+	c.mu.Lock()
+	interactions := c.interactions[key]
+	index := min(c.served[key], len(interactions)-1)
+	c.served[key]++
+	c.mu.Unlock()
+	if len(interactions) == 0 {
+		http.Error(w, "no recorded interaction for "+key, http.StatusNotFound)
+		return
+	}
+
+	var response map[string]any
+	_ = json.Unmarshal(interactions[index].Response, &response)
+	response["id"] = request.ID
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(interactions[index].Status)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+	cassette := filepath.Join(t.TempDir(), "cassette.jsonl")
+
+	calls := func(session *xenapi.Session) []string {
+		var results []string
+		vmRefs, err := xenapi.VM.GetAll(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, vmRef := range vmRefs {
+			record, err := xenapi.VM.GetRecord(session, vmRef)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, string(vmRef)+" "+record.NameLabel)
+		}
+		secretRef, err := xenapi.Secret.Create(session, xenapi.SecretRecord{Value: "secret-password"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(results, string(secretRef))
+	}
+
+	session, err := loginServer(server.URL, "root", "password", &clientConf{CassetteFile: cassette})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := calls(session)
+
+	content, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret-password") || strings.Contains(string(content), "\"password\"") || strings.Contains(string(content), "OpaqueRef:session") {
+		t.Fatalf("expected the credentials and the sessions to be scrubbed, got: %s", content)
+	}
+
+	replayer, err := newCassetteReplayer(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayServer := httptest.NewServer(replayer)
+	defer replayServer.Close()
+	session, err = loginServer(replayServer.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := calls(session)
+	if strings.Join(replayed, ",") != strings.Join(recorded, ",") {
+		t.Fatalf("expected the replayed results %v to be the recorded ones %v", replayed, recorded)
+	}
+}
+
+func TestCassetteScrubAsyncCall(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+	cassette := filepath.Join(t.TempDir(), "cassette.jsonl")
+
+	session, err := loginServer(server.URL, "root", "password", &clientConf{CassetteFile: cassette})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.Pool.AsyncJoin(session, "192.0.2.1", "root", "coordinator-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "Async.pool.join") || strings.Contains(string(content), "coordinator-password") {
+		t.Fatalf("expected the password of the asynchronous join to be scrubbed, got: %s", content)
+	}
+}
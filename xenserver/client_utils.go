@@ -0,0 +1,422 @@
+package xenserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientConf describes how the provider connects to XenServer hosts. It is
+// shared by the coordinator session and the supporter sessions of pool join.
+type clientConf struct {
+	CACertificate      string
+	CAFile             string
+	ClientCertificate  string
+	ClientKey          string
+	InsecureSkipVerify *bool
+	Fingerprints       []string
+	Retry              retryConf
+	// APILogFile is the path of the audit log of the XAPI calls, if any.
+	APILogFile string
+	// CassetteFile is the path the XAPI traffic is recorded to, if any.
+	CassetteFile string
+	// RecordCacheTTL is how long the results of get_all_records are reused,
+	// 0 disables the cache.
+	RecordCacheTTL time.Duration
+	// ReadOnly refuses the calls which change the pool.
+	ReadOnly bool
+}
+
+// xapiRelay forwards the JSON-RPC requests of one XenServer SDK session to a
+// host. The SDK only accepts the URL of the host, so the session is pointed
+// at this loopback relay instead, which owns the HTTP transport towards the
+// host and therefore the TLS settings of the provider.
+type xapiRelay struct {
+	mu       sync.Mutex
+	upstream string
+	scheme   string
+	token    string
+	client   *http.Client
+	listener net.Listener
+	server   *http.Server
+	session  relaySession
+	retry    retryConf
+	// followCoordinator is set for the coordinator session, which follows
+	// HOST_IS_SLAVE redirects and fails over to the candidates and the pool
+	// members when the coordinator changes.
+	followCoordinator bool
+	candidates        []string
+	// audit records the requests sent to the host when api_log_file is set.
+	audit *jsonLinesFile
+	// cassette records the requests and the responses of the host to replay
+	// them in tests.
+	cassette *jsonLinesFile
+	// records caches the results of get_all_records, nil when disabled.
+	records *recordCache
+	// readOnly refuses the calls which change the pool, see read_only.
+	readOnly bool
+}
+
+// normalizeFingerprint accepts SHA-256 fingerprints with or without colons
+// and in any case, e.g. "AB:CD:..." or "abcd...".
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
+
+func validateClientConf(conf *clientConf) error {
+	if conf.CACertificate != "" && conf.CAFile != "" {
+		return errors.New("only one of ca_certificate and ca_file can be set")
+	}
+	if (conf.ClientCertificate == "") != (conf.ClientKey == "") {
+		return errors.New("client_certificate and client_key must be set together")
+	}
+	if conf.InsecureSkipVerify != nil && *conf.InsecureSkipVerify &&
+		(conf.CACertificate != "" || conf.CAFile != "" || len(conf.Fingerprints) > 0) {
+		return errors.New("insecure_skip_verify cannot be enabled together with ca_certificate, ca_file or certificate_fingerprints")
+	}
+	if conf.Retry.MaxRetries < 0 {
+		return errors.New("max_retries cannot be negative")
+	}
+	if conf.Retry.MaxRetries > 0 && conf.Retry.RetryMaxInterval <= 0 {
+		return errors.New("retry_max_interval must be a positive duration")
+	}
+	for _, fingerprint := range conf.Fingerprints {
+		decoded, err := hex.DecodeString(normalizeFingerprint(fingerprint))
+		if err != nil || len(decoded) != sha256.Size {
+			return errors.New("certificate fingerprint '" + fingerprint + "' is not a valid SHA-256 fingerprint")
+		}
+	}
+
+	return nil
+}
+
+// legacyTLSVerify reports whether none of the TLS settings are configured, in
+// which case the server certificate is not verified, as in earlier versions.
+func legacyTLSVerify(conf *clientConf) bool {
+	return conf.InsecureSkipVerify == nil && conf.CACertificate == "" && conf.CAFile == "" && len(conf.Fingerprints) == 0
+}
+
+func getTLSConfig(conf *clientConf) (*tls.Config, error) {
+	err := validateClientConf(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if conf.ClientCertificate != "" {
+		cert, err := tls.X509KeyPair([]byte(conf.ClientCertificate), []byte(conf.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate. %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	caPEM := []byte(conf.CACertificate)
+	if conf.CAFile != "" {
+		caPEM, err = os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file %s. %w", conf.CAFile, err)
+		}
+	}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no valid PEM certificate found in the CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if legacyTLSVerify(conf) || (conf.InsecureSkipVerify != nil && *conf.InsecureSkipVerify) {
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec // verification is explicitly disabled by the user
+		return tlsConfig, nil
+	}
+
+	if len(conf.Fingerprints) > 0 {
+		fingerprints := make([]string, 0, len(conf.Fingerprints))
+		for _, fingerprint := range conf.Fingerprints {
+			fingerprints = append(fingerprints, normalizeFingerprint(fingerprint))
+		}
+		verifyChain := tlsConfig.RootCAs != nil
+		// The pinned certificate replaces the chain verification unless a CA
+		// is also provided, as XenServer hosts use self-signed certificates
+		// by default.
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec // the peer is verified in VerifyConnection
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server did not present a certificate")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !slices.Contains(fingerprints, hex.EncodeToString(sum[:])) {
+				return errors.New("server certificate fingerprint " + hex.EncodeToString(sum[:]) + " does not match certificate_fingerprints")
+			}
+			if !verifyChain {
+				return nil
+			}
+			opts := x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         tlsConfig.RootCAs,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			if err != nil {
+				return fmt.Errorf("unable to verify server certificate. %w", err)
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func newXAPIRelay(host string, conf *clientConf) (*xapiRelay, error) {
+	tlsConfig, err := getTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	var audit, cassette *jsonLinesFile
+	if conf.APILogFile != "" {
+		audit, err = openJSONLinesFile(conf.APILogFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open the API log file. %w", err)
+		}
+	}
+	if conf.CassetteFile != "" {
+		cassette, err = openJSONLinesFile(conf.CassetteFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open the cassette file. %w", err)
+		}
+	}
+
+	token := make([]byte, 16)
+	_, err = rand.Read(token)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate relay token. %w", err)
+	}
+
+	listener, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to start XAPI relay. %w", err)
+	}
+
+	scheme, _, _ := strings.Cut(host, "://")
+	relay := &xapiRelay{
+		upstream: strings.TrimSuffix(host, "/"),
+		scheme:   scheme,
+		token:    "/" + hex.EncodeToString(token),
+		client:   &http.Client{Transport: transport},
+		listener: listener,
+		retry:    conf.Retry,
+		audit:    audit,
+		cassette: cassette,
+		records:  newRecordCache(conf.RecordCacheTTL),
+		readOnly: conf.ReadOnly,
+	}
+	relay.server = &http.Server{
+		Handler:           relay,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		_ = relay.server.Serve(listener)
+	}()
+
+	return relay, nil
+}
+
+// URL returns the address the SDK session should be created with. The random
+// path prefix keeps other local processes from using the relay.
+func (r *xapiRelay) URL() string {
+	return "http://" + r.listener.Addr().String() + r.token
+}
+
+func (r *xapiRelay) getUpstream() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.upstream
+}
+
+func (r *xapiRelay) setUpstream(upstream string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.upstream = upstream
+}
+
+// hostURL returns the URL of a host address, with the scheme of the relay if
+// the address does not have one, e.g. the address of a HOST_IS_SLAVE error.
+func (r *xapiRelay) hostURL(address string) string {
+	if strings.Contains(address, "://") {
+		return strings.TrimSuffix(address, "/")
+	}
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		address = "[" + address + "]"
+	}
+	return r.scheme + "://" + address
+}
+
+func (r *xapiRelay) Close() error {
+	err := r.server.Close()
+	if err != nil {
+		return fmt.Errorf("unable to stop XAPI relay: %w", err)
+	}
+	return nil
+}
+
+func (r *xapiRelay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path, ok := strings.CutPrefix(req.URL.Path, r.token)
+	if !ok || (path != "" && !strings.HasPrefix(path, "/")) {
+		http.NotFound(w, req)
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := r.call(req.Context(), path, req.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if response := parseXAPIResponse(resp.body); response != nil && response.Error != nil {
+		recordXAPIFailure(response.Error)
+	}
+
+	for key, values := range resp.header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.status)
+	_, _ = w.Write(resp.body)
+}
+
+// relayResponse is the HTTP response of the host to one relayed request.
+type relayResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// send posts the body to the host as is.
+func (r *xapiRelay) send(ctx context.Context, upstream string, path string, header http.Header, body []byte) (*relayResponse, error) {
+	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodPost, upstream+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request to %s: %w", upstream, err)
+	}
+	upstreamReq.Header = header.Clone()
+
+	resp, err := r.client.Do(upstreamReq)
+	if err != nil {
+		// keep the cause to tell dial errors from lost responses
+		return nil, fmt.Errorf("unable to send request to %s: %w", upstream, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response of %s: %w", upstream, err)
+	}
+
+	return &relayResponse{status: resp.StatusCode, header: resp.Header, body: respBody}, nil
+}
+
+// maxImportRedirects bounds the redirects of an import, XAPI redirects it to
+// the host which can access the SR of the VDI.
+const maxImportRedirects = 3
+
+// importRawVDI writes the content of a VDI with the import_raw_vdi handler of
+// XAPI. The handler isn't a JSON-RPC call, so it isn't sent through the SDK
+// but with the transport and the session of the relay.
+func (r *xapiRelay) importRawVDI(ctx context.Context, vdiRef string, content []byte) error {
+	if r.readOnly {
+		return errors.New("unable to import the content of the VDI, " + readOnlyErrorCode)
+	}
+	r.session.mu.Lock()
+	sessionRef := r.session.currentRef
+	r.session.mu.Unlock()
+
+	request, err := newXAPIRequest("VDI.import_raw", sessionRef)
+	if err != nil {
+		return err
+	}
+	vdiParam, err := json.Marshal(vdiRef)
+	if err != nil {
+		return fmt.Errorf("unable to encode VDI ref: %w", err)
+	}
+	request.Params = append(request.Params, vdiParam)
+
+	upstream := r.getUpstream()
+	query := url.Values{"session_id": {sessionRef}, "vdi": {vdiRef}, "format": {"raw"}}
+	target := upstream + "/import_raw_vdi?" + query.Encode()
+	start := time.Now()
+	err = r.putContent(ctx, target, content)
+	if r.audit != nil {
+		r.audit.write(newAuditEntry(upstream, request, start, &relayResponse{status: http.StatusOK}, err))
+	}
+
+	return err
+}
+
+// putContent sends the content to the target, following the redirects with
+// the same method and body.
+func (r *xapiRelay) putContent(ctx context.Context, target string, content []byte) error {
+	client := *r.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	for range maxImportRedirects + 1 {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("unable to create the import request: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("unable to send the content to %s: %w", req.URL.Host, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			location, err := resp.Location()
+			if err != nil {
+				return fmt.Errorf("unable to follow the redirect of the import, %w", err)
+			}
+			target = location.String()
+		default:
+			return errors.New("unable to import the content of the VDI, the host answered " + resp.Status)
+		}
+	}
+
+	return errors.New("unable to import the content of the VDI, too many redirects")
+}
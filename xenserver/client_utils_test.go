@@ -0,0 +1,111 @@
+package xenserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func relayPost(t *testing.T, relay *xapiRelay) (string, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, relay.URL()+"/jsonrpc", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &httpStatusError{resp.StatusCode, string(body)}
+	}
+	return string(body), nil
+}
+
+type httpStatusError struct {
+	code int
+	body string
+}
+
+func (e *httpStatusError) Error() string {
+	return http.StatusText(e.code) + ": " + e.body
+}
+
+func TestXAPIRelayTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.URL.Path + " " + string(body)))
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256(server.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	verify := false
+
+	testCases := []struct {
+		name    string
+		conf    clientConf
+		success bool
+	}{
+		{"legacy", clientConf{}, true},
+		{"verify with system CA", clientConf{InsecureSkipVerify: &verify}, false},
+		{"pinned fingerprint", clientConf{Fingerprints: []string{strings.ToUpper(fingerprint)}}, true},
+		{"wrong fingerprint", clientConf{Fingerprints: []string{strings.Repeat("0", 64)}}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			relay, err := newXAPIRelay(server.URL, &tc.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer relay.Close()
+
+			body, err := relayPost(t, relay)
+			if tc.success && (err != nil || body != "/jsonrpc ping") {
+				t.Fatalf("expected the request to be relayed, got %q, %v", body, err)
+			}
+			if !tc.success && err == nil {
+				t.Fatal("expected the TLS verification to fail")
+			}
+		})
+	}
+}
+
+func TestValidateClientConf(t *testing.T) {
+	insecure := true
+	invalid := []clientConf{
+		{CACertificate: "pem", CAFile: "/ca.pem"},
+		{ClientCertificate: "pem"},
+		{InsecureSkipVerify: &insecure, CAFile: "/ca.pem"},
+		{Fingerprints: []string{"abcd"}},
+	}
+	for _, conf := range invalid {
+		if validateClientConf(&conf) == nil {
+			t.Errorf("expected %+v to be invalid", conf)
+		}
+	}
+}
+
+func TestGetClientConfFingerprints(t *testing.T) {
+	first := strings.Repeat("AA:", 31) + "AA"
+	second := strings.Repeat("CC:", 31) + "DD"
+	t.Setenv("XENSERVER_CERTIFICATE_FINGERPRINTS", first+", "+second+" ,")
+	conf, diags := getClientConf(context.Background(), providerModel{})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if !slices.Equal(conf.Fingerprints, []string{first, second}) {
+		t.Fatalf("expected the trimmed fingerprints, got %q", conf.Fingerprints)
+	}
+}
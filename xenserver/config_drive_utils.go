@@ -0,0 +1,455 @@
+package xenserver
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	"xenapi"
+)
+
+type cloudInitResourceModel struct {
+	UserData        types.String `tfsdk:"user_data"`
+	MetaData        types.String `tfsdk:"meta_data"`
+	NetworkConfig   types.String `tfsdk:"network_config"`
+	SR              types.String `tfsdk:"sr_uuid"`
+	RemoveAfterBoot types.Bool   `tfsdk:"remove_after_boot"`
+}
+
+var cloudInitResourceModelAttrTypes = map[string]attr.Type{
+	"user_data":         types.StringType,
+	"meta_data":         types.StringType,
+	"network_config":    types.StringType,
+	"sr_uuid":           types.StringType,
+	"remove_after_boot": types.BoolType,
+}
+
+func cloudInitSchema() map[string]schema.Attribute {
+	return map[string]schema.Attribute{
+		"user_data": schema.StringAttribute{
+			MarkdownDescription: "The user-data of cloud-init, e.g. a `#cloud-config` document.",
+			Required:            true,
+			Sensitive:           true,
+		},
+		"meta_data": schema.StringAttribute{
+			MarkdownDescription: "The meta-data of cloud-init, default to be the `instance-id` and the `local-hostname` of the virtual machine, i.e. its UUID and its name.",
+			Optional:            true,
+		},
+		"network_config": schema.StringAttribute{
+			MarkdownDescription: "The network configuration of cloud-init, in the version 1 or 2 format.",
+			Optional:            true,
+		},
+		"sr_uuid": schema.StringAttribute{
+			MarkdownDescription: "The UUID of the storage repository of the config drive, default to be the default SR of the pool.",
+			Optional:            true,
+		},
+		"remove_after_boot": schema.BoolAttribute{
+			MarkdownDescription: "Whether to detach and destroy the config drive once the virtual machine has booted, default to be `false`. " +
+				"The boot is detected by the IP address of the virtual machine, so `check_ip_timeout` must be set. " +
+				"The drive is removed at the creation of the virtual machine, or by a later apply when its removal failed.",
+			Optional: true,
+			Computed: true,
+			Default:  booldefault.StaticBool(false),
+		},
+	}
+}
+
+// configDriveSizeUnit is the size unit of the VDI of a config drive, which
+// holds the image rounded up.
+const configDriveSizeUnit = 1024 * 1024
+
+const (
+	isoSectorSize = 2048
+	// the system area, the primary volume descriptor, the terminator and the
+	// path tables come before the root directory
+	isoRootSector = 20
+)
+
+// configDriveLabel is the volume label cloud-init looks for to find the
+// NoCloud data source.
+const configDriveLabel = "cidata"
+
+// configDriveFile is a file of the config drive.
+type configDriveFile struct {
+	Name    string
+	Content []byte
+}
+
+// buildConfigDrive returns a NoCloud config drive, an ISO 9660 image labelled
+// cidata with the files in its root directory, e.g. user-data and meta-data.
+// The names of the files are given by Rock Ridge entries, as the ISO 9660 names
+// are limited to upper case 8.3 names.
+func buildConfigDrive(files []configDriveFile, now time.Time) []byte {
+	files = append([]configDriveFile{}, files...)
+	sort.Slice(files, func(i, j int) bool { return isoFileName(files[i].Name) < isoFileName(files[j].Name) })
+
+	// the root directory fits in one sector for the few files of the drive
+	sector := isoRootSector + 1
+	extents := make([]int, len(files))
+	for i, file := range files {
+		extents[i] = sector
+		sector += (len(file.Content) + isoSectorSize - 1) / isoSectorSize
+	}
+	image := make([]byte, sector*isoSectorSize)
+
+	// the SUSP entry of the root directory announces the Rock Ridge entries
+	records := isoDirectoryRecord([]byte{0}, isoRootSector, isoSectorSize, true, now,
+		append([]byte{'S', 'P', 7, 1, 0xbe, 0xef, 0}, rockRidgePX(0o40555, 2)...))
+	records = append(records, isoDirectoryRecord([]byte{1}, isoRootSector, isoSectorSize, true, now, rockRidgePX(0o40555, 2))...)
+	for i, file := range files {
+		systemUse := append(rockRidgePX(0o100444, 1), 'N', 'M', isoByte(5+len(file.Name)), 1, 0)
+		systemUse = append(systemUse, file.Name...)
+		records = append(records, isoDirectoryRecord([]byte(isoFileName(file.Name)), extents[i], len(file.Content), false, now, systemUse)...)
+		copy(image[extents[i]*isoSectorSize:], file.Content)
+	}
+	copy(image[isoRootSector*isoSectorSize:], records)
+
+	// the path tables have the root directory only, in little and big endian
+	pathTable := []byte{1, 0, 0, 0, 0, 0, 1, 0, 0, 0}
+	binary.LittleEndian.PutUint32(pathTable[2:], isoRootSector)
+	copy(image[18*isoSectorSize:], pathTable)
+	binary.BigEndian.PutUint32(pathTable[2:], isoRootSector)
+	binary.BigEndian.PutUint16(pathTable[6:], 1)
+	copy(image[19*isoSectorSize:], pathTable)
+
+	pvd := image[16*isoSectorSize : 17*isoSectorSize]
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	pvd[6] = 1
+	copy(pvd[8:40], isoPadString("LINUX", 32))
+	copy(pvd[40:72], isoPadString(configDriveLabel, 32))
+	putBothEndian32(pvd[80:], isoUint32(sector))
+	putBothEndian16(pvd[120:], 1)
+	putBothEndian16(pvd[124:], 1)
+	putBothEndian16(pvd[128:], isoSectorSize)
+	putBothEndian32(pvd[132:], isoUint32(len(pathTable)))
+	binary.LittleEndian.PutUint32(pvd[140:], 18)
+	binary.BigEndian.PutUint32(pvd[148:], 19)
+	copy(pvd[156:190], isoDirectoryRecord([]byte{0}, isoRootSector, isoSectorSize, true, now, nil))
+	copy(pvd[190:813], strings.Repeat(" ", 813-190))
+	copy(pvd[813:830], isoVolumeDate(now))
+	copy(pvd[830:847], isoVolumeDate(now))
+	copy(pvd[847:864], isoVolumeDate(time.Time{}))
+	copy(pvd[864:881], isoVolumeDate(time.Time{}))
+	pvd[881] = 1
+
+	terminator := image[17*isoSectorSize : 18*isoSectorSize]
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	return image
+}
+
+// isoFileName returns the ISO 9660 level 1 name of a file, e.g. USER_DAT.;1
+// for user-data.
+func isoFileName(name string) string {
+	base := strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+	if len(base) > 8 {
+		base = base[:8]
+	}
+	return base + ".;1"
+}
+
+func isoDirectoryRecord(identifier []byte, extent int, size int, directory bool, date time.Time, systemUse []byte) []byte {
+	// the fields are padded to start on even offsets
+	systemUseOffset := 33 + len(identifier) + (33+len(identifier))%2
+	length := systemUseOffset + len(systemUse) + len(systemUse)%2
+
+	record := make([]byte, length)
+	record[0] = isoByte(length)
+	putBothEndian32(record[2:], isoUint32(extent))
+	putBothEndian32(record[10:], isoUint32(size))
+	date = date.UTC()
+	record[18] = isoByte(date.Year() - 1900)
+	record[19] = isoByte(int(date.Month()))
+	record[20] = isoByte(date.Day())
+	record[21] = isoByte(date.Hour())
+	record[22] = isoByte(date.Minute())
+	record[23] = isoByte(date.Second())
+	if directory {
+		record[25] = 2
+	}
+	putBothEndian16(record[28:], 1)
+	record[32] = isoByte(len(identifier))
+	copy(record[33:], identifier)
+	copy(record[systemUseOffset:], systemUse)
+
+	return record
+}
+
+// rockRidgePX returns the Rock Ridge entry of the POSIX attributes of a file,
+// which belongs to root.
+func rockRidgePX(mode uint32, links uint32) []byte {
+	px := make([]byte, 36)
+	copy(px, []byte{'P', 'X', 36, 1})
+	putBothEndian32(px[4:], mode)
+	putBothEndian32(px[12:], links)
+	return px
+}
+
+// isoByte returns the value of a one byte field, the records of the drive
+// and the names of its files are short.
+func isoByte(value int) byte {
+	return byte(value) //nolint:gosec // the fields of the drive are small
+}
+
+// isoUint32 returns the value of a four bytes field, the drive is small.
+func isoUint32(value int) uint32 {
+	return uint32(value) //nolint:gosec // the fields of the drive are small
+}
+
+// isoVolumeDate returns a date of the volume descriptor, all zeros when the
+// date isn't set.
+func isoVolumeDate(date time.Time) []byte {
+	if date.IsZero() {
+		return append([]byte(strings.Repeat("0", 16)), 0)
+	}
+	return append([]byte(date.UTC().Format("20060102150405")+"00"), 0)
+}
+
+func isoPadString(value string, length int) string {
+	return value + strings.Repeat(" ", length-len(value))
+}
+
+func putBothEndian16(b []byte, value uint16) {
+	binary.LittleEndian.PutUint16(b, value)
+	binary.BigEndian.PutUint16(b[2:], value)
+}
+
+func putBothEndian32(b []byte, value uint32) {
+	binary.LittleEndian.PutUint32(b, value)
+	binary.BigEndian.PutUint32(b[4:], value)
+}
+
+// getConfigDriveFiles returns the files of the NoCloud data source of the VM.
+func getConfigDriveFiles(cloudInit cloudInitResourceModel, vmUUID string, nameLabel string) []configDriveFile {
+	metaData := cloudInit.MetaData.ValueString()
+	if cloudInit.MetaData.IsNull() {
+		// a JSON string is a YAML string, whatever the characters of the name
+		hostname, _ := json.Marshal(nameLabel)
+		metaData = "instance-id: " + vmUUID + "\nlocal-hostname: " + string(hostname) + "\n"
+	}
+	files := []configDriveFile{
+		{Name: "user-data", Content: []byte(cloudInit.UserData.ValueString())},
+		{Name: "meta-data", Content: []byte(metaData)},
+	}
+	if !cloudInit.NetworkConfig.IsNull() {
+		files = append(files, configDriveFile{Name: "network-config", Content: []byte(cloudInit.NetworkConfig.ValueString())})
+	}
+	return files
+}
+
+// getCloudInit returns the cloud_init attribute of the plan, nil when unset.
+func getCloudInit(ctx context.Context, value basetypes.ObjectValue) (*cloudInitResourceModel, error) {
+	if value.IsNull() || value.IsUnknown() {
+		return nil, nil
+	}
+	var cloudInit cloudInitResourceModel
+	diags := value.As(ctx, &cloudInit, basetypes.ObjectAsOptions{})
+	if diags.HasError() {
+		return nil, errors.New("unable to read VM cloud init")
+	}
+	return &cloudInit, nil
+}
+
+// getConfigDriveSR returns the SR of the config drive, the default SR of the
+// pool unless sr_uuid is set.
+func getConfigDriveSR(session *xenapi.Session, cloudInit cloudInitResourceModel) (xenapi.SRRef, error) {
+	if cloudInit.SR.ValueString() != "" {
+		srRef, err := xenapi.SR.GetByUUID(session, cloudInit.SR.ValueString())
+		if err != nil {
+			return srRef, newXAPIError(err)
+		}
+		return srRef, nil
+	}
+
+	poolRefs, err := xenapi.Pool.GetAll(session)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	if len(poolRefs) == 0 {
+		return "", errors.New("unable to find the pool")
+	}
+	srRef, err := xenapi.Pool.GetDefaultSR(session, poolRefs[0])
+	if err != nil {
+		return srRef, newXAPIError(err)
+	}
+	if string(srRef) == "OpaqueRef:NULL" {
+		return srRef, errors.New("the pool has no default SR, set sr_uuid of cloud_init")
+	}
+	return srRef, nil
+}
+
+// createConfigDrive creates the config drive of the cloud_init attribute and
+// attaches it to the VM as a disk, the CD drive being kept for cdrom. It
+// returns the VBD of the drive, empty when cloud_init is unset. The caller
+// holds the lock of the VM.
+func createConfigDrive(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) (xenapi.VBDRef, error) {
+	cloudInit, err := getCloudInit(ctx, plan.CloudInit)
+	if err != nil || cloudInit == nil {
+		return "", err
+	}
+
+	vmUUID, err := xenapi.VM.GetUUID(session, vmRef)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	srRef, err := getConfigDriveSR(session, *cloudInit)
+	if err != nil {
+		return "", err
+	}
+
+	image := buildConfigDrive(getConfigDriveFiles(*cloudInit, vmUUID, plan.NameLabel.ValueString()), time.Now())
+	tflog.Debug(ctx, "---> Create the config drive of the VM "+vmUUID)
+	vdiRef, err := xenapi.VDI.Create(session, xenapi.VDIRecord{
+		NameLabel:       configDriveLabel + " " + plan.NameLabel.ValueString(),
+		NameDescription: "The cloud-init config drive of the VM " + vmUUID,
+		SR:              srRef,
+		VirtualSize:     (len(image) + configDriveSizeUnit - 1) / configDriveSizeUnit * configDriveSizeUnit,
+		Type:            xenapi.VdiTypeUser,
+		OtherConfig:     map[string]string{},
+	})
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+
+	vbdRef, err := attachConfigDrive(ctx, session, vmRef, vdiRef, image)
+	if err != nil {
+		_ = xenapi.VDI.Destroy(session, vdiRef)
+		return "", err
+	}
+
+	return vbdRef, nil
+}
+
+func attachConfigDrive(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, vdiRef xenapi.VDIRef, image []byte) (xenapi.VBDRef, error) {
+	relay, ok := getSessionRelay(session)
+	if !ok {
+		return "", errors.New("unable to find the relay of the session")
+	}
+	err := relay.importRawVDI(ctx, string(vdiRef), image)
+	if err != nil {
+		return "", err
+	}
+
+	userDevices, err := xenapi.VM.GetAllowedVBDDevices(session, vmRef)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	if len(userDevices) == 0 {
+		return "", errors.New("unable to find available vbd devices to attach to vm " + string(vmRef))
+	}
+	vbdRef, err := xenapi.VBD.Create(session, xenapi.VBDRecord{
+		VM:         vmRef,
+		VDI:        vdiRef,
+		Type:       xenapi.VbdTypeDisk,
+		Mode:       xenapi.VbdModeRW,
+		Bootable:   false,
+		Empty:      false,
+		Userdevice: userDevices[0],
+	})
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+
+	return vbdRef, nil
+}
+
+// removeConfigDrive detaches the config drive from the VM and destroys it.
+func removeConfigDrive(session *xenapi.Session, vbdRef xenapi.VBDRef) error {
+	vbdRecord, err := xenapi.VBD.GetRecord(session, vbdRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	if vbdRecord.CurrentlyAttached {
+		err = xenapi.VBD.Unplug(session, vbdRef)
+		if err != nil {
+			return newXAPIError(err)
+		}
+	}
+	err = xenapi.VBD.Destroy(session, vbdRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	err = xenapi.VDI.Destroy(session, vbdRecord.VDI)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	return nil
+}
+
+// removeConfigDriveAfterBoot removes the config drive of the VM once it has
+// booted, i.e. once its IP address is found, when remove_after_boot is set. A
+// failure is only a warning, the drive stays attached until the next update
+// and is destroyed with the VM. The caller holds the lock of the VM.
+func removeConfigDriveAfterBoot(ctx context.Context, session *xenapi.Session, plan vmResourceModel, vmPrivate *vmPrivateState) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if vmPrivate.ConfigDriveVBD == "" || plan.DefaultIP.ValueString() == "" {
+		return diags
+	}
+	cloudInit, err := getCloudInit(ctx, plan.CloudInit)
+	if err != nil || cloudInit == nil || !cloudInit.RemoveAfterBoot.ValueBool() {
+		return diags
+	}
+
+	err = removeConfigDrive(session, vmPrivate.ConfigDriveVBD)
+	if err != nil {
+		diags.AddAttributeWarning(
+			path.Root("cloud_init"),
+			"Unable to remove the config drive",
+			"The config drive stays attached to the VM and is destroyed with it. "+err.Error(),
+		)
+		return diags
+	}
+	vmPrivate.ConfigDriveVBD = ""
+	return diags
+}
+
+// cloudInitPlanCheck checks the SR of the config drive, and that the boot of
+// the VM can be detected when the drive is removed after it. The SR of the
+// existing VMs is only checked when cloud_init changes, as they are replaced.
+func cloudInitPlanCheck(ctx context.Context, session *xenapi.Session, plan vmResourceModel, state *vmResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	cloudInit, err := getCloudInit(ctx, plan.CloudInit)
+	if err != nil {
+		diags.AddAttributeError(path.Root("cloud_init"), "Invalid cloud init", err.Error())
+		return diags
+	}
+	if cloudInit == nil {
+		return diags
+	}
+	if cloudInit.RemoveAfterBoot.ValueBool() && !plan.CheckIPTimeout.IsUnknown() && plan.CheckIPTimeout.ValueInt64() == 0 {
+		diags.AddAttributeError(path.Root("cloud_init").AtName("remove_after_boot"), "Unable to detect the boot of the VM",
+			"remove_after_boot removes the config drive once the VM has an IP address, set check_ip_timeout to wait for it.")
+	}
+	if state != nil && plan.CloudInit.Equal(state.CloudInit) {
+		return diags
+	}
+	if !cloudInit.SR.IsUnknown() {
+		_, err = getConfigDriveSR(session, *cloudInit)
+		if err != nil {
+			diags.AddAttributeError(path.Root("cloud_init").AtName("sr_uuid"), "Invalid config drive SR", err.Error())
+		}
+	}
+
+	return diags
+}
@@ -0,0 +1,182 @@
+package xenserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"maps"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"xenapi"
+)
+
+// readConfigDrive returns the files of the root directory of a config drive
+// by their Rock Ridge name.
+func readConfigDrive(t *testing.T, image []byte) map[string][]byte {
+	t.Helper()
+	pvd := image[16*isoSectorSize:]
+	if pvd[0] != 1 || string(pvd[1:6]) != "CD001" {
+		t.Fatalf("expected a primary volume descriptor, got %q", pvd[:6])
+	}
+	if label := string(bytes.TrimRight(pvd[40:72], " ")); label != configDriveLabel {
+		t.Fatalf("expected the label %s, got %q", configDriveLabel, label)
+	}
+	if size := int(binary.LittleEndian.Uint32(pvd[80:])) * isoSectorSize; size != len(image) {
+		t.Fatalf("expected the volume size %d, got %d", len(image), size)
+	}
+
+	root := pvd[156:]
+	extent := int(binary.LittleEndian.Uint32(root[2:]))
+	directory := image[extent*isoSectorSize : (extent+1)*isoSectorSize]
+	files := map[string][]byte{}
+	for offset := 0; offset < len(directory) && directory[offset] != 0; offset += int(directory[offset]) {
+		record := directory[offset : offset+int(directory[offset])]
+		if record[25]&2 != 0 {
+			continue
+		}
+		nameLength := int(record[32])
+		systemUse := record[33+nameLength+(33+nameLength)%2:]
+		name := ""
+		for len(systemUse) >= 4 && systemUse[2] > 0 {
+			if string(systemUse[:2]) == "NM" {
+				name = string(systemUse[5:systemUse[2]])
+			}
+			systemUse = systemUse[systemUse[2]:]
+		}
+		start := int(binary.LittleEndian.Uint32(record[2:])) * isoSectorSize
+		files[name] = image[start : start+int(binary.LittleEndian.Uint32(record[10:]))]
+	}
+	return files
+}
+
+func TestBuildConfigDrive(t *testing.T) {
+	userData := bytes.Repeat([]byte("#cloud-config\n"), 200)
+	image := buildConfigDrive([]configDriveFile{
+		{Name: "user-data", Content: userData},
+		{Name: "meta-data", Content: []byte("instance-id: vm\n")},
+		{Name: "network-config", Content: []byte("version: 2\n")},
+	}, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	files := readConfigDrive(t, image)
+	if len(files) != 3 || !bytes.Equal(files["user-data"], userData) ||
+		string(files["meta-data"]) != "instance-id: vm\n" || string(files["network-config"]) != "version: 2\n" {
+		t.Fatalf("unexpected files of the config drive: %q", slices.Sorted(maps.Keys(files)))
+	}
+}
+
+func TestIsoFileName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"user-data":      "USER_DAT.;1",
+		"meta-data":      "META_DAT.;1",
+		"network-config": "NETWORK_.;1",
+	} {
+		if isoFileName(name) != expected {
+			t.Errorf("expected %s for %s, got %s", expected, name, isoFileName(name))
+		}
+	}
+}
+
+func TestConfigDrive(t *testing.T) {
+	fake := newFakeXAPI("root", "password")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	templateRef, err := getFirstTemplate(session, "Debian Bullseye 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloudInit := types.ObjectValueMust(cloudInitResourceModelAttrTypes, map[string]attr.Value{
+		"user_data":         types.StringValue("#cloud-config\n"),
+		"meta_data":         types.StringNull(),
+		"network_config":    types.StringNull(),
+		"sr_uuid":           types.StringNull(),
+		"remove_after_boot": types.BoolValue(true),
+	})
+	plan := vmResourceModel{NameLabel: types.StringValue("web"), CloudInit: cloudInit, CheckIPTimeout: types.Int64Value(0)}
+
+	diags := cloudInitPlanCheck(ctx, session, plan, nil)
+	if diags.ErrorsCount() != 1 {
+		t.Fatalf("expected the error of remove_after_boot, got: %v", diags)
+	}
+	// the VM created without cloud_init is replaced
+	diags = cloudInitPlanCheck(ctx, session, plan, &vmResourceModel{CloudInit: types.ObjectNull(cloudInitResourceModelAttrTypes)})
+	if diags.ErrorsCount() != 1 {
+		t.Fatalf("expected the error of remove_after_boot, got: %v", diags)
+	}
+	// whether or not cloud_init changes
+	diags = cloudInitPlanCheck(ctx, session, plan, &plan)
+	if diags.ErrorsCount() != 1 {
+		t.Fatalf("expected the error of remove_after_boot for the unchanged cloud_init, got: %v", diags)
+	}
+	plan.CheckIPTimeout = types.Int64Value(60)
+	diags = cloudInitPlanCheck(ctx, session, plan, nil)
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostic, got: %v", diags)
+	}
+
+	vbdRef, err := createConfigDrive(ctx, session, vmRef, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRef, err := xenapi.VBD.GetVDI(session, vbdRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmUUID, err := xenapi.VM.GetUUID(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	content := fake.contents[string(vdiRef)]
+	fake.mu.Unlock()
+	files := readConfigDrive(t, content)
+	if string(files["meta-data"]) != "instance-id: "+vmUUID+"\nlocal-hostname: \"web\"\n" || string(files["user-data"]) != "#cloud-config\n" {
+		t.Fatalf("unexpected files of the config drive: %q", files)
+	}
+	// the name is quoted in the YAML of the meta-data
+	metaData := getConfigDriveFiles(cloudInitResourceModel{MetaData: types.StringNull()}, vmUUID, "web: #1 \"a\"")[1]
+	if string(metaData.Content) != "instance-id: "+vmUUID+"\nlocal-hostname: \"web: #1 \\\"a\\\"\"\n" {
+		t.Fatalf("unexpected meta-data: %q", metaData.Content)
+	}
+
+	// the config drive isn't a hard drive of the VM
+	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmPrivate := vmPrivateState{ConfigDriveVBD: vbdRef}
+	_, vbds, err := getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeDisk, vmPrivate.unmanagedVBDs(), nil)
+	if err != nil || len(vbds) != 0 {
+		t.Fatalf("expected no hard drive, got %v: %v", vbds, err)
+	}
+
+	// the drive is kept until the IP address of the VM is found
+	plan.DefaultIP = types.StringValue("")
+	diags = removeConfigDriveAfterBoot(ctx, session, plan, &vmPrivate)
+	if len(diags) != 0 || vmPrivate.ConfigDriveVBD != vbdRef {
+		t.Fatalf("expected the config drive to be kept, got %v: %v", vmPrivate, diags)
+	}
+	plan.DefaultIP = types.StringValue("192.0.2.20")
+	diags = removeConfigDriveAfterBoot(ctx, session, plan, &vmPrivate)
+	if len(diags) != 0 || vmPrivate.ConfigDriveVBD != "" {
+		t.Fatalf("expected the config drive to be removed, got %v: %v", vmPrivate, diags)
+	}
+	if _, err = xenapi.VDI.GetRecord(session, vdiRef); err == nil {
+		t.Fatal("expected the VDI of the config drive to be destroyed")
+	}
+}
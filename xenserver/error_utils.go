@@ -0,0 +1,294 @@
+package xenserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+)
+
+// xapiErrorPattern matches the failure code in the error messages of the SDK,
+// e.g. "API error: code 1, message VM_BAD_POWER_STATE, data [OpaqueRef:...
+// halted running]".
+var xapiErrorPattern = regexp.MustCompile(`API error: code -?[0-9]+, message ([A-Z][A-Z0-9_]*), data `)
+
+// xapiError is a failure reported by XAPI, its code and its parameters, and the
+// error reporting it, e.g. the error of the SDK. The parameters are nil when
+// they are unknown.
+type xapiError struct {
+	Code   string
+	Params []string
+	err    error
+}
+
+func (e *xapiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *xapiError) Unwrap() error {
+	return e.err
+}
+
+// newXAPIError returns the error of a XAPI call, a *xapiError when XAPI
+// reported a failure. The SDK doesn't export the type of its errors, so the
+// failure code is read from the message. The parameters are printed separated
+// by spaces, which is ambiguous when one of them contains a space, so they are
+// taken from the failures recorded by the relay instead. The utils wrap the
+// returned error with %w so that the failure stays typed.
+func newXAPIError(err error) error {
+	var failure *xapiError
+	if err == nil || errors.As(err, &failure) {
+		return err
+	}
+	match := xapiErrorPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	return &xapiError{Code: match[1], Params: lookupXAPIFailureParams(err.Error()), err: err}
+}
+
+// maxRecordedFailures bounds the failures kept by recordXAPIFailure, a failure
+// is read right after the SDK returned it.
+const maxRecordedFailures = 64
+
+// recordedFailures are the parameters of the last failures relayed to the SDK,
+// by the message of the SDK error reporting them.
+var recordedFailures = struct {
+	mu       sync.Mutex
+	params   map[string][]string
+	messages []string
+}{params: map[string][]string{}}
+
+// recordXAPIFailure keeps the parameters of a failure relayed to the SDK, so
+// that newXAPIError finds them from the message of the SDK error.
+func recordXAPIFailure(failure *xapiErrorObject) {
+	var data any
+	var params []string
+	if json.Unmarshal(failure.Data, &data) != nil || json.Unmarshal(failure.Data, &params) != nil {
+		return
+	}
+	// the message of the SDK error, see xapiErrorPattern
+	message := fmt.Sprintf("API error: code %d, message %s, data %v", failure.Code, failure.Message, data)
+
+	recordedFailures.mu.Lock()
+	defer recordedFailures.mu.Unlock()
+	if _, ok := recordedFailures.params[message]; !ok {
+		recordedFailures.messages = append(recordedFailures.messages, message)
+		if len(recordedFailures.messages) > maxRecordedFailures {
+			delete(recordedFailures.params, recordedFailures.messages[0])
+			recordedFailures.messages = recordedFailures.messages[1:]
+		}
+	}
+	recordedFailures.params[message] = params
+}
+
+// lookupXAPIFailureParams returns the parameters of the recorded failure the
+// error message reports, the latest first, or nil when none is found.
+func lookupXAPIFailureParams(message string) []string {
+	recordedFailures.mu.Lock()
+	defer recordedFailures.mu.Unlock()
+	for _, recorded := range slices.Backward(recordedFailures.messages) {
+		if strings.Contains(message, recorded) {
+			return slices.Clone(recordedFailures.params[recorded])
+		}
+	}
+	return nil
+}
+
+// asXAPIError returns the XAPI failure of an error, e.g. of a util wrapping
+// the error of a XAPI call.
+func asXAPIError(err error) (*xapiError, bool) {
+	var failure *xapiError
+	ok := errors.As(newXAPIError(err), &failure)
+	return failure, ok
+}
+
+// isXAPIError reports whether the error is the XAPI failure of the code.
+func isXAPIError(err error, code string) bool {
+	failure, ok := asXAPIError(err)
+	return ok && failure.Code == code
+}
+
+// xapiErrorHint explains a failure code to the users.
+type xapiErrorHint struct {
+	Summary string
+	Hint    string
+	// Attributes are the resource attributes the failure usually comes from,
+	// in order of likelihood.
+	Attributes []string
+}
+
+var xapiErrorHints = map[string]xapiErrorHint{
+	"HANDLE_INVALID": {
+		Summary: "an object used by the resource doesn't exist anymore",
+		Hint:    "It was probably deleted outside of Terraform. Run `terraform apply -refresh-only` to refresh the state, or remove the resource from the state.",
+	},
+	"UUID_INVALID": {
+		Summary:    "no object has the given UUID",
+		Hint:       "Check the UUIDs of the configuration, e.g. with the data sources of the provider.",
+		Attributes: []string{"sr_uuid", "vm_uuid", "hard_drive", "network_interface", "default_sr", "management_network", "host"},
+	},
+	"SESSION_AUTHENTICATION_FAILED": {
+		Summary: "the authentication failed",
+		Hint:    "Check the username and password of the provider.",
+	},
+	"SESSION_INVALID": {
+		Summary: "the session isn't valid anymore",
+		Hint:    "Check that the session_id of the provider is still logged in, or use a username and password.",
+	},
+	"RBAC_PERMISSION_DENIED": {
+		Summary: "the user isn't allowed to do the operation",
+		Hint:    "Use an account with a role allowing the operation, e.g. Pool Admin.",
+	},
+	"PERMISSION_DENIED": {
+		Summary: "the user isn't allowed to do the operation",
+		Hint:    "Use an account with a role allowing the operation, e.g. Pool Admin.",
+	},
+	readOnlyErrorCode: {
+		Summary: "the provider is in read-only mode",
+		Hint:    "Unset read_only of the provider to change the pool.",
+	},
+	"LICENCE_RESTRICTION": {
+		Summary: "the license of the pool doesn't allow the operation",
+		Hint:    "Apply a license to the hosts of the pool which includes the feature.",
+	},
+	"OTHER_OPERATION_IN_PROGRESS": {
+		Summary: "another operation is running on the object",
+		Hint:    "Apply again once the operation is finished, or increase max_retries of the provider.",
+	},
+	"HOST_OFFLINE": {
+		Summary:    "a host of the pool is offline",
+		Hint:       "Bring the host back online or remove it from the pool, then apply again.",
+		Attributes: []string{"resident_host", "affinity_host"},
+	},
+	"HOST_DISABLED": {
+		Summary:    "the host is disabled",
+		Hint:       "Enable the host, e.g. once its maintenance is over, or choose another host.",
+		Attributes: []string{"resident_host", "affinity_host"},
+	},
+	"VM_BAD_POWER_STATE": {
+		Summary:    "the VM isn't in the power state the operation requires",
+		Hint:       "The parameters give the expected and the actual power state. Start or shut down the VM, e.g. a snapshot with memory requires a running VM.",
+		Attributes: []string{"with_memory", "power_state", "check_ip_timeout"},
+	},
+	"VM_MISSING_PV_DRIVERS": {
+		Summary:    "the VM tools aren't running in the VM",
+		Hint:       "Install the XenServer VM Tools in the VM and check that they are running.",
+		Attributes: []string{"with_memory", "power_state", "check_ip_timeout"},
+	},
+	"VM_LACKS_FEATURE": {
+		Summary:    "the VM tools don't support the operation",
+		Hint:       "Install or update the XenServer VM Tools in the VM.",
+		Attributes: []string{"with_memory"},
+	},
+	"HOST_NOT_ENOUGH_FREE_MEMORY": {
+		Summary:    "no host has enough free memory for the VM",
+		Hint:       "Reduce the memory of the VM or free some memory on the hosts, e.g. by shutting down other VMs.",
+		Attributes: []string{"dynamic_mem_min", "static_mem_max", "resident_host"},
+	},
+	"VM_REQUIRES_SR": {
+		Summary:    "the host can't access a storage repository of the VM disks",
+		Hint:       "Use disks on a shared SR or plug the SR on the host.",
+		Attributes: []string{"hard_drive", "resident_host"},
+	},
+	"VM_REQUIRES_NETWORK": {
+		Summary:    "the host can't access a network of the VM",
+		Hint:       "Use networks available on the host, e.g. of a NIC of every host of the pool.",
+		Attributes: []string{"network_interface", "resident_host"},
+	},
+	"VDI_IN_USE": {
+		Summary:    "the disk is in use",
+		Hint:       "Detach the VDI from the other VMs or shut them down, then apply again.",
+		Attributes: []string{"hard_drive", "vdi_uuid"},
+	},
+	"VDI_NEEDS_VM_FOR_MIGRATE": {
+		Summary:    "the disk can only be migrated live while it is attached to a running VM",
+		Hint:       "Start the VM of the disk, or shut it down so that the disk is copied to the SR, then apply again.",
+		Attributes: []string{"sr_uuid"},
+	},
+	"SR_OPERATION_NOT_SUPPORTED": {
+		Summary:    "the storage repository doesn't support the operation",
+		Hint:       "Shut down the VM to resize its disk offline, or use a disk on another SR.",
+		Attributes: []string{"virtual_size", "hard_drive"},
+	},
+	"SR_FULL": {
+		Summary:    "the storage repository is full",
+		Hint:       "Free some space on the SR or use another SR.",
+		Attributes: []string{"virtual_size", "sr_uuid", "sr_for_full_disk_copy"},
+	},
+	"SR_BACKEND_FAILURE": {
+		Summary:    "the storage backend failed",
+		Hint:       "Check that the storage is reachable from the hosts and that its configuration is correct. The parameters give the error of the storage backend.",
+		Attributes: []string{"device_config", "storage_location", "sr_uuid"},
+	},
+	"SR_UNKNOWN_DRIVER": {
+		Summary:    "the type of the storage repository isn't supported",
+		Hint:       "Use one of the SR types supported by the hosts of the pool.",
+		Attributes: []string{"type"},
+	},
+	"MAC_INVALID": {
+		Summary:    "the MAC address is invalid",
+		Hint:       "Use a MAC address such as 11:22:33:44:55:66.",
+		Attributes: []string{"network_interface"},
+	},
+	"DEVICE_ALREADY_EXISTS": {
+		Summary:    "the device number is already used by the VM",
+		Hint:       "Use a different device number for each network interface.",
+		Attributes: []string{"network_interface", "hard_drive"},
+	},
+	"PIF_VLAN_EXISTS": {
+		Summary:    "a VLAN with this tag already exists on the NIC",
+		Hint:       "Use another VLAN tag, or import the existing VLAN network.",
+		Attributes: []string{"vlan_tag"},
+	},
+	"VLAN_TAG_INVALID": {
+		Summary:    "the VLAN tag is invalid",
+		Hint:       "Use a VLAN tag between 0 and 4094.",
+		Attributes: []string{"vlan_tag"},
+	},
+	"JOINING_HOST_CANNOT_CONTAIN_SHARED_SRS": {
+		Summary:    "the joining host has shared storage repositories",
+		Hint:       "Detach the shared SRs of the host before it joins the pool.",
+		Attributes: []string{"join_supporters"},
+	},
+}
+
+// lookupXAPIErrorHint returns the hint of a failure code. The codes of the
+// storage backends have a numeric suffix, e.g. SR_BACKEND_FAILURE_73.
+func lookupXAPIErrorHint(code string) (xapiErrorHint, bool) {
+	if strings.HasPrefix(code, "SR_BACKEND_FAILURE") {
+		code = "SR_BACKEND_FAILURE"
+	}
+	hint, ok := xapiErrorHints[code]
+	return hint, ok
+}
+
+// xapiErrorDiagnostic returns the diagnostic of a failed operation. The known
+// XAPI failures get a summary and a remediation hint, and are reported on the
+// attribute they come from when it is one of the given attributes of the
+// operation.
+func xapiErrorDiagnostic(summary string, err error, attributes ...string) diag.Diagnostic {
+	failure, ok := asXAPIError(err)
+	if !ok {
+		return diag.NewErrorDiagnostic(summary, err.Error())
+	}
+	hint, ok := lookupXAPIErrorHint(failure.Code)
+	if !ok {
+		return diag.NewErrorDiagnostic(summary, err.Error())
+	}
+
+	summary = summary + ", " + hint.Summary
+	detail := hint.Hint + "\n\n" + err.Error()
+	for _, attribute := range hint.Attributes {
+		if slices.Contains(attributes, attribute) {
+			return diag.NewAttributeErrorDiagnostic(path.Root(attribute), summary, detail)
+		}
+	}
+	return diag.NewErrorDiagnostic(summary, detail)
+}
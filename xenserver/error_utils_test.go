@@ -0,0 +1,80 @@
+package xenserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+
+	"xenapi"
+)
+
+func TestNewXAPIError(t *testing.T) {
+	// the parameters aren't read from the message, where they are ambiguous
+	failure, ok := asXAPIError(newXAPIError(errors.New("API error: code 1, message VM_BAD_POWER_STATE, data [OpaqueRef:vm halted running]")))
+	if !ok || failure.Code != "VM_BAD_POWER_STATE" || failure.Params != nil {
+		t.Fatalf("unexpected failure: %+v", failure)
+	}
+	// but from the failure relayed to the SDK
+	recordXAPIFailure(&xapiErrorObject{Code: 1, Message: "SR_BACKEND_FAILURE_111", Data: json.RawMessage(`["", "mount error", "OpaqueRef:sr"]`)})
+	failure, ok = asXAPIError(fmt.Errorf("unable to create SR. %w", errors.New("API error: code 1, message SR_BACKEND_FAILURE_111, data [ mount error OpaqueRef:sr]")))
+	if !ok || failure.Code != "SR_BACKEND_FAILURE_111" || !slices.Equal(failure.Params, []string{"", "mount error", "OpaqueRef:sr"}) {
+		t.Fatalf("unexpected failure: %+v", failure)
+	}
+	failure, ok = asXAPIError(newTaskError(xenapi.TaskRecord{NameLabel: "Async.SR.create", ErrorInfo: []string{"SR_BACKEND_FAILURE_73", "", "NFS mount error"}}))
+	if !ok || failure.Code != "SR_BACKEND_FAILURE_73" || len(failure.Params) != 2 || failure.Params[1] != "NFS mount error" {
+		t.Fatalf("unexpected failure: %+v", failure)
+	}
+	err := errors.New("unable to get VM record")
+	if newXAPIError(err) != err {
+		t.Fatal("expected the error unchanged")
+	}
+
+	// the failure stays typed through the utils wrapping it
+	wrapped := fmt.Errorf("unable to resize VDI. %w", newXAPIError(errors.New("API error: code 1, message SR_OPERATION_NOT_SUPPORTED, data [OpaqueRef:sr]")))
+	if !isXAPIError(wrapped, "SR_OPERATION_NOT_SUPPORTED") || isXAPIError(wrapped, "SR_FULL") {
+		t.Fatalf("expected the failure of the wrapped error: %v", wrapped)
+	}
+	if newXAPIError(wrapped) != wrapped {
+		t.Fatal("expected the typed error unchanged")
+	}
+
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+	_, err = loginServer(server.URL, "root", "wrong", &clientConf{})
+	failure, ok = asXAPIError(err)
+	if !ok || failure.Code != "SESSION_AUTHENTICATION_FAILED" || !slices.Equal(failure.Params, []string{"Authentication failure"}) {
+		t.Fatalf("unexpected failure of the login: %+v", failure)
+	}
+}
+
+func TestXAPIErrorDiagnostic(t *testing.T) {
+	err := errors.New("API error: code 1, message SR_BACKEND_FAILURE_73, data [ NFS mount error]")
+	diagnostic := xapiErrorDiagnostic("Unable to create SR", err, "storage_location")
+	withPath, ok := diagnostic.(diag.DiagnosticWithPath)
+	if !ok || !withPath.Path().Equal(path.Root("storage_location")) {
+		t.Fatalf("expected an attribute diagnostic, got: %v", diagnostic)
+	}
+	if !strings.HasPrefix(diagnostic.Summary(), "Unable to create SR, ") || !strings.HasSuffix(diagnostic.Detail(), err.Error()) {
+		t.Fatalf("unexpected diagnostic: %s: %s", diagnostic.Summary(), diagnostic.Detail())
+	}
+
+	// the typed failure is found whatever the message of the error
+	err = fmt.Errorf("unable to create SR. %w", &xapiError{Code: "SR_FULL", err: errors.New("no space left")})
+	diagnostic = xapiErrorDiagnostic("Unable to create SR", err, "sr_uuid")
+	withPath, ok = diagnostic.(diag.DiagnosticWithPath)
+	if !ok || !withPath.Path().Equal(path.Root("sr_uuid")) || diagnostic.Summary() != "Unable to create SR, the storage repository is full" {
+		t.Fatalf("expected the diagnostic of SR_FULL, got: %s", diagnostic.Summary())
+	}
+
+	diagnostic = xapiErrorDiagnostic("Unable to get VM record", errors.New("API error: code 1, message UNKNOWN_FAILURE, data []"), "hard_drive")
+	if _, ok := diagnostic.(diag.DiagnosticWithPath); ok || diagnostic.Summary() != "Unable to get VM record" {
+		t.Fatalf("expected the error unchanged, got: %s", diagnostic.Summary())
+	}
+}
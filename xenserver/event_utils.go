@@ -0,0 +1,47 @@
+package xenserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"xenapi"
+)
+
+// eventFromTimeout is the longest time a call to event.from blocks on the
+// coordinator when nothing changes.
+const eventFromTimeout = 30 * time.Second
+
+// waitForEvents waits until check reports that the awaited condition is met.
+// Instead of polling, check runs again each time XAPI reports a change of the
+// objects it returns the subscriptions of, e.g. "pif/OpaqueRef:..." for one
+// object or "host" for all the objects of a class. The wait is bounded by the
+// context.
+func waitForEvents(ctx context.Context, session *xenapi.Session, check func() (bool, []string, error)) error {
+	token := ""
+	for {
+		done, classes, err := check()
+		if err != nil || done {
+			return err
+		}
+		if ctx.Err() != nil {
+			return errors.New("operation timed out. " + ctx.Err().Error())
+		}
+
+		timeout := eventFromTimeout
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			timeout = max(time.Until(deadline), time.Second)
+		}
+		batch, err := xenapi.Event.From(session, classes, token, timeout.Seconds())
+		if err != nil {
+			if isXAPIError(err, "EVENTS_LOST") {
+				// the token is too old, start again from the current state
+				token = ""
+				continue
+			}
+			return fmt.Errorf("unable to wait for XAPI events. %w", err)
+		}
+		token = batch.Token
+	}
+}
@@ -0,0 +1,1011 @@
+package xenserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xenapi"
+)
+
+const fakeNullRef = "OpaqueRef:NULL"
+
+// fakeXAPI is an in-memory stand-in for the XAPI JSON-RPC endpoint of a
+// standalone host, so that the provider can be tested without a XenServer
+// pool. It keeps the records of the objects the provider manages and
+// implements the calls the provider makes: the generic getters and setters
+// of the record fields and the operations linking the objects together, e.g.
+// VBD.create adding the VBD to its VM and VDI. Operations complete at once,
+// the asynchronous ones through a task that has already succeeded.
+type fakeXAPI struct {
+	mu       sync.Mutex
+	username string
+	password string
+	sessions map[string]bool
+	// objects are the records by lower case class name and reference, with
+	// the field names and values of the JSON-RPC protocol.
+	objects map[string]map[string]map[string]any
+	serial  int
+	// generation counts the changes of the objects, it is the token of
+	// event.from, which returns once the objects changed since the token.
+	generation int
+	changed    chan struct{}
+	// contents are the contents of the VDIs written by import_raw_vdi.
+	contents map[string][]byte
+	// faults are the failures of the calls by lower case method, e.g. to
+	// test the fallbacks of the provider.
+	faults map[string]*fakeXAPIError
+}
+
+type fakeXAPIError struct {
+	code   string
+	params []any
+	// once removes the fault after the first failure, e.g. to test the
+	// recovery of the provider.
+	once bool
+}
+
+func (e *fakeXAPIError) Error() string {
+	return e.code
+}
+
+func fakeError(code string, params ...any) *fakeXAPIError {
+	return &fakeXAPIError{code: code, params: params}
+}
+
+// newFakeXAPI returns a host with the objects of a fresh installation: the
+// pool, the host, its management and second physical network, the local
+// storage and a few VM templates.
+func newFakeXAPI(username string, password string) *fakeXAPI {
+	x := &fakeXAPI{
+		username: username,
+		password: password,
+		sessions: map[string]bool{},
+		objects:  map[string]map[string]map[string]any{},
+		changed:  make(chan struct{}),
+		contents: map[string][]byte{},
+		faults:   map[string]*fakeXAPIError{},
+	}
+
+	hostMetrics := x.add("host_metrics", map[string]any{"live": true, "memory_total": 68719476736, "memory_free": 64424509440})
+	host := x.add("host", map[string]any{
+		"name_label":        "xenserver-fake",
+		"hostname":          "xenserver-fake",
+		"address":           "127.0.0.1",
+		"enabled":           true,
+		"metrics":           hostMetrics,
+		"API_version_major": 2,
+		"software_version":  map[string]any{"product_version": "8.4.0"},
+	})
+	x.add("pool", map[string]any{"name_label": "", "master": host})
+	controlDomain := x.add("VM", map[string]any{"name_label": "Control domain on host: xenserver-fake", "is_control_domain": true, "power_state": "Running", "resident_on": host})
+	x.set(host, "control_domain", controlDomain)
+
+	for i, device := range []string{"eth0", "eth1"} {
+		network := x.add("network", map[string]any{"name_label": "Pool-wide network associated with " + device, "bridge": "xenbr" + strconv.Itoa(i), "MTU": 1500})
+		pifMetrics := x.add("PIF_metrics", map[string]any{"carrier": true})
+		pif := x.add("PIF", map[string]any{
+			"device":                device,
+			"network":               network,
+			"host":                  host,
+			"MAC":                   fmt.Sprintf("00:16:3e:00:00:%02x", i),
+			"MTU":                   1500,
+			"VLAN":                  -1,
+			"metrics":               pifMetrics,
+			"physical":              true,
+			"currently_attached":    true,
+			"management":            i == 0,
+			"ip_configuration_mode": "None",
+		})
+		if i == 0 {
+			x.set(pif, "ip_configuration_mode", "DHCP")
+			x.set(pif, "IP", "127.0.0.1")
+			x.set(pif, "netmask", "255.255.255.0")
+		}
+		x.link(network, "PIFs", pif)
+		x.link(host, "PIFs", pif)
+	}
+
+	sr := x.createSR(host, map[string]any{"device": "/dev/sda3"}, 536870912000, "Local storage", "", "ext", "user", false)
+	x.set(x.ref("pool"), "default_SR", sr)
+
+	for _, name := range []string{"Windows 11", "Debian Bullseye 11", "Other install media"} {
+		x.add("VM", map[string]any{
+			"name_label":          name,
+			"is_a_template":       true,
+			"is_default_template": true,
+			"HVM_boot_policy":     "BIOS order",
+			"HVM_boot_params":     map[string]any{"order": "cdn"},
+			"platform":            map[string]any{"device-model": "qemu-upstream-compat"},
+			"memory_static_max":   4294967296,
+			"memory_dynamic_max":  4294967296,
+			"memory_dynamic_min":  4294967296,
+			"memory_static_min":   4294967296,
+			"VCPUs_max":           2,
+			"VCPUs_at_startup":    2,
+		})
+	}
+
+	return x
+}
+
+// fakeDefaults are the fields every new record of a class gets, the record
+// given at creation may override them.
+var fakeDefaults = map[string]map[string]any{
+	"vm": {
+		"power_state": "Halted", "is_a_template": false, "is_default_template": false, "is_a_snapshot": false,
+		"is_control_domain": false, "snapshot_of": fakeNullRef, "snapshots": []any{}, "resident_on": fakeNullRef,
+		"affinity": fakeNullRef, "suspend_SR": fakeNullRef, "guest_metrics": fakeNullRef, "metrics": fakeNullRef,
+		"VBDs": []any{}, "VIFs": []any{}, "platform": map[string]any{}, "HVM_boot_params": map[string]any{},
+		"VCPUs_params": map[string]any{}, "xenstore_data": map[string]any{}, "blocked_operations": map[string]any{},
+		"allowed_operations": []any{}, "tags": []any{}, "domain_type": "hvm",
+	},
+	"vbd":     {"VM": fakeNullRef, "VDI": fakeNullRef, "empty": false, "currently_attached": false, "bootable": false, "mode": "RW", "type": "Disk", "device": "", "userdevice": "0"},
+	"vdi":     {"SR": fakeNullRef, "VBDs": []any{}, "sm_config": map[string]any{}, "allowed_operations": []any{"clone", "copy", "destroy", "resize", "snapshot"}, "is_a_snapshot": false, "snapshot_of": fakeNullRef, "sharable": false, "read_only": false, "type": "user", "physical_utilisation": 0},
+	"vif":     {"VM": fakeNullRef, "network": fakeNullRef, "currently_attached": false, "MTU": 1500, "locking_mode": "network_default"},
+	"network": {"PIFs": []any{}, "VIFs": []any{}, "MTU": 1500, "bridge": "", "managed": true, "tags": []any{}},
+	"sr":      {"VDIs": []any{}, "PBDs": []any{}, "sm_config": map[string]any{}, "tags": []any{}, "physical_utilisation": 0, "virtual_allocation": 0},
+	"pbd":     {"device_config": map[string]any{}, "currently_attached": false},
+	"pif":     {"VLAN_slave_of": []any{}, "VLAN_master_of": fakeNullRef, "bond_slave_of": fakeNullRef, "bond_master_of": []any{}, "IP": "", "netmask": "", "gateway": "", "DNS": "", "IPv6": []any{}, "disallow_unplug": false, "management": false, "physical": false, "metrics": fakeNullRef},
+	"host":    {"PIFs": []any{}, "resident_VMs": []any{}},
+	"pool":    {"default_SR": fakeNullRef, "name_description": ""},
+	"task":    {"status": "pending", "progress": 0, "result": "", "error_info": []any{}},
+}
+
+func (x *fakeXAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut && r.URL.Path == "/import_raw_vdi" {
+		x.importRawVDI(w, r)
+		return
+	}
+
+	var request struct {
+		Method string          `json:"method"`
+		Params []any           `json:"params"`
+		ID     json.RawMessage `json:"id"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if decoder.Decode(&request) != nil {
+		http.Error(w, "invalid JSON-RPC request", http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]any{"jsonrpc": "2.0", "id": request.ID}
+	result, err := x.handle(request.Method, request.Params)
+	if err != nil {
+		xapiErr := fakeError("INTERNAL_ERROR", err.Error())
+		errors.As(err, &xapiErr)
+		params := []string{}
+		for _, param := range xapiErr.params {
+			params = append(params, fmt.Sprint(param))
+		}
+		response["error"] = map[string]any{"code": 1, "message": xapiErr.code, "data": params}
+	} else {
+		response["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// importRawVDI is the HTTP handler writing the content of a VDI.
+func (x *fakeXAPI) importRawVDI(w http.ResponseWriter, r *http.Request) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.sessions[r.URL.Query().Get("session_id")] {
+		http.Error(w, "invalid session", http.StatusUnauthorized)
+		return
+	}
+	vdi := r.URL.Query().Get("vdi")
+	if _, ok := x.objects["vdi"][vdi]; !ok || r.URL.Query().Get("format") != "raw" {
+		http.Error(w, "invalid VDI", http.StatusNotFound)
+		return
+	}
+	x.contents[vdi] = content
+	x.set(vdi, "physical_utilisation", len(content))
+}
+
+func (x *fakeXAPI) handle(method string, params []any) (any, error) {
+	if method == "session.login_with_password" {
+		return x.login(params)
+	}
+	if len(params) == 0 {
+		return nil, fakeError("SESSION_INVALID", "")
+	}
+	session := fakeStr(params[0])
+	x.mu.Lock()
+	valid := x.sessions[session]
+	x.mu.Unlock()
+	if !valid {
+		return nil, fakeError("SESSION_INVALID", session)
+	}
+	args := params[1:]
+
+	if strings.EqualFold(method, "event.from") {
+		return x.eventFrom(args)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if class, ok := strings.CutPrefix(method, "Async."); ok {
+		return x.async(class, args), nil
+	}
+	result, err := x.call(method, session, args)
+	if err == nil && !isReadOnlyMethod(method) {
+		x.touch()
+	}
+	// the result is encoded once the lock is released
+	return fakeClone(result), err
+}
+
+func (x *fakeXAPI) login(params []any) (any, error) {
+	if len(params) < 2 || fakeStr(params[0]) != x.username || fakeStr(params[1]) != x.password {
+		return nil, fakeError("SESSION_AUTHENTICATION_FAILED", "Authentication failure")
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.serial++
+	session := "OpaqueRef:session-" + strconv.Itoa(x.serial)
+	x.sessions[session] = true
+	return session, nil
+}
+
+// eventFrom returns once the objects changed since the token or after the
+// timeout. The events themselves are not reported, the provider checks the
+// state again after each call.
+func (x *fakeXAPI) eventFrom(args []any) (any, error) {
+	token, _ := strconv.Atoi(fakeStr(fakeArg(args, 1)))
+	timeout, _ := strconv.ParseFloat(fakeStr(fakeArg(args, 2)), 64)
+
+	x.mu.Lock()
+	generation, changed := x.generation, x.changed
+	x.mu.Unlock()
+	if fakeStr(fakeArg(args, 1)) != "" && token >= generation {
+		select {
+		case <-changed:
+		case <-time.After(time.Duration(timeout * float64(time.Second))):
+		}
+		x.mu.Lock()
+		generation = x.generation
+		x.mu.Unlock()
+	}
+
+	return map[string]any{"events": []any{}, "valid_ref_counts": map[string]any{}, "token": strconv.Itoa(generation)}, nil
+}
+
+func (x *fakeXAPI) touch() {
+	x.generation++
+	close(x.changed)
+	x.changed = make(chan struct{})
+}
+
+// async runs the operation at once and returns the task holding its outcome.
+func (x *fakeXAPI) async(method string, args []any) string {
+	task := x.add("task", map[string]any{"name_label": "Async." + method, "status": "success", "progress": 1})
+	result, err := x.call(method, "", args)
+	if err != nil {
+		xapiErr := fakeError("INTERNAL_ERROR", err.Error())
+		errors.As(err, &xapiErr)
+		x.set(task, "status", "failure")
+		x.set(task, "error_info", append([]any{xapiErr.code}, xapiErr.params...))
+	} else if result != nil {
+		x.set(task, "result", fmt.Sprintf("<value>%v</value>", result))
+	}
+	x.touch()
+	return task
+}
+
+//nolint:gocyclo,cyclop // one case per operation
+func (x *fakeXAPI) call(method string, session string, args []any) (any, error) {
+	class, name, _ := strings.Cut(method, ".")
+	class = strings.ToLower(class)
+	self := fakeStr(fakeArg(args, 0))
+
+	if fault, ok := x.faults[strings.ToLower(class+"."+name)]; ok {
+		if fault.once {
+			delete(x.faults, strings.ToLower(class+"."+name))
+		}
+		return nil, fault
+	}
+
+	switch strings.ToLower(class + "." + name) {
+	case "session.logout":
+		delete(x.sessions, session)
+		return nil, nil
+	case "session.get_this_host":
+		return x.ref("host"), nil
+	case "session.get_rbac_permissions":
+		return []any{"vm.get_record", "vm.clone", "vm.destroy", "vdi.create", "sr.create", "network.create"}, nil
+	case "task.cancel":
+		return nil, x.setField(self, "status", "cancelled")
+	case "vm.clone", "vm.copy":
+		return x.cloneVM(self, fakeStr(fakeArg(args, 1)), false)
+	case "vm.snapshot", "vm.checkpoint":
+		return x.cloneVM(self, fakeStr(fakeArg(args, 1)), true)
+	case "vm.provision", "pool.join", "pif.plug", "pif.unplug":
+		return nil, x.check(self)
+	case "vm.assert_can_boot_here":
+		if err := x.check(self); err != nil {
+			return nil, err
+		}
+		return nil, x.check(fakeStr(fakeArg(args, 1)))
+	case "vm.start", "vm.resume":
+		if err := x.startVM(self); err != nil || fakeArg(args, 1) != true {
+			return nil, err
+		}
+		return nil, x.setField(self, "power_state", "Paused")
+	case "vm.start_on", "vm.resume_on":
+		if err := x.check(fakeStr(fakeArg(args, 1))); err != nil {
+			return nil, err
+		}
+		if err := x.startVM(self); err != nil {
+			return nil, err
+		}
+		x.set(self, "resident_on", fakeStr(fakeArg(args, 1)))
+		if fakeArg(args, 2) != true {
+			return nil, nil
+		}
+		return nil, x.setField(self, "power_state", "Paused")
+	case "vm.unpause":
+		return nil, x.startVM(self)
+	case "vm.pool_migrate":
+		if err := x.check(fakeStr(fakeArg(args, 1))); err != nil {
+			return nil, err
+		}
+		return nil, x.setField(self, "resident_on", fakeStr(fakeArg(args, 1)))
+	case "vm.hard_shutdown", "vm.clean_shutdown":
+		if err := x.setField(self, "power_state", "Halted"); err != nil {
+			return nil, err
+		}
+		// the devices of a halted VM are unplugged
+		vbds, _ := x.field(self, "VBDs")
+		for _, vbd := range fakeList(vbds) {
+			x.set(fakeStr(vbd), "currently_attached", false)
+		}
+		return nil, x.setField(self, "resident_on", fakeNullRef)
+	case "vm.pause":
+		return nil, x.setField(self, "power_state", "Paused")
+	case "vm.suspend":
+		return nil, x.setField(self, "power_state", "Suspended")
+	case "vm.revert":
+		parent, err := x.field(self, "snapshot_of")
+		if err != nil {
+			return nil, err
+		}
+		state, _ := x.field(self, "power_state")
+		return nil, x.setField(fakeStr(parent), "power_state", state)
+	case "vm.destroy":
+		return nil, x.destroyVM(self)
+	case "vm.get_allowed_vbd_devices":
+		return x.allowedVBDDevices(self)
+	case "vm.set_memory_limits":
+		for i, field := range []string{"memory_static_min", "memory_static_max", "memory_dynamic_min", "memory_dynamic_max"} {
+			if err := x.setField(self, field, fakeArg(args, i+1)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case "vbd.create":
+		return x.create(class, fakeRecord(fakeArg(args, 0)), map[string]string{"VM": "VBDs", "VDI": "VBDs"})
+	case "vbd.destroy":
+		return nil, x.destroy(self, map[string]string{"VM": "VBDs", "VDI": "VBDs"})
+	case "vbd.plug", "vif.plug", "pbd.plug":
+		return nil, x.setField(self, "currently_attached", true)
+	case "vbd.unplug", "vbd.unplug_force", "vif.unplug", "pbd.unplug":
+		return nil, x.setField(self, "currently_attached", false)
+	case "vbd.insert":
+		if err := x.setField(self, "empty", false); err != nil {
+			return nil, err
+		}
+		return nil, x.setField(self, "VDI", fakeStr(fakeArg(args, 1)))
+	case "vbd.eject":
+		if err := x.setField(self, "empty", true); err != nil {
+			return nil, err
+		}
+		return nil, x.setField(self, "VDI", fakeNullRef)
+	case "vdi.create":
+		return x.create(class, fakeRecord(fakeArg(args, 0)), map[string]string{"SR": "VDIs"})
+	case "vdi.destroy":
+		return nil, x.destroy(self, map[string]string{"SR": "VDIs"})
+	case "vdi.resize", "vdi.resize_online":
+		return nil, x.setField(self, "virtual_size", fakeArg(args, 1))
+	case "vdi.copy":
+		return x.copyVDI(self, fakeStr(fakeArg(args, 1)))
+	case "vdi.pool_migrate":
+		return x.migrateVDI(self, fakeStr(fakeArg(args, 1)))
+	case "vif.create":
+		vif := fakeRecord(fakeArg(args, 0))
+		if fakeStr(vif["MAC"]) == "" {
+			x.serial++
+			vif["MAC"] = fmt.Sprintf("b6:2a:%02x:%02x:%02x:%02x", byte(x.serial>>24), byte(x.serial>>16), byte(x.serial>>8), byte(x.serial))
+			vif["MAC_autogenerated"] = true
+		}
+		return x.create(class, vif, map[string]string{"VM": "VIFs", "network": "VIFs"})
+	case "vif.destroy":
+		return nil, x.destroy(self, map[string]string{"VM": "VIFs", "network": "VIFs"})
+	case "vif.get_allowed_operations":
+		return []any{"attach", "plug", "unplug"}, x.check(self)
+	case "network.create":
+		network := fakeRecord(fakeArg(args, 0))
+		network["bridge"] = "xapi" + strconv.Itoa(len(x.objects["network"]))
+		return x.create(class, network, nil)
+	case "sr.create":
+		return x.createSR(self, fakeRecord(fakeArg(args, 1)), fakeArg(args, 2), fakeStr(fakeArg(args, 3)), fakeStr(fakeArg(args, 4)), fakeStr(fakeArg(args, 5)), fakeStr(fakeArg(args, 6)), fakeArg(args, 7) == true), nil
+	case "sr.forget", "sr.destroy":
+		return nil, x.destroySR(self)
+	case "pool.create_vlan_from_pif":
+		return x.createVLAN(self, fakeStr(fakeArg(args, 1)), fakeArg(args, 2))
+	case "vlan.destroy":
+		untagged, err := x.field(self, "untagged_PIF")
+		if err != nil {
+			return nil, err
+		}
+		if err := x.destroy(fakeStr(untagged), map[string]string{"network": "PIFs", "host": "PIFs"}); err != nil {
+			return nil, err
+		}
+		return nil, x.destroy(self, map[string]string{"tagged_PIF": "VLAN_slave_of"})
+	case "pif.reconfigure_ip":
+		for i, field := range []string{"ip_configuration_mode", "IP", "netmask", "gateway", "DNS"} {
+			if err := x.setField(self, field, fakeArg(args, i+1)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case "pool.management_reconfigure":
+		for _, pif := range x.objects["pif"] {
+			pif["management"] = fakeStr(pif["network"]) == self
+		}
+		return nil, nil
+	case "pool.eject":
+		return nil, fakeError("HOST_IS_LIVE", self)
+	}
+
+	return x.generic(class, name, args)
+}
+
+// generic implements the calls every class has, based on the name of the
+// method and of the field it reads or writes.
+func (x *fakeXAPI) generic(class string, name string, args []any) (any, error) {
+	self := fakeStr(fakeArg(args, 0))
+	switch {
+	case name == "get_all":
+		return slices.Sorted(maps.Keys(x.objects[class])), nil
+	case name == "get_all_records":
+		records := map[string]any{}
+		for ref, record := range x.objects[class] {
+			records[ref] = record
+		}
+		return records, nil
+	case name == "get_record":
+		return x.get(class, self)
+	case name == "get_by_uuid":
+		for ref, record := range x.objects[class] {
+			if record["uuid"] == self {
+				return ref, nil
+			}
+		}
+		return nil, fakeError("UUID_INVALID", class, self)
+	case name == "get_by_name_label":
+		refs := []string{}
+		for ref, record := range x.objects[class] {
+			if record["name_label"] == self {
+				refs = append(refs, ref)
+			}
+		}
+		return refs, nil
+	case name == "create":
+		return x.create(class, fakeRecord(fakeArg(args, 0)), nil)
+	case name == "destroy":
+		return nil, x.destroy(self, nil)
+	case strings.HasPrefix(name, "get_"):
+		return x.field(self, strings.TrimPrefix(name, "get_"))
+	case strings.HasPrefix(name, "set_"):
+		return nil, x.setField(self, strings.TrimPrefix(name, "set_"), fakeArg(args, 1))
+	case strings.HasPrefix(name, "add_to_"):
+		value, err := x.field(self, strings.TrimPrefix(name, "add_to_"))
+		if err != nil {
+			return nil, err
+		}
+		fakeRecord(value)[fakeStr(fakeArg(args, 1))] = fakeArg(args, 2)
+		return nil, nil
+	case strings.HasPrefix(name, "remove_from_"):
+		value, err := x.field(self, strings.TrimPrefix(name, "remove_from_"))
+		if err != nil {
+			return nil, err
+		}
+		delete(fakeRecord(value), fakeStr(fakeArg(args, 1)))
+		return nil, nil
+	}
+	return nil, fakeError("MESSAGE_METHOD_UNKNOWN", class+"."+name)
+}
+
+// add stores a new record of the class and returns its reference.
+func (x *fakeXAPI) add(class string, fields map[string]any) string {
+	x.serial++
+	ref := fmt.Sprintf("OpaqueRef:%08x-0000-4000-8000-%012x", x.serial, x.serial)
+	record := map[string]any{
+		"uuid":             fmt.Sprintf("%08x-0000-4000-9000-%012x", x.serial, x.serial),
+		"name_label":       "",
+		"name_description": "",
+		"other_config":     map[string]any{},
+	}
+	for key, value := range fakeDefaults[strings.ToLower(class)] {
+		record[key] = fakeClone(value)
+	}
+	for key, value := range fields {
+		record[key] = value
+	}
+	if x.objects[strings.ToLower(class)] == nil {
+		x.objects[strings.ToLower(class)] = map[string]map[string]any{}
+	}
+	x.objects[strings.ToLower(class)][ref] = record
+	return ref
+}
+
+// create adds the record and links it to the objects it refers to, links
+// maps the reference fields to the list fields of the referred objects.
+func (x *fakeXAPI) create(class string, fields map[string]any, links map[string]string) (string, error) {
+	for field := range links {
+		if target := fakeStr(fields[field]); target != "" && target != fakeNullRef {
+			if err := x.check(target); err != nil {
+				return "", err
+			}
+		}
+	}
+	// XAPI generates the UUID of the new objects
+	delete(fields, "uuid")
+	ref := x.add(class, fields)
+	for field, list := range links {
+		if target := fakeStr(fields[field]); target != "" && target != fakeNullRef {
+			x.link(target, list, ref)
+		}
+	}
+	return ref, nil
+}
+
+func (x *fakeXAPI) destroy(ref string, links map[string]string) error {
+	class, record, err := x.find(ref)
+	if err != nil {
+		return err
+	}
+	for field, list := range links {
+		if target, _, err := x.find(fakeStr(record[field])); err == nil {
+			values, _ := x.objects[target][fakeStr(record[field])][list].([]any)
+			x.objects[target][fakeStr(record[field])][list] = slices.DeleteFunc(values, func(value any) bool { return value == ref })
+		}
+	}
+	delete(x.objects[class], ref)
+	return nil
+}
+
+func (x *fakeXAPI) cloneVM(ref string, nameLabel string, snapshot bool) (string, error) {
+	_, source, err := x.find(ref)
+	if err != nil {
+		return "", err
+	}
+	fields := fakeClone(source).(map[string]any) //nolint:forcetypeassert // a record is a map
+	fields["name_label"] = nameLabel
+	fields["is_default_template"] = false
+	fields["VBDs"] = []any{}
+	fields["VIFs"] = []any{}
+	fields["snapshots"] = []any{}
+	delete(fields, "uuid")
+	if otherConfig, ok := fields["other_config"].(map[string]any); ok && source["is_a_template"] == true && !snapshot {
+		// XAPI records the template of the clones
+		if _, ok := otherConfig[baseTemplateNameKey]; !ok {
+			otherConfig[baseTemplateNameKey] = source["name_label"]
+		}
+	}
+	if snapshot {
+		fields["is_a_snapshot"] = true
+		fields["is_a_template"] = true
+		fields["snapshot_of"] = ref
+		fields["snapshot_time"] = time.Now().UTC().Format("20060102T15:04:05Z")
+	}
+	vm := x.add("VM", fields)
+	if snapshot {
+		x.link(ref, "snapshots", vm)
+	}
+
+	for _, vbd := range fakeList(source["VBDs"]) {
+		_, vbdRecord, err := x.find(fakeStr(vbd))
+		if err != nil {
+			return "", err
+		}
+		vbdFields := fakeClone(vbdRecord).(map[string]any) //nolint:forcetypeassert // a record is a map
+		delete(vbdFields, "uuid")
+		vbdFields["VM"] = vm
+		if vdi := fakeStr(vbdRecord["VDI"]); vdi != fakeNullRef && vbdRecord["type"] == "Disk" {
+			_, vdiRecord, err := x.find(vdi)
+			if err != nil {
+				return "", err
+			}
+			vdiFields := fakeClone(vdiRecord).(map[string]any) //nolint:forcetypeassert // a record is a map
+			delete(vdiFields, "uuid")
+			vdiFields["VBDs"] = []any{}
+			vbdFields["VDI"], _ = x.create("VDI", vdiFields, map[string]string{"SR": "VDIs"})
+		}
+		if _, err := x.create("VBD", vbdFields, map[string]string{"VM": "VBDs", "VDI": "VBDs"}); err != nil {
+			return "", err
+		}
+	}
+	for _, vif := range fakeList(source["VIFs"]) {
+		_, vifRecord, err := x.find(fakeStr(vif))
+		if err != nil {
+			return "", err
+		}
+		vifFields := fakeClone(vifRecord).(map[string]any) //nolint:forcetypeassert // a record is a map
+		delete(vifFields, "uuid")
+		vifFields["VM"] = vm
+		if _, err := x.create("VIF", vifFields, map[string]string{"VM": "VIFs", "network": "VIFs"}); err != nil {
+			return "", err
+		}
+	}
+
+	return vm, nil
+}
+
+// startVM runs the VM on the host, the guest reporting an IP address for
+// each of its VIFs right away.
+func (x *fakeXAPI) startVM(ref string) error {
+	_, vm, err := x.find(ref)
+	if err != nil {
+		return err
+	}
+	if vm["is_a_template"] == true {
+		return fakeError("VM_IS_TEMPLATE", ref)
+	}
+	networks := map[string]any{}
+	for i := range fakeList(vm["VIFs"]) {
+		x.serial++
+		networks[strconv.Itoa(i)+"/ip"] = "192.0.2." + strconv.Itoa(x.serial%254+1)
+	}
+	if fakeStr(vm["guest_metrics"]) == fakeNullRef {
+		vm["guest_metrics"] = x.add("VM_guest_metrics", map[string]any{"os_version": map[string]any{}, "networks": networks, "other": map[string]any{}, "live": true})
+	}
+	vm["power_state"] = "Running"
+	vm["resident_on"] = x.ref("host")
+	return nil
+}
+
+func (x *fakeXAPI) destroyVM(ref string) error {
+	_, vm, err := x.find(ref)
+	if err != nil {
+		return err
+	}
+	if vm["power_state"] != "Halted" && vm["is_a_snapshot"] != true {
+		return fakeError("VM_BAD_POWER_STATE", ref, "halted", strings.ToLower(fakeStr(vm["power_state"])))
+	}
+	for _, vbd := range fakeList(vm["VBDs"]) {
+		_ = x.destroy(fakeStr(vbd), map[string]string{"VDI": "VBDs"})
+	}
+	for _, vif := range fakeList(vm["VIFs"]) {
+		_ = x.destroy(fakeStr(vif), map[string]string{"network": "VIFs"})
+	}
+	if guestMetrics := fakeStr(vm["guest_metrics"]); guestMetrics != fakeNullRef {
+		_ = x.destroy(guestMetrics, nil)
+	}
+	return x.destroy(ref, map[string]string{"snapshot_of": "snapshots"})
+}
+
+func (x *fakeXAPI) allowedVBDDevices(ref string) (any, error) {
+	_, vm, err := x.find(ref)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, vbd := range fakeList(vm["VBDs"]) {
+		if _, record, err := x.find(fakeStr(vbd)); err == nil {
+			used[fakeStr(record["userdevice"])] = true
+		}
+	}
+	devices := []any{}
+	for i := range 16 {
+		if !used[strconv.Itoa(i)] {
+			devices = append(devices, strconv.Itoa(i))
+		}
+	}
+	return devices, nil
+}
+
+// createSR creates the SR with its PBD plugged on the host.
+func (x *fakeXAPI) createSR(host string, deviceConfig map[string]any, physicalSize any, nameLabel string, nameDescription string, srType string, contentType string, shared bool) string {
+	sr := x.add("SR", map[string]any{
+		"name_label":       nameLabel,
+		"name_description": nameDescription,
+		"type":             srType,
+		"content_type":     contentType,
+		"shared":           shared,
+		"physical_size":    physicalSize,
+	})
+	pbd := x.add("PBD", map[string]any{"host": host, "SR": sr, "device_config": deviceConfig, "currently_attached": true})
+	x.link(sr, "PBDs", pbd)
+	return sr
+}
+
+func (x *fakeXAPI) destroySR(ref string) error {
+	_, sr, err := x.find(ref)
+	if err != nil {
+		return err
+	}
+	for _, pbd := range fakeList(sr["PBDs"]) {
+		_ = x.destroy(fakeStr(pbd), nil)
+	}
+	for _, vdi := range fakeList(sr["VDIs"]) {
+		_ = x.destroy(fakeStr(vdi), nil)
+	}
+	return x.destroy(ref, nil)
+}
+
+// copyVDI creates a VDI with the fields of the VDI on the SR, without VBDs.
+func (x *fakeXAPI) copyVDI(ref string, sr string) (string, error) {
+	_, source, err := x.find(ref)
+	if err != nil {
+		return "", err
+	}
+	fields := fakeClone(source).(map[string]any) //nolint:forcetypeassert // a record is a map
+	fields["SR"] = sr
+	fields["VBDs"] = []any{}
+	return x.create("VDI", fields, map[string]string{"SR": "VDIs"})
+}
+
+// migrateVDI copies the VDI to the SR, moves its VBDs to the copy and
+// destroys it, as XAPI does when migrating a VDI.
+func (x *fakeXAPI) migrateVDI(ref string, sr string) (string, error) {
+	copyRef, err := x.copyVDI(ref, sr)
+	if err != nil {
+		return "", err
+	}
+	vbds, _ := x.field(ref, "VBDs")
+	for _, vbd := range fakeList(vbds) {
+		x.set(fakeStr(vbd), "VDI", copyRef)
+		x.link(copyRef, "VBDs", fakeStr(vbd))
+	}
+	return copyRef, x.destroy(ref, map[string]string{"SR": "VDIs"})
+}
+
+// createVLAN creates the VLAN on the host of the tagged PIF and returns the
+// new untagged PIF.
+func (x *fakeXAPI) createVLAN(taggedPIF string, network string, tag any) (any, error) {
+	_, pif, err := x.find(taggedPIF)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.check(network); err != nil {
+		return nil, err
+	}
+	untagged := x.add("PIF", map[string]any{
+		"device":                pif["device"],
+		"network":               network,
+		"host":                  pif["host"],
+		"MAC":                   pif["MAC"],
+		"MTU":                   pif["MTU"],
+		"VLAN":                  tag,
+		"currently_attached":    true,
+		"ip_configuration_mode": "None",
+	})
+	vlan := x.add("VLAN", map[string]any{"tagged_PIF": taggedPIF, "untagged_PIF": untagged, "tag": tag})
+	x.set(untagged, "VLAN_master_of", vlan)
+	x.link(taggedPIF, "VLAN_slave_of", vlan)
+	x.link(network, "PIFs", untagged)
+	x.link(fakeStr(pif["host"]), "PIFs", untagged)
+	return []any{untagged}, nil
+}
+
+func (x *fakeXAPI) find(ref string) (string, map[string]any, error) {
+	for class, records := range x.objects {
+		if record, ok := records[ref]; ok {
+			return class, record, nil
+		}
+	}
+	return "", nil, fakeError("HANDLE_INVALID", ref)
+}
+
+func (x *fakeXAPI) check(ref string) error {
+	_, _, err := x.find(ref)
+	return err
+}
+
+func (x *fakeXAPI) get(class string, ref string) (any, error) {
+	record, ok := x.objects[class][ref]
+	if !ok {
+		return nil, fakeError("HANDLE_INVALID", class, ref)
+	}
+	return record, nil
+}
+
+func (x *fakeXAPI) field(ref string, field string) (any, error) {
+	_, record, err := x.find(ref)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range record {
+		if strings.EqualFold(key, field) {
+			return value, nil
+		}
+	}
+	return nil, fakeError("MESSAGE_METHOD_UNKNOWN", field)
+}
+
+func (x *fakeXAPI) setField(ref string, field string, value any) error {
+	_, record, err := x.find(ref)
+	if err != nil {
+		return err
+	}
+	for key := range record {
+		if strings.EqualFold(key, field) {
+			field = key
+		}
+	}
+	record[field] = value
+	return nil
+}
+
+// set is setField for the objects known to exist, e.g. while seeding.
+func (x *fakeXAPI) set(ref string, field string, value any) {
+	_ = x.setField(ref, field, value)
+}
+
+// link appends the reference to the list field of the object.
+func (x *fakeXAPI) link(ref string, field string, value string) {
+	_, record, err := x.find(ref)
+	if err != nil {
+		return
+	}
+	record[field] = append(fakeList(record[field]), value)
+}
+
+// ref returns the first object of a class, e.g. the pool.
+func (x *fakeXAPI) ref(class string) string {
+	refs := slices.Sorted(maps.Keys(x.objects[strings.ToLower(class)]))
+	if len(refs) == 0 {
+		return fakeNullRef
+	}
+	return refs[0]
+}
+
+func fakeArg(args []any, i int) any {
+	if i >= len(args) {
+		return nil
+	}
+	return args[i]
+}
+
+func fakeStr(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func fakeList(value any) []any {
+	values, _ := value.([]any)
+	return values
+}
+
+func fakeRecord(value any) map[string]any {
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return map[string]any{}
+	}
+	return fields
+}
+
+// clone deep copies the lists and maps of a record.
+func fakeClone(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		fields := make(map[string]any, len(v))
+		for key, item := range v {
+			fields[key] = fakeClone(item)
+		}
+		return fields
+	case []any:
+		values := make([]any, 0, len(v))
+		for _, item := range v {
+			values = append(values, fakeClone(item))
+		}
+		return values
+	}
+	return value
+}
+
+func TestFakeXAPI(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+
+	_, err := loginServer(server.URL, "root", "wrong", &clientConf{})
+	if err == nil || !strings.Contains(err.Error(), "SESSION_AUTHENTICATION_FAILED") {
+		t.Fatalf("expected SESSION_AUTHENTICATION_FAILED, got: %v", err)
+	}
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	templateRef, err := getFirstTemplate(session, "Windows 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "test vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolRefs, err := xenapi.Pool.GetAll(session)
+	if err != nil || len(poolRefs) != 1 {
+		t.Fatalf("expected 1 pool, got %d: %v", len(poolRefs), err)
+	}
+	srRef, err := xenapi.Pool.GetDefaultSR(session, poolRefs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRef, err := xenapi.VDI.Create(session, xenapi.VDIRecord{NameLabel: "disk", SR: srRef, VirtualSize: 1073741824, Type: xenapi.VdiTypeUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.VBD.Create(session, xenapi.VBDRecord{VM: vmRef, VDI: vdiRef, Userdevice: "0", Bootable: true, Mode: xenapi.VbdModeRW, Type: xenapi.VbdTypeDisk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	networks, err := xenapi.Network.GetAll(session)
+	if err != nil || len(networks) != 2 {
+		t.Fatalf("expected 2 networks, got %d: %v", len(networks), err)
+	}
+	_, err = xenapi.VIF.Create(session, xenapi.VIFRecord{VM: vmRef, Network: networks[0], Device: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xenapi.VM.SetIsATemplate(session, vmRef, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.Start(session, vmRef, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vmRecord.PowerState != xenapi.VMPowerStateRunning || len(vmRecord.VBDs) != 1 || len(vmRecord.VIFs) != 1 {
+		t.Fatalf("unexpected VM record: %+v", vmRecord)
+	}
+	ip, err := checkIP(ctx, session, vmRecord, 1)
+	if err != nil || ip == "" {
+		t.Fatalf("expected the IP address of the VM, got %q: %v", ip, err)
+	}
+
+	err = cleanupVMResource(session, vmRef, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRecord, err := xenapi.VDI.GetRecord(session, vdiRef)
+	if err != nil || len(vdiRecord.VBDs) != 0 {
+		t.Fatalf("expected the VDI to be kept without VBDs, got %+v: %v", vdiRecord, err)
+	}
+	err = cleanupVDIResource(session, vdiRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
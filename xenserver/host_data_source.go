@@ -90,10 +90,10 @@ func (d *hostDataSource) Read(ctx context.Context, req datasource.ReadRequest, r
 
 	hostRecords, err := xenapi.Host.GetAllRecords(d.session)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to read Host records",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -111,10 +111,10 @@ func (d *hostDataSource) Read(ctx context.Context, req datasource.ReadRequest, r
 		if !data.IsCoordinator.IsNull() {
 			_, coordinatorUUID, err := getCoordinatorRef(d.session)
 			if err != nil {
-				resp.Diagnostics.AddError(
+				resp.Diagnostics.Append(xapiErrorDiagnostic(
 					"Unable to get coordinator ref",
-					err.Error(),
-				)
+					err,
+				))
 				return
 			}
 
@@ -133,10 +133,10 @@ func (d *hostDataSource) Read(ctx context.Context, req datasource.ReadRequest, r
 		var hostData hostRecordData
 		err = updateHostRecordData(ctx, d.session, hostRecord, &hostData)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to update Host record data",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 		hostItems = append(hostItems, hostData)
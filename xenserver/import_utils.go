@@ -0,0 +1,134 @@
+package xenserver
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/identityschema"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// uuidIdentityModel is the identity of the resources of a XAPI object, the
+// UUID of the object.
+type uuidIdentityModel struct {
+	UUID types.String `tfsdk:"uuid"`
+}
+
+// uuidIdentitySchema returns the identity schema of the resources of a XAPI
+// object, which can be imported by the UUID of the object in an import block.
+func uuidIdentitySchema(kind string) identityschema.Schema {
+	return identityschema.Schema{
+		Attributes: map[string]identityschema.Attribute{
+			"uuid": identityschema.StringAttribute{
+				Description:       "The UUID of the " + kind + ".",
+				RequiredForImport: true,
+			},
+		},
+	}
+}
+
+// setUUIDIdentity sets the identity of the resource of the object, unless
+// Terraform doesn't support resource identity, i.e. before Terraform 1.12.
+func setUUIDIdentity(ctx context.Context, identity *tfsdk.ResourceIdentity, uuid types.String) diag.Diagnostics {
+	if identity == nil {
+		return nil
+	}
+	return identity.Set(ctx, uuidIdentityModel{UUID: uuid})
+}
+
+// parseImportID returns the selectors of an import ID, e.g.
+// "sr=<uuid>/name=data" or "name:web", or nil when the ID is a UUID. The
+// values can contain "/", e.g. "name=a/b", as the segments which don't start
+// with one of the keys are part of the previous value.
+func parseImportID(id string, keys []string) (map[string]string, error) {
+	selectors := map[string]string{}
+	last := ""
+	for i, segment := range strings.Split(id, "/") {
+		key, value, ok := cutImportSelector(segment)
+		if ok && slices.Contains(keys, key) {
+			if _, ok := selectors[key]; ok {
+				return nil, errors.New("the selector " + key + " is set more than once")
+			}
+			selectors[key] = value
+			last = key
+			continue
+		}
+		if i == 0 {
+			if ok {
+				return nil, errors.New("unknown selector " + key + ", expected one of " + strings.Join(keys, ", "))
+			}
+			// a UUID
+			return nil, nil
+		}
+		selectors[last] = selectors[last] + "/" + segment
+	}
+	if selectors["name"] == "" {
+		return nil, errors.New("the name selector is required, e.g. name=<name_label>")
+	}
+
+	return selectors, nil
+}
+
+// cutImportSelector splits a selector on its first "=" or ":".
+func cutImportSelector(segment string) (string, string, bool) {
+	i := strings.IndexAny(segment, "=:")
+	if i < 0 {
+		return "", "", false
+	}
+	return segment[:i], segment[i+1:], true
+}
+
+// importStateByName imports a resource by its identity, the UUID of its object
+// or the selectors of the import ID. find returns the UUIDs of the objects
+// matching the selectors, a name being ambiguous when several objects match.
+func importStateByName(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse, kind string, keys []string, find func(selectors map[string]string) ([]string, error)) {
+	if req.ID == "" {
+		resource.ImportStatePassthroughWithIdentity(ctx, path.Root("uuid"), path.Root("uuid"), req, resp)
+		return
+	}
+	selectors, err := parseImportID(req.ID, keys)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Invalid import ID",
+			"The import ID must be the UUID of the "+kind+" or selectors such as name=<name_label>, got: "+req.ID+". "+err.Error(),
+		)
+		return
+	}
+	if selectors == nil {
+		resource.ImportStatePassthroughID(ctx, path.Root("uuid"), req, resp)
+		return
+	}
+
+	uuids, err := find(selectors)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to find the "+kind+" to import",
+			err,
+		))
+		return
+	}
+	switch len(uuids) {
+	case 0:
+		resp.Diagnostics.AddError(
+			"Unable to find the "+kind+" to import",
+			"No "+kind+" matches the import ID "+req.ID+".",
+		)
+		return
+	case 1:
+	default:
+		sort.Strings(uuids)
+		resp.Diagnostics.AddError(
+			"Ambiguous import ID",
+			"The import ID "+req.ID+" matches several objects of type "+kind+": "+strings.Join(uuids, ", ")+". Import with the UUID instead, or add a selector among "+strings.Join(keys, ", ")+".",
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("uuid"), uuids[0])...)
+}
@@ -0,0 +1,194 @@
+package xenserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+
+	"xenapi"
+)
+
+func TestParseImportID(t *testing.T) {
+	keys := []string{"name", "sr"}
+	for _, tc := range []struct {
+		id       string
+		expected map[string]string
+		fails    bool
+	}{
+		{id: "c9c1f5a6-0c3d-4e0a-9d6e-1a2b3c4d5e6f", expected: nil},
+		{id: "name:data", expected: map[string]string{"name": "data"}},
+		{id: "sr=c9c1f5a6/name=data", expected: map[string]string{"name": "data", "sr": "c9c1f5a6"}},
+		{id: "name=backup/2024/sr=c9c1f5a6", expected: map[string]string{"name": "backup/2024", "sr": "c9c1f5a6"}},
+		{id: "host=c9c1f5a6/name=data", fails: true},
+		{id: "sr=c9c1f5a6", fails: true},
+		{id: "name=a/name=b", fails: true},
+	} {
+		selectors, err := parseImportID(tc.id, keys)
+		if (err != nil) != tc.fails {
+			t.Fatalf("%s: unexpected error: %v", tc.id, err)
+		}
+		if !tc.fails && !reflect.DeepEqual(selectors, tc.expected) {
+			t.Fatalf("%s: expected %v, got %v", tc.id, tc.expected, selectors)
+		}
+	}
+}
+
+func TestFindForImport(t *testing.T) {
+	fake := newFakeXAPI("root", "password")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	templateRef, err := getFirstTemplate(session, "Windows 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		vmRef, err := cloneVM(ctx, session, templateRef, "web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = xenapi.VM.SetIsATemplate(session, vmRef, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	uuids, err := findVMsForImport(session, map[string]string{"name": "web"})
+	if err != nil || len(uuids) != 2 {
+		t.Fatalf("expected 2 VMs, got %v, %v", uuids, err)
+	}
+	// the templates aren't VMs
+	uuids, err = findVMsForImport(session, map[string]string{"name": "Windows 11"})
+	if err != nil || len(uuids) != 0 {
+		t.Fatalf("expected no VM, got %v, %v", uuids, err)
+	}
+
+	srRef, err := xenapi.Pool.GetDefaultSR(session, mustGetPool(t, session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srUUID, err := xenapi.SR.GetUUID(session, srRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.VDI.Create(session, xenapi.VDIRecord{NameLabel: "data", SR: srRef, VirtualSize: 1073741824, Type: xenapi.VdiTypeUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuids, err = findVDIsForImport(session, map[string]string{"name": "data", "sr": srUUID})
+	if err != nil || len(uuids) != 1 {
+		t.Fatalf("expected 1 VDI, got %v, %v", uuids, err)
+	}
+	_, err = findVDIsForImport(session, map[string]string{"name": "data", "sr": "00000000-0000-0000-0000-000000000000"})
+	if err == nil {
+		t.Fatal("expected an error for an unknown SR")
+	}
+
+	// the SRs of the other storages aren't found by the NFS and SMB resources
+	host := fake.ref("host")
+	fake.createSR(host, map[string]any{"server": "192.0.2.10", "serverpath": "/share"}, 0, "shared", "", "nfs", "", true)
+	fake.createSR(host, map[string]any{"location": "192.0.2.10:/iso", "type": "nfs_iso"}, 0, "shared", "", "iso", "iso", true)
+	fake.createSR(host, map[string]any{"server": "//192.0.2.11/share"}, 0, "shared", "", "smb", "", true)
+	fake.createSR(host, map[string]any{"location": "//192.0.2.11/iso", "type": "cifs"}, 0, "shared", "", "iso", "iso", true)
+	for _, tc := range []struct {
+		srType  string
+		isoType string
+		count   int
+	}{
+		{srType: "nfs", isoType: "nfs_iso", count: 2},
+		{srType: "smb", isoType: "cifs", count: 2},
+		{srType: "", isoType: "", count: 4},
+	} {
+		uuids, err = findSRsForImport(session, tc.srType, tc.isoType, map[string]string{"name": "shared"})
+		if err != nil || len(uuids) != tc.count {
+			t.Fatalf("expected %d SRs of the type %q, got %v, %v", tc.count, tc.srType, uuids, err)
+		}
+	}
+
+	// only the VLAN networks are found
+	pifRecords, err := xenapi.PIF.GetAllRecords(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var networkRef xenapi.NetworkRef
+	for range 2 {
+		networkRef, err = xenapi.Network.Create(session, xenapi.NetworkRecord{NameLabel: "vlan"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for pifRef, pifRecord := range pifRecords {
+		if pifRecord.Device == "eth1" {
+			_, err = fake.createVLAN(string(pifRef), string(networkRef), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	uuids, err = findNetworksForImport(session, map[string]string{"name": "vlan"})
+	if err != nil || len(uuids) != 1 {
+		t.Fatalf("expected 1 VLAN network, got %v, %v", uuids, err)
+	}
+}
+
+func TestImportStateByIdentity(t *testing.T) {
+	ctx := context.Background()
+	r := &vmResource{}
+	var schemaResp resource.SchemaResponse
+	r.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	var identitySchemaResp resource.IdentitySchemaResponse
+	r.IdentitySchema(ctx, resource.IdentitySchemaRequest{}, &identitySchemaResp)
+
+	identityType := identitySchemaResp.IdentitySchema.Type().TerraformType(ctx)
+	uuid := "c9c1f5a6-0c3d-4e0a-9d6e-1a2b3c4d5e6f"
+	req := resource.ImportStateRequest{
+		Identity: &tfsdk.ResourceIdentity{
+			Schema: identitySchemaResp.IdentitySchema,
+			Raw:    tftypes.NewValue(identityType, map[string]tftypes.Value{"uuid": tftypes.NewValue(tftypes.String, uuid)}),
+		},
+	}
+	resp := resource.ImportStateResponse{
+		State: tfsdk.State{
+			Schema: schemaResp.Schema,
+			Raw:    tftypes.NewValue(schemaResp.Schema.Type().TerraformType(ctx), nil),
+		},
+		Identity: &tfsdk.ResourceIdentity{
+			Schema: identitySchemaResp.IdentitySchema,
+			Raw:    req.Identity.Raw.Copy(),
+		},
+	}
+	importStateByName(ctx, req, &resp, "VM", []string{"name"}, func(map[string]string) ([]string, error) {
+		t.Fatal("expected the VM to be imported by its identity")
+		return nil, nil
+	})
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	var imported types.String
+	resp.Diagnostics.Append(resp.State.GetAttribute(ctx, path.Root("uuid"), &imported)...)
+	if resp.Diagnostics.HasError() || imported.ValueString() != uuid {
+		t.Fatalf("expected the UUID %s in the state, got %s: %v", uuid, imported, resp.Diagnostics)
+	}
+
+	// the identity is kept up to date with the state
+	diags := setUUIDIdentity(ctx, resp.Identity, types.StringValue("00000000-0000-0000-0000-000000000000"))
+	var identity uuidIdentityModel
+	diags.Append(resp.Identity.Get(ctx, &identity)...)
+	if diags.HasError() || identity.UUID.ValueString() != "00000000-0000-0000-0000-000000000000" {
+		t.Fatalf("unexpected identity %v: %v", identity, diags)
+	}
+	if diags = setUUIDIdentity(ctx, nil, identity.UUID); diags.HasError() {
+		t.Fatalf("expected no identity before Terraform 1.12, got: %v", diags)
+	}
+}
@@ -0,0 +1,95 @@
+package xenserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// objectLocks serializes the operations of the resources on the same XAPI
+// object, which terraform runs in parallel, e.g. the update of a VM and a
+// snapshot of the VM. The locks are keyed by the object references, which are
+// unique across the pools, so they are shared by all the provider
+// configurations.
+var objectLocks = newKeyedMutex()
+
+// keyedMutex is a set of mutexes by key. The mutex of a key is removed once
+// it isn't used anymore.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	// held has a value while the mutex is locked, a channel rather than a
+	// sync.Mutex so that the waiters can give up.
+	held chan struct{}
+	// refs is the number of the holders and the waiters of the mutex.
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: map[string]*keyedMutexEntry{}}
+}
+
+// lock waits for the mutex of the key until the context is done.
+func (m *keyedMutex) lock(ctx context.Context, key string) error {
+	m.mu.Lock()
+	entry, ok := m.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{held: make(chan struct{}, 1)}
+		m.locks[key] = entry
+	}
+	entry.refs++
+	m.mu.Unlock()
+
+	select {
+	case entry.held <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		m.release(key, entry)
+		return ctx.Err()
+	}
+}
+
+func (m *keyedMutex) unlock(key string) {
+	m.mu.Lock()
+	entry, ok := m.locks[key]
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	<-entry.held
+	m.release(key, entry)
+}
+
+// release drops a reference to the mutex of the key.
+func (m *keyedMutex) release(key string, entry *keyedMutexEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.refs--
+	if entry.refs == 0 {
+		delete(m.locks, key)
+	}
+}
+
+// lockObject locks the XAPI object of the reference until the returned
+// function is called. It fails when the context is done before the lock is
+// acquired, e.g. at the timeout of the operation. The locks aren't reentrant,
+// so the functions called while holding the lock of an object must not lock
+// it again.
+func lockObject(ctx context.Context, ref string) (func(), error) {
+	tflog.Debug(ctx, "----> Lock the object "+ref)
+	err := objectLocks.lock(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock the object %s, another operation is still using it. %w", ref, err)
+	}
+
+	return func() {
+		objectLocks.unlock(ref)
+		tflog.Debug(ctx, "----> Unlock the object "+ref)
+	}, nil
+}
@@ -0,0 +1,63 @@
+package xenserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	m := newKeyedMutex()
+	counters := map[string]*int{"OpaqueRef:vm1": new(int), "OpaqueRef:vm2": new(int)}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		for _, key := range []string{"OpaqueRef:vm1", "OpaqueRef:vm2"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := m.lock(context.Background(), key); err != nil {
+					t.Error(err)
+					return
+				}
+				defer m.unlock(key)
+				// the read and the write race unless the key is locked
+				*counters[key] = *counters[key] + 1
+			}()
+		}
+	}
+	wg.Wait()
+
+	if *counters["OpaqueRef:vm1"] != 100 || *counters["OpaqueRef:vm2"] != 100 {
+		t.Fatalf("unexpected counters: %d, %d", *counters["OpaqueRef:vm1"], *counters["OpaqueRef:vm2"])
+	}
+	if len(m.locks) != 0 {
+		t.Fatalf("expected the mutexes to be removed, got %d", len(m.locks))
+	}
+}
+
+func TestLockObjectTimeout(t *testing.T) {
+	unlock, err := lockObject(context.Background(), "OpaqueRef:vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the waiter gives up at the timeout of its operation
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = lockObject(ctx, "OpaqueRef:vm")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got: %v", err)
+	}
+
+	unlock()
+	unlock, err = lockObject(context.Background(), "OpaqueRef:vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if len(objectLocks.locks) != 0 {
+		t.Fatalf("expected the mutexes to be removed, got %d", len(objectLocks.locks))
+	}
+}
@@ -156,10 +156,10 @@ func (d *networkDataSource) Read(ctx context.Context, req datasource.ReadRequest
 
 	networkRecords, err := xenapi.Network.GetAllRecords(d.session)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get network records",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -179,10 +179,10 @@ func (d *networkDataSource) Read(ctx context.Context, req datasource.ReadRequest
 		var networkData networkRecordData
 		err = updateNetworkRecordData(ctx, d.session, networkRecord, &networkData)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to update network record data",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 		networkItem = append(networkItem, networkData)
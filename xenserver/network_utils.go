@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 
 	"xenapi"
@@ -109,15 +112,16 @@ func updateNetworkRecordData(ctx context.Context, session *xenapi.Session, recor
 }
 
 type vlanResourceModel struct {
-	NameLabel       types.String `tfsdk:"name_label"`
-	NameDescription types.String `tfsdk:"name_description"`
-	MTU             types.Int32  `tfsdk:"mtu"`
-	Managed         types.Bool   `tfsdk:"managed"`
-	OtherConfig     types.Map    `tfsdk:"other_config"`
-	Tag             types.Int32  `tfsdk:"vlan_tag"`
-	NIC             types.String `tfsdk:"nic"`
-	UUID            types.String `tfsdk:"uuid"`
-	ID              types.String `tfsdk:"id"`
+	NameLabel       types.String   `tfsdk:"name_label"`
+	NameDescription types.String   `tfsdk:"name_description"`
+	MTU             types.Int32    `tfsdk:"mtu"`
+	Managed         types.Bool     `tfsdk:"managed"`
+	OtherConfig     types.Map      `tfsdk:"other_config"`
+	Tag             types.Int32    `tfsdk:"vlan_tag"`
+	NIC             types.String   `tfsdk:"nic"`
+	UUID            types.String   `tfsdk:"uuid"`
+	ID              types.String   `tfsdk:"id"`
+	Timeouts        timeouts.Value `tfsdk:"timeouts"`
 }
 
 type vlanCreateParams struct {
@@ -146,14 +150,14 @@ func getBondNICDevice(session *xenapi.Session, nic string) (string, error) {
 	slavesDevices := strings.Split(strings.Split(nic, " ")[1], "+")
 	bondRecords, err := xenapi.Bond.GetAllRecords(session)
 	if err != nil {
-		return "", errors.New(err.Error())
+		return "", newXAPIError(err)
 	}
 	for _, bondRecord := range bondRecords {
 		devices := []string{}
 		for _, slave := range bondRecord.Slaves {
 			pifRecord, err := xenapi.PIF.GetRecord(session, slave)
 			if err != nil {
-				return "", errors.New(err.Error())
+				return "", newXAPIError(err)
 			}
 			devices = append(devices, strings.Split(pifRecord.Device, "eth")[1])
 		}
@@ -161,7 +165,7 @@ func getBondNICDevice(session *xenapi.Session, nic string) (string, error) {
 		if slices.Equal(slavesDevices, devices) {
 			record, err := xenapi.PIF.GetRecord(session, bondRecord.Master)
 			if err != nil {
-				return "", errors.New(err.Error())
+				return "", newXAPIError(err)
 			}
 			return record.Device, nil
 		}
@@ -174,7 +178,7 @@ func getPifRefsForNIC(session *xenapi.Session, nic string) ([]xenapi.PIFRef, err
 	var pifRefs []xenapi.PIFRef
 	pifRecords, err := xenapi.PIF.GetAllRecords(session)
 	if err != nil {
-		return pifRefs, errors.New(err.Error())
+		return pifRefs, newXAPIError(err)
 	}
 	device := "eth" + strings.Split(nic, " ")[1]
 	if strings.HasPrefix(nic, "Bond") {
@@ -194,7 +198,7 @@ func getPifRefsForNIC(session *xenapi.Session, nic string) ([]xenapi.PIFRef, err
 	for _, uuid := range uuids {
 		ref, err := xenapi.PIF.GetByUUID(session, uuid)
 		if err != nil {
-			return pifRefs, errors.New(err.Error())
+			return pifRefs, newXAPIError(err)
 		}
 		pifRefs = append(pifRefs, ref)
 	}
@@ -227,11 +231,11 @@ func getNICFromPIF(session *xenapi.Session, pifRecord xenapi.PIFRecord) (string,
 		if !pifRecord.Physical && string(pifRecord.VLANMasterOf) != "OpaqueRef:NULL" {
 			vlanRecord, err := xenapi.VLAN.GetRecord(session, pifRecord.VLANMasterOf)
 			if err != nil {
-				return name, errors.New(err.Error())
+				return name, newXAPIError(err)
 			}
 			taggedPifRecord, err := xenapi.PIF.GetRecord(session, vlanRecord.TaggedPIF)
 			if err != nil {
-				return name, errors.New(err.Error())
+				return name, newXAPIError(err)
 			}
 			if len(taggedPifRecord.SriovLogicalPIFOf) > 0 {
 				name = "NIC-SR-IOV " + index
@@ -240,15 +244,15 @@ func getNICFromPIF(session *xenapi.Session, pifRecord xenapi.PIFRecord) (string,
 	} else if strings.HasPrefix(pifRecord.Device, "bond") {
 		vlanRecord, err := xenapi.VLAN.GetRecord(session, pifRecord.VLANMasterOf)
 		if err != nil {
-			return name, errors.New(err.Error())
+			return name, newXAPIError(err)
 		}
 		taggedPifRecord, err := xenapi.PIF.GetRecord(session, vlanRecord.TaggedPIF)
 		if err != nil {
-			return name, errors.New(err.Error())
+			return name, newXAPIError(err)
 		}
 		bondRecord, err := xenapi.Bond.GetRecord(session, taggedPifRecord.BondMasterOf[0])
 		if err != nil {
-			return name, errors.New(err.Error())
+			return name, newXAPIError(err)
 		}
 		bondSlaveDevices, err := getBondSlaveDevices(session, bondRecord.Slaves)
 		if err != nil {
@@ -264,7 +268,7 @@ func updateVlanResourceModel(ctx context.Context, session *xenapi.Session, recor
 	data.NameLabel = types.StringValue(record.NameLabel)
 	pifRecord, err := xenapi.PIF.GetRecord(session, record.PIFs[0])
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	vlan, err := ToInt32(pifRecord.VLAN)
@@ -316,16 +320,16 @@ func vlanResourceModelUpdateCheck(data vlanResourceModel, dataState vlanResource
 func vlanResourceModelUpdate(ctx context.Context, session *xenapi.Session, ref xenapi.NetworkRef, data vlanResourceModel) error {
 	err := xenapi.Network.SetNameLabel(session, ref, data.NameLabel.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	err = xenapi.Network.SetNameDescription(session, ref, data.NameDescription.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	mtu := int(data.MTU.ValueInt32())
 	err = xenapi.Network.SetMTU(session, ref, mtu)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	otherConfig := make(map[string]string)
 	diags := data.OtherConfig.ElementsAs(ctx, &otherConfig, false)
@@ -334,7 +338,7 @@ func vlanResourceModelUpdate(ctx context.Context, session *xenapi.Session, ref x
 	}
 	err = xenapi.Network.SetOtherConfig(session, ref, otherConfig)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	return nil
 }
@@ -342,21 +346,21 @@ func vlanResourceModelUpdate(ctx context.Context, session *xenapi.Session, ref x
 func cleanupVlanResource(session *xenapi.Session, ref xenapi.NetworkRef) error {
 	networkRecord, err := xenapi.Network.GetRecord(session, ref)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	for _, pifRef := range networkRecord.PIFs {
 		pifRecord, err := xenapi.PIF.GetRecord(session, pifRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 		err = xenapi.VLAN.Destroy(session, pifRecord.VLANMasterOf)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 	err = xenapi.Network.Destroy(session, ref)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	return nil
 }
@@ -377,7 +381,7 @@ func getBondSlaveDevices(session *xenapi.Session, bondSlaves []xenapi.PIFRef) ([
 	for _, slave := range bondSlaves {
 		record, err := xenapi.PIF.GetRecord(session, slave)
 		if err != nil {
-			return bondSlaveDevices, errors.New(err.Error())
+			return bondSlaveDevices, newXAPIError(err)
 		}
 		bondSlaveDevices = append(bondSlaveDevices, record.Device)
 	}
@@ -388,13 +392,13 @@ func getBondNICs(session *xenapi.Session) ([]string, error) {
 	var nics []string
 	bondRecords, err := xenapi.Bond.GetAllRecords(session)
 	if err != nil {
-		return nics, errors.New(err.Error())
+		return nics, newXAPIError(err)
 	}
 	var bondDevices []string
 	for _, bondRecord := range bondRecords {
 		pifRecord, err := xenapi.PIF.GetRecord(session, bondRecord.Master)
 		if err != nil {
-			return nics, errors.New(err.Error())
+			return nics, newXAPIError(err)
 		}
 		if !slices.Contains(bondDevices, pifRecord.Device) {
 			bondDevices = append(bondDevices, pifRecord.Device)
@@ -480,3 +484,69 @@ func getNICNameForBondDevices(devices []string) string {
 	slices.Sort(deviceNumberStrings)
 	return name + " " + strings.Join(deviceNumberStrings, "+")
 }
+
+// vlanResourceModelPlanCheck checks that the NIC of the plan exists and that
+// the VLAN tag isn't used on it yet. The state is nil when the VLAN is going
+// to be created.
+func vlanResourceModelPlanCheck(session *xenapi.Session, plan vlanResourceModel, state *vlanResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if state != nil || plan.NIC.IsUnknown() {
+		return diags
+	}
+
+	pifRefs, err := getPifRefsForNIC(session, plan.NIC.ValueString())
+	if err != nil {
+		diags.AddAttributeError(path.Root("nic"), "Invalid NIC", err.Error())
+		return diags
+	}
+	if len(pifRefs) == 0 {
+		diags.AddAttributeError(path.Root("nic"), "Invalid NIC", "unable to find PIF for NIC "+plan.NIC.ValueString())
+		return diags
+	}
+	if plan.Tag.IsUnknown() {
+		return diags
+	}
+
+	vlanRecords, err := xenapi.VLAN.GetAllRecords(session)
+	if err != nil {
+		diags.AddAttributeError(path.Root("vlan_tag"), "Unable to get VLAN records", err.Error())
+		return diags
+	}
+	for _, vlanRecord := range vlanRecords {
+		if slices.Contains(pifRefs, vlanRecord.TaggedPIF) && vlanRecord.Tag == int(plan.Tag.ValueInt32()) {
+			diags.AddAttributeError(path.Root("vlan_tag"), "Invalid VLAN tag", "the VLAN tag "+strconv.Itoa(vlanRecord.Tag)+" is already used on "+plan.NIC.ValueString())
+			break
+		}
+	}
+
+	return diags
+}
+
+// findNetworksForImport returns the UUIDs of the VLAN networks with the name of
+// the selectors, the networks without a VLAN PIF aren't managed by
+// xenserver_network_vlan.
+func findNetworksForImport(session *xenapi.Session, selectors map[string]string) ([]string, error) {
+	networkRecords, err := xenapi.Network.GetAllRecords(session)
+	if err != nil {
+		return nil, newXAPIError(err)
+	}
+	pifRecords, err := xenapi.PIF.GetAllRecords(session)
+	if err != nil {
+		return nil, newXAPIError(err)
+	}
+	vlanNetworks := make(map[xenapi.NetworkRef]bool)
+	for _, pifRecord := range pifRecords {
+		if string(pifRecord.VLANMasterOf) != "OpaqueRef:NULL" {
+			vlanNetworks[pifRecord.Network] = true
+		}
+	}
+	var uuids []string
+	for networkRef, networkRecord := range networkRecords {
+		if networkRecord.NameLabel != selectors["name"] || !vlanNetworks[networkRef] {
+			continue
+		}
+		uuids = append(uuids, networkRecord.UUID)
+	}
+
+	return uuids, nil
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework-validators/int32validator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
 	"github.com/hashicorp/terraform-plugin-framework/attr"
-	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
@@ -29,6 +28,8 @@ var (
 	_ resource.Resource                = &vlanResource{}
 	_ resource.ResourceWithConfigure   = &vlanResource{}
 	_ resource.ResourceWithImportState = &vlanResource{}
+	_ resource.ResourceWithIdentity    = &vlanResource{}
+	_ resource.ResourceWithModifyPlan  = &vlanResource{}
 )
 
 func NewVlanResource() resource.Resource {
@@ -44,7 +45,7 @@ func (r *vlanResource) Metadata(_ context.Context, req resource.MetadataRequest,
 	resp.TypeName = req.ProviderTypeName + "_network_vlan"
 }
 
-func (r *vlanResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *vlanResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "Provides an external network resource. A network that passes traffic over one of your VLANs.",
 		Attributes: map[string]schema.Attribute{
@@ -113,9 +114,16 @@ func (r *vlanResource) Schema(_ context.Context, _ resource.SchemaRequest, resp
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
+func (r *vlanResource) IdentitySchema(_ context.Context, _ resource.IdentitySchemaRequest, resp *resource.IdentitySchemaResponse) {
+	resp.IdentitySchema = uuidIdentitySchema("network")
+}
+
 func (r *vlanResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
 	// Prevent panic if the provider has not been configured.
 	if req.ProviderData == nil {
@@ -132,57 +140,92 @@ func (r *vlanResource) Configure(_ context.Context, req resource.ConfigureReques
 	r.session = providerData.session
 }
 
+// ModifyPlan checks the plan against the pool, so that the invalid references
+// are reported by terraform plan.
+func (r *vlanResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// nothing to check when the resource is destroyed or the provider isn't
+	// configured yet
+	if req.Plan.Raw.IsNull() || r.session == nil {
+		return
+	}
+	var plan vlanResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	var state *vlanResourceModel
+	if !req.State.Raw.IsNull() {
+		state = &vlanResourceModel{}
+		resp.Diagnostics.Append(req.State.Get(ctx, state)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(vlanResourceModelPlanCheck(r.session, plan, state)...)
+}
+
 func (r *vlanResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("create"))
+		return
+	}
+
 	var data vlanResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	createTimeout, diags := data.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	tflog.Debug(ctx, "Creating Network...")
 	networkRecord, err := getNetworkCreateParams(ctx, data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get network create params",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	networkRef, err := xenapi.Network.Create(r.session, networkRecord)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to create network",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	networkRecord, err = xenapi.Network.GetRecord(r.session, networkRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get network record",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupVlanResource(r.session, networkRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up network resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 	err = updateVlanResourceModelComputed(ctx, networkRecord, &data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of vlanResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupVlanResource(r.session, networkRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up network resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
@@ -190,31 +233,42 @@ func (r *vlanResource) Create(ctx context.Context, req resource.CreateRequest, r
 	tflog.Debug(ctx, "Creating Vlan...")
 	params, err := getVlanCreateParams(r.session, data, networkRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get vlan create params",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupVlanResource(r.session, networkRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up network resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
+	// the tags of the NIC are checked and used one by one
+	unlock, err := lockObject(ctx, string(params.PifRef))
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock NIC",
+			err,
+		))
+		return
+	}
+	defer unlock()
 	_, err = xenapi.Pool.CreateVLANFromPIF(r.session, params.PifRef, params.NetworkRef, params.Tag)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to create vlan",
-			err.Error(),
-		)
+			err,
+			"vlan_tag",
+		))
 		err = cleanupVlanResource(r.session, networkRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up network resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
@@ -222,6 +276,7 @@ func (r *vlanResource) Create(ctx context.Context, req resource.CreateRequest, r
 	tflog.Debug(ctx, "External Network created")
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 func (r *vlanResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
@@ -231,42 +286,64 @@ func (r *vlanResource) Read(ctx context.Context, req resource.ReadRequest, resp
 		return
 	}
 
+	readTimeout, diags := data.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	// Overwrite data with refreshed resource state
 	networkRef, err := xenapi.Network.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get network ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	networkRecord, err := xenapi.Network.GetRecord(r.session, networkRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get network record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = updateVlanResourceModel(ctx, r.session, networkRecord, &data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the fields of vlanResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 func (r *vlanResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("update"))
+		return
+	}
+
 	var plan, state vlanResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	updateTimeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	// Checking if configuration changes are allowed
 	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
 	if resp.Diagnostics.HasError() {
@@ -274,75 +351,91 @@ func (r *vlanResource) Update(ctx context.Context, req resource.UpdateRequest, r
 	}
 	err := vlanResourceModelUpdateCheck(plan, state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Error update xenserver_network_vlan configuration",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	// Update the resource with new configuration
 	networkRef, err := xenapi.Network.GetByUUID(r.session, plan.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get network ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = vlanResourceModelUpdate(ctx, r.session, networkRef, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update network_vlan resource",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	networkRecord, err := xenapi.Network.GetRecord(r.session, networkRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get network record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = updateVlanResourceModelComputed(ctx, networkRecord, &plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of vlanResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, plan.UUID)...)
 }
 
 func (r *vlanResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("delete"))
+		return
+	}
+
 	var data vlanResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
 	networkRef, err := xenapi.Network.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get network ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = cleanupVlanResource(r.session, networkRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to delete network resource",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 }
 
 func (r *vlanResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("uuid"), req, resp)
+	importStateByName(ctx, req, resp, "network", []string{"name"}, func(selectors map[string]string) ([]string, error) {
+		return findNetworksForImport(r.session, selectors)
+	})
 }
@@ -77,12 +77,12 @@ func (d *nicDataSource) Read(ctx context.Context, req datasource.ReadRequest, re
 
 	bondNICs, err := getBondNICs(d.session)
 	if err != nil {
-		resp.Diagnostics.AddError("Failed to get bond type NICs", err.Error())
+		resp.Diagnostics.Append(xapiErrorDiagnostic("Failed to get bond type NICs", err))
 		return
 	}
 	pifRecords, err := xenapi.PIF.GetAllRecords(d.session)
 	if err != nil {
-		resp.Diagnostics.AddError("Failed to get PIF records", err.Error())
+		resp.Diagnostics.Append(xapiErrorDiagnostic("Failed to get PIF records", err))
 		return
 	}
 	physicalWithoutBondNICs := getPhysicalWithoutBondNICs(pifRecords)
@@ -36,7 +36,7 @@ func (r *pifConfigureResource) Metadata(_ context.Context, req resource.Metadata
 	resp.TypeName = req.ProviderTypeName + "_pif_configure"
 }
 
-func (r *pifConfigureResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *pifConfigureResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "PIF configuration resource which is used to update the existing PIF parameters. \n\n Noted that no new PIF will be deployed when `terraform apply` is executed. Additionally, when it comes to `terraform destroy`, it actually has no effect on this resource.",
 		Attributes: map[string]schema.Attribute{
@@ -89,6 +89,9 @@ func (r *pifConfigureResource) Schema(_ context.Context, _ resource.SchemaReques
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
@@ -110,18 +113,31 @@ func (r *pifConfigureResource) Configure(_ context.Context, req resource.Configu
 }
 
 func (r *pifConfigureResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("create"))
+		return
+	}
+
 	var data pifConfigureResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	createTimeout, diags := data.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	err := pifConfigureResourceModelUpdate(ctx, r.session, data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update PIF configuration",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -138,23 +154,44 @@ func (r *pifConfigureResource) Read(ctx context.Context, req resource.ReadReques
 		return
 	}
 
+	readTimeout, diags := data.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	data.ID = data.UUID
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
 func (r *pifConfigureResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("update"))
+		return
+	}
+
 	var plan pifConfigureResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	updateTimeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	err := pifConfigureResourceModelUpdate(ctx, r.session, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update PIF configuration",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -163,6 +200,11 @@ func (r *pifConfigureResource) Update(ctx context.Context, req resource.UpdateRe
 }
 
 func (r *pifConfigureResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("delete"))
+		return
+	}
+
 	tflog.Debug(ctx, "Don't recover the PIF configuration when destroy resource")
 }
 
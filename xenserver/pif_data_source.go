@@ -238,10 +238,10 @@ func (d *pifDataSource) Read(ctx context.Context, req datasource.ReadRequest, re
 
 	pifRecords, err := xenapi.PIF.GetAllRecords(d.session)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to read PIF records",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -250,10 +250,10 @@ func (d *pifDataSource) Read(ctx context.Context, req datasource.ReadRequest, re
 		if !data.Network.IsNull() {
 			NetworkRef, err := xenapi.Network.GetByUUID(d.session, data.Network.ValueString())
 			if err != nil {
-				resp.Diagnostics.AddError(
+				resp.Diagnostics.Append(xapiErrorDiagnostic(
 					"Unable to get network reference",
-					err.Error(),
-				)
+					err,
+				))
 				return
 			}
 			if pifRecord.Network != NetworkRef {
@@ -272,10 +272,10 @@ func (d *pifDataSource) Read(ctx context.Context, req datasource.ReadRequest, re
 		var pifData pifRecordData
 		err = updatePIFRecordData(ctx, d.session, pifRecord, &pifData)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to update PIF record data",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 		pifItems = append(pifItems, pifData)
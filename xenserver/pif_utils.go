@@ -3,9 +3,10 @@ package xenserver
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
-	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
@@ -181,10 +182,11 @@ func updatePIFRecordData(ctx context.Context, session *xenapi.Session, record xe
 }
 
 type pifConfigureResourceModel struct {
-	DisallowUnplug types.Bool   `tfsdk:"disallow_unplug"`
-	Interface      types.Object `tfsdk:"interface"`
-	UUID           types.String `tfsdk:"uuid"`
-	ID             types.String `tfsdk:"id"`
+	DisallowUnplug types.Bool     `tfsdk:"disallow_unplug"`
+	Interface      types.Object   `tfsdk:"interface"`
+	UUID           types.String   `tfsdk:"uuid"`
+	ID             types.String   `tfsdk:"id"`
+	Timeouts       timeouts.Value `tfsdk:"timeouts"`
 }
 
 type InterfaceObject struct {
@@ -214,26 +216,26 @@ func getIPConfigurationMode(mode string) xenapi.IPConfigurationMode {
 func pifConfigureResourceModelUpdate(ctx context.Context, session *xenapi.Session, data pifConfigureResourceModel) error {
 	pifRef, err := xenapi.PIF.GetByUUID(session, data.UUID.ValueString())
 	if err != nil {
-		return errors.New(err.Error() + ", uuid: " + data.UUID.ValueString())
+		return fmt.Errorf("%w, uuid: %s", err, data.UUID.ValueString())
 	}
 
 	if !data.DisallowUnplug.IsNull() {
 		err := xenapi.PIF.SetDisallowUnplug(session, pifRef, data.DisallowUnplug.ValueBool())
 		if err != nil {
 			tflog.Error(ctx, "unable to update the PIF 'disallow_unplug'")
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
 	if !data.Interface.IsNull() {
 		pifMetricsRef, err := xenapi.PIF.GetMetrics(session, pifRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 
 		isPIFConnected, err := xenapi.PIFMetrics.GetCarrier(session, pifMetricsRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 
 		if !isPIFConnected {
@@ -249,14 +251,14 @@ func pifConfigureResourceModelUpdate(ctx context.Context, session *xenapi.Sessio
 		if !interfaceObject.NameLabel.IsNull() {
 			oc, err := xenapi.PIF.GetOtherConfig(session, pifRef)
 			if err != nil {
-				return errors.New(err.Error())
+				return newXAPIError(err)
 			}
 
 			oc["management_purpose"] = interfaceObject.NameLabel.ValueString()
 
 			err = xenapi.PIF.SetOtherConfig(session, pifRef, oc)
 			if err != nil {
-				return errors.New(err.Error())
+				return newXAPIError(err)
 			}
 		}
 
@@ -270,7 +272,7 @@ func pifConfigureResourceModelUpdate(ctx context.Context, session *xenapi.Sessio
 		err = xenapi.PIF.ReconfigureIP(session, pifRef, mode, ip, netmask, gateway, dns)
 		if err != nil {
 			tflog.Error(ctx, "unable to update the PIF 'interface'")
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 		if string(mode) == "DHCP" {
 			err := checkPIFHasIP(ctx, session, pifRef)
@@ -284,25 +286,24 @@ func pifConfigureResourceModelUpdate(ctx context.Context, session *xenapi.Sessio
 }
 
 func checkPIFHasIP(ctx context.Context, session *xenapi.Session, ref xenapi.PIFRef) error {
-	// set timeout channel to check if IP address is available
-	timeoutChan := time.After(time.Duration(60) * time.Second)
-	for {
-		select {
-		case <-timeoutChan:
-			return errors.New("get PIF IP timeout in 60 seconds, please check if the interface is connected")
-		default:
-			ip, err := xenapi.PIF.GetIP(session, ref)
-			if err != nil {
-				tflog.Error(ctx, "unable to get the PIF IP")
-				return errors.New(err.Error())
-			}
-			if isValidIpAddress(net.ParseIP(ip)) {
-				tflog.Debug(ctx, "PIF IP is available: "+ip)
-				return nil
-			}
-
-			tflog.Debug(ctx, "-----> Retry get PIF IP")
-			time.Sleep(5 * time.Second)
+	// wait until the IP address is available or the operation times out
+	err := waitForEvents(ctx, session, func() (bool, []string, error) {
+		ip, err := xenapi.PIF.GetIP(session, ref)
+		if err != nil {
+			tflog.Error(ctx, "unable to get the PIF IP")
+			return false, nil, newXAPIError(err)
+		}
+		if isValidIpAddress(net.ParseIP(ip)) {
+			tflog.Debug(ctx, "PIF IP is available: "+ip)
+			return true, nil, nil
 		}
+
+		tflog.Debug(ctx, "-----> Waiting for PIF IP")
+		return false, []string{"pif/" + string(ref)}, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("unable to get PIF IP, please check if the interface is connected. %w", err)
 	}
+
+	return err
 }
@@ -27,16 +27,20 @@ func NewPoolResource() resource.Resource {
 type poolResource struct {
 	session         *xenapi.Session
 	coordinatorConf *coordinatorConf
+	clientConf      *clientConf
 }
 
 func (r *poolResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
 	resp.TypeName = req.ProviderTypeName + "_pool"
 }
 
-func (r *poolResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *poolResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "This provides a pool resource." + "\n\n-> **Note:** During the execution of `terraform destroy` for this particular resource, all of the hosts that are part of the pool will be separated and converted into standalone hosts.",
 		Attributes:          PoolSchema(),
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
@@ -58,9 +62,15 @@ func (r *poolResource) Configure(_ context.Context, req resource.ConfigureReques
 
 	r.session = providerData.session
 	r.coordinatorConf = &providerData.coordinatorConf
+	r.clientConf = &providerData.clientConf
 }
 
 func (r *poolResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("create"))
+		return
+	}
+
 	tflog.Debug(ctx, "---> Create Pool resource")
 	var plan poolResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
@@ -68,63 +78,74 @@ func (r *poolResource) Create(ctx context.Context, req resource.CreateRequest, r
 		return
 	}
 
+	createTimeout, diags := plan.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	poolParams := getPoolParams(plan)
 
 	poolRef, err := getPoolRef(r.session)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get pool ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	tflog.Debug(ctx, "----> Start Pool join")
-	err = poolJoin(ctx, r.session, r.coordinatorConf, plan)
+	err = poolJoin(ctx, r.session, r.coordinatorConf, r.clientConf, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to join pool in Create stage",
-			err.Error(),
-		)
+			err,
+			"join_supporters",
+		))
 		return
 	}
 
 	tflog.Debug(ctx, "----> Start Pool eject")
 	err = poolEject(ctx, r.session, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to eject pool in Create stage",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	tflog.Debug(ctx, "----> Start Pool setting")
-	err = setPool(r.session, poolRef, poolParams)
+	err = setPool(ctx, r.session, poolRef, poolParams)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to set pool in Create stage",
-			err.Error(),
-		)
+			err,
+			"default_sr",
+			"management_network",
+		))
 
 		return
 	}
 
 	poolRecord, err := xenapi.Pool.GetRecord(r.session, poolRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get pool record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	err = updatePoolResourceModelComputed(r.session, poolRecord, &plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of PoolResourceModel in Create stage",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -138,30 +159,38 @@ func (r *poolResource) Read(ctx context.Context, req resource.ReadRequest, resp
 		return
 	}
 
+	readTimeout, diags := state.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	poolRef, err := xenapi.Pool.GetByUUID(r.session, state.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get pool ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	poolRecord, err := xenapi.Pool.GetRecord(r.session, poolRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get pool record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	err = updatePoolResourceModel(r.session, poolRecord, &state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of PoolResourceModel in Read stage",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -169,12 +198,25 @@ func (r *poolResource) Read(ctx context.Context, req resource.ReadRequest, resp
 }
 
 func (r *poolResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("update"))
+		return
+	}
+
 	tflog.Debug(ctx, "---> Update Pool resource")
 	var plan, state poolResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+
+	updateTimeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
 	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
 	if resp.Diagnostics.HasError() {
 		return
@@ -184,59 +226,62 @@ func (r *poolResource) Update(ctx context.Context, req resource.UpdateRequest, r
 
 	poolRef, err := getPoolRef(r.session)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get pool ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	tflog.Debug(ctx, "----> Start Pool join")
-	err = poolJoin(ctx, r.session, r.coordinatorConf, plan)
+	err = poolJoin(ctx, r.session, r.coordinatorConf, r.clientConf, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to join pool in Update stage",
-			err.Error(),
-		)
+			err,
+			"join_supporters",
+		))
 		return
 	}
 
 	tflog.Debug(ctx, "----> Start Pool eject")
 	err = poolEject(ctx, r.session, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to eject pool in Update stage",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	tflog.Debug(ctx, "----> Start Pool setting")
-	err = setPool(r.session, poolRef, poolParams)
+	err = setPool(ctx, r.session, poolRef, poolParams)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to set pool in Update stage",
-			err.Error(),
-		)
+			err,
+			"default_sr",
+			"management_network",
+		))
 
 		return
 	}
 
 	poolRecord, err := xenapi.Pool.GetRecord(r.session, poolRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get pool record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	err = updatePoolResourceModelComputed(r.session, poolRecord, &plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of PoolResourceModel in Update stage",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -244,6 +289,11 @@ func (r *poolResource) Update(ctx context.Context, req resource.UpdateRequest, r
 }
 
 func (r *poolResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("delete"))
+		return
+	}
+
 	tflog.Debug(ctx, "---> Delete Pool resource")
 	var state poolResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
@@ -251,16 +301,24 @@ func (r *poolResource) Delete(ctx context.Context, req resource.DeleteRequest, r
 		return
 	}
 
+	deleteTimeout, diags := state.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
 	poolRef, err := xenapi.Pool.GetByUUID(r.session, state.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError("Unable to get pool ref", err.Error())
+		resp.Diagnostics.Append(xapiErrorDiagnostic("Unable to get pool ref", err))
 		return
 	}
 
 	tflog.Debug(ctx, "----> Clean pool resource")
 	err = cleanupPoolResource(r.session, poolRef)
 	if err != nil {
-		resp.Diagnostics.AddError("Unable to cleanup pool resource", err.Error())
+		resp.Diagnostics.Append(xapiErrorDiagnostic("Unable to cleanup pool resource", err))
 		return
 	}
 
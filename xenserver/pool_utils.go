@@ -3,12 +3,11 @@ package xenserver
 import (
 	"context"
 	"errors"
-	"regexp"
+	"fmt"
 	"slices"
-	"strings"
 	"time"
 
-	"github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
@@ -20,14 +19,15 @@ import (
 )
 
 type poolResourceModel struct {
-	NameLabel             types.String `tfsdk:"name_label"`
-	NameDescription       types.String `tfsdk:"name_description"`
-	DefaultSRUUID         types.String `tfsdk:"default_sr"`
-	ManagementNetworkUUID types.String `tfsdk:"management_network"`
-	JoinSupporters        types.Set    `tfsdk:"join_supporters"`
-	EjectSupporters       types.Set    `tfsdk:"eject_supporters"`
-	UUID                  types.String `tfsdk:"uuid"`
-	ID                    types.String `tfsdk:"id"`
+	NameLabel             types.String   `tfsdk:"name_label"`
+	NameDescription       types.String   `tfsdk:"name_description"`
+	DefaultSRUUID         types.String   `tfsdk:"default_sr"`
+	ManagementNetworkUUID types.String   `tfsdk:"management_network"`
+	JoinSupporters        types.Set      `tfsdk:"join_supporters"`
+	EjectSupporters       types.Set      `tfsdk:"eject_supporters"`
+	UUID                  types.String   `tfsdk:"uuid"`
+	ID                    types.String   `tfsdk:"id"`
+	Timeouts              timeouts.Value `tfsdk:"timeouts"`
 }
 
 type joinSupporterResourceModel struct {
@@ -126,7 +126,7 @@ func getPoolParams(plan poolResourceModel) poolParams {
 	return params
 }
 
-func poolJoin(ctx context.Context, coordinatorSession *xenapi.Session, coordinatorConf *coordinatorConf, plan poolResourceModel) error {
+func poolJoin(ctx context.Context, coordinatorSession *xenapi.Session, coordinatorConf *coordinatorConf, clientConf *clientConf, plan poolResourceModel) error {
 	joinedSupporterUUIDs := []string{}
 	joinSupporters := make([]joinSupporterResourceModel, 0, len(plan.JoinSupporters.Elements()))
 	diags := plan.JoinSupporters.ElementsAs(ctx, &joinSupporters, false)
@@ -137,13 +137,21 @@ func poolJoin(ctx context.Context, coordinatorSession *xenapi.Session, coordinat
 		tflog.Debug(ctx, "No host to join.")
 		return nil
 	}
+	if coordinatorConf.Username == "" || coordinatorConf.Password == "" {
+		// the supporters log in to the coordinator with these credentials
+		return errors.New("joining supporters requires the username and password of the provider, not a session ID")
+	}
 	ejectSupporters := make([]string, 0, len(plan.EjectSupporters.Elements()))
 	diags = plan.EjectSupporters.ElementsAs(ctx, &ejectSupporters, false)
 	if diags.HasError() {
 		return errors.New("unable to access eject supporters in config data")
 	}
-	// if coordinator host has scheme, remove it
-	coordinatorIP := regexp.MustCompile(`^https?://`).ReplaceAllString(coordinatorConf.Host, "")
+	// use the address of the current coordinator, the configured host could
+	// be a supporter or a former coordinator
+	coordinatorIP, err := getCoordinatorAddress(coordinatorSession)
+	if err != nil {
+		return err
+	}
 	supportersHosts := []string{}
 	for _, supporter := range joinSupporters {
 		// check if the supporter is duplicated in 'join_supporters', skip if it is
@@ -153,44 +161,29 @@ func poolJoin(ctx context.Context, coordinatorSession *xenapi.Session, coordinat
 		}
 		supportersHosts = append(supportersHosts, supporter.Host.ValueString())
 
-		supporterSession, err := loginServer(supporter.Host.ValueString(), supporter.Username.ValueString(), supporter.Password.ValueString())
+		supporterSession, err := loginServer(supporter.Host.ValueString(), supporter.Username.ValueString(), supporter.Password.ValueString(), clientConf)
 		if err != nil {
-			if strings.Contains(err.Error(), "HOST_IS_SLAVE") {
+			if failure, ok := asXAPIError(err); ok && failure.Code == "HOST_IS_SLAVE" {
 				// check if the supporter in current pool
-				re := regexp.MustCompile(`data \[([^']*)\]`)
-				matches := re.FindStringSubmatch(err.Error())
-				if len(matches) > 1 && matches[1] == coordinatorIP {
+				if len(failure.Params) > 0 && failure.Params[0] == coordinatorIP {
 					tflog.Debug(ctx, "Host "+supporter.Host.ValueString()+" is already in this pool, continue")
 					continue
 				} else {
 					return errors.New("unable to join supporter host " + supporter.Host.ValueString() + ", it's not a standalone host")
 				}
 			}
-			return errors.New("login supporter host " + supporter.Host.ValueString() + "failed. " + err.Error())
+			return fmt.Errorf("login supporter host %sfailed. %w", supporter.Host.ValueString(), err)
 		}
 
-		hostRefs, err := xenapi.Host.GetAll(supporterSession)
-		if err != nil {
-			return errors.New("unable to get the supporter host refs. " + err.Error())
-		}
-		// check if the supporter is a pool with more than 1 host, return error if it is
-		if len(hostRefs) > 1 {
-			return errors.New("unable to join supporter host " + supporter.Host.ValueString() + ", it's not a standalone host")
+		supporterUUID, err := joinSupporter(ctx, supporterSession, supporter.Host.ValueString(), coordinatorIP, coordinatorConf, ejectSupporters)
+		// the supporter session is not needed anymore, xapi restarts on the
+		// supporter once it joined so a failure to log out is expected
+		logoutErr := logoutSession(ctx, supporterSession)
+		if logoutErr != nil {
+			tflog.Debug(ctx, "Unable to log out of supporter host "+supporter.Host.ValueString()+". "+logoutErr.Error())
 		}
-		supporterRef := hostRefs[0]
-		supporterUUID, err := getUUIDFromHostRef(supporterSession, supporterRef)
-		if err != nil {
-			return errors.New(err.Error() + ". \n\nsupporter host is: " + supporter.Host.ValueString())
-		}
-
-		// check if the host is in eject_supporters, return error if it is
-		if slices.Contains(ejectSupporters, supporterUUID) {
-			return errors.New("host " + supporter.Host.ValueString() + " with uuid " + supporterUUID + " is in eject_supporters, can't join the pool")
-		}
-
-		err = xenapi.Pool.Join(supporterSession, coordinatorIP, coordinatorConf.Username, coordinatorConf.Password)
 		if err != nil {
-			return errors.New(err.Error() + ". \n\nPool join failed with host uuid: " + supporterUUID)
+			return err
 		}
 		joinedSupporterUUIDs = append(joinedSupporterUUIDs, supporterUUID)
 	}
@@ -198,35 +191,61 @@ func poolJoin(ctx context.Context, coordinatorSession *xenapi.Session, coordinat
 	return waitAllSupportersLive(ctx, coordinatorSession, joinedSupporterUUIDs)
 }
 
+// joinSupporter joins the standalone host of the supporter session to the pool
+// and returns its UUID.
+func joinSupporter(ctx context.Context, supporterSession *xenapi.Session, supporterHost string, coordinatorIP string, coordinatorConf *coordinatorConf, ejectSupporters []string) (string, error) {
+	hostRefs, err := xenapi.Host.GetAll(supporterSession)
+	if err != nil {
+		return "", fmt.Errorf("unable to get the supporter host refs. %w", err)
+	}
+	// check if the supporter is a pool with more than 1 host, return error if it is
+	if len(hostRefs) > 1 {
+		return "", errors.New("unable to join supporter host " + supporterHost + ", it's not a standalone host")
+	}
+	supporterRef := hostRefs[0]
+	supporterUUID, err := getUUIDFromHostRef(supporterSession, supporterRef)
+	if err != nil {
+		return "", fmt.Errorf("%w. \n\nsupporter host is: %s", err, supporterHost)
+	}
+
+	// check if the host is in eject_supporters, return error if it is
+	if slices.Contains(ejectSupporters, supporterUUID) {
+		return "", errors.New("host " + supporterHost + " with uuid " + supporterUUID + " is in eject_supporters, can't join the pool")
+	}
+
+	taskRef, err := xenapi.Pool.AsyncJoin(supporterSession, coordinatorIP, coordinatorConf.Username, coordinatorConf.Password)
+	if err == nil {
+		_, err = waitForTask(ctx, supporterSession, taskRef)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w. \n\nPool join failed with host uuid: %s", err, supporterUUID)
+	}
+
+	return supporterUUID, nil
+}
+
 func waitAllSupportersLive(ctx context.Context, session *xenapi.Session, supporterUUIDs []string) error {
 	tflog.Debug(ctx, "---> Waiting for all supporters to join the pool...")
-	operation := func() error {
+	err := waitForEvents(ctx, session, func() (bool, []string, error) {
 		for _, supporterUUID := range supporterUUIDs {
 			hostRef, err := xenapi.Host.GetByUUID(session, supporterUUID)
 			if err != nil {
-				return errors.New("unable to get host ref by UUID " + supporterUUID + "!\n" + err.Error())
+				return false, nil, fmt.Errorf("unable to get host ref by UUID %s!\n%w", supporterUUID, err)
 			}
 			hostEnabled, err := xenapi.Host.GetEnabled(session, hostRef)
 			if err != nil {
-				return errors.New("unable to get host enabled status. " + err.Error())
+				return false, nil, fmt.Errorf("unable to get host enabled status. %w", err)
 			}
-			if hostEnabled {
-				tflog.Debug(ctx, "Host "+supporterUUID+" is enabled")
-				continue
-			} else {
-				tflog.Debug(ctx, "Host "+supporterUUID+" is disabled, retrying...")
-				return errors.New("host " + supporterUUID + " is disabled")
+			if !hostEnabled {
+				tflog.Debug(ctx, "Host "+supporterUUID+" is disabled, waiting...")
+				return false, []string{"host/" + string(hostRef)}, nil
 			}
+			tflog.Debug(ctx, "Host "+supporterUUID+" is enabled")
 		}
-		return nil
-	}
-
-	b := backoff.NewExponentialBackOff()
-	b.MaxInterval = 10 * time.Second
-	b.MaxElapsedTime = 5 * time.Minute
-	err := backoff.Retry(operation, b)
+		return true, nil, nil
+	})
 	if err != nil {
-		return errors.New(err.Error())
+		return err
 	}
 	tflog.Debug(ctx, "---> All supporters success join the pool.")
 
@@ -246,7 +265,7 @@ func poolEject(ctx context.Context, session *xenapi.Session, plan poolResourceMo
 	// get all the hosts current in pool
 	beforeEjectHostRefs, err := xenapi.Host.GetAll(session)
 	if err != nil {
-		return errors.New("unable to get the origin host refs in pool. " + err.Error())
+		return fmt.Errorf("unable to get the origin host refs in pool. %w", err)
 	}
 	beforeEjectHosts := make(map[string]xenapi.HostRef)
 	for _, ref := range beforeEjectHostRefs {
@@ -267,7 +286,7 @@ func poolEject(ctx context.Context, session *xenapi.Session, plan poolResourceMo
 		}
 		err := xenapi.Pool.Eject(session, hostRef)
 		if err != nil {
-			return errors.New("unable to eject pool with host UUID " + hostUUID + "!\n" + err.Error())
+			return fmt.Errorf("unable to eject pool with host UUID %s!\n%w", hostUUID, err)
 		}
 	}
 
@@ -279,11 +298,11 @@ func getCoordinatorRef(session *xenapi.Session) (xenapi.HostRef, string, error)
 	var coordinatorUUID string
 	poolRef, err := getPoolRef(session)
 	if err != nil {
-		return coordinatorRef, coordinatorUUID, errors.New(err.Error())
+		return coordinatorRef, coordinatorUUID, err
 	}
 	coordinatorRef, err = xenapi.Pool.GetMaster(session, poolRef)
 	if err != nil {
-		return coordinatorRef, coordinatorUUID, errors.New("unable to get pool master. " + err.Error())
+		return coordinatorRef, coordinatorUUID, fmt.Errorf("unable to get pool master. %w", err)
 	}
 	coordinatorUUID, err = getUUIDFromHostRef(session, coordinatorRef)
 	if err != nil {
@@ -292,10 +311,22 @@ func getCoordinatorRef(session *xenapi.Session) (xenapi.HostRef, string, error)
 	return coordinatorRef, coordinatorUUID, nil
 }
 
+func getCoordinatorAddress(session *xenapi.Session) (string, error) {
+	coordinatorRef, _, err := getCoordinatorRef(session)
+	if err != nil {
+		return "", err
+	}
+	address, err := xenapi.Host.GetAddress(session, coordinatorRef)
+	if err != nil {
+		return "", fmt.Errorf("unable to get coordinator address. %w", err)
+	}
+	return address, nil
+}
+
 func getPoolRef(session *xenapi.Session) (xenapi.PoolRef, error) {
 	poolRefs, err := xenapi.Pool.GetAll(session)
 	if err != nil {
-		return "", errors.New("unable to get pool refs. " + err.Error())
+		return "", fmt.Errorf("unable to get pool refs. %w", err)
 	}
 
 	return poolRefs[0], nil
@@ -304,18 +335,18 @@ func getPoolRef(session *xenapi.Session) (xenapi.PoolRef, error) {
 func cleanupPoolResource(session *xenapi.Session, poolRef xenapi.PoolRef) error {
 	err := xenapi.Pool.SetNameLabel(session, poolRef, "")
 	if err != nil {
-		return errors.New("unable to set pool name_label. " + err.Error())
+		return fmt.Errorf("unable to set pool name_label. %w", err)
 	}
 
 	coordinatorRef, _, err := getCoordinatorRef(session)
 	if err != nil {
-		return errors.New(err.Error())
+		return err
 	}
 
 	// eject supporters
 	hostRefs, err := xenapi.Host.GetAll(session)
 	if err != nil {
-		return errors.New("unable to get host all refs. " + err.Error())
+		return fmt.Errorf("unable to get host all refs. %w", err)
 	}
 
 	for _, hostRef := range hostRefs {
@@ -326,34 +357,34 @@ func cleanupPoolResource(session *xenapi.Session, poolRef xenapi.PoolRef) error
 
 		err = xenapi.Pool.Eject(session, hostRef)
 		if err != nil {
-			return errors.New("Pool eject failed when clean up. " + err.Error())
+			return fmt.Errorf("Pool eject failed when clean up. %w", err)
 		}
 	}
 
 	return nil
 }
 
-func setPool(session *xenapi.Session, poolRef xenapi.PoolRef, poolParams poolParams) error {
+func setPool(ctx context.Context, session *xenapi.Session, poolRef xenapi.PoolRef, poolParams poolParams) error {
 	err := xenapi.Pool.SetNameLabel(session, poolRef, poolParams.NameLabel)
 	if err != nil {
-		return errors.New("unable to set pool name_label. " + err.Error())
+		return fmt.Errorf("unable to set pool name_label. %w", err)
 	}
 
 	err = xenapi.Pool.SetNameDescription(session, poolRef, poolParams.NameDescription)
 	if err != nil {
-		return errors.New("unable to set pool name_description. " + err.Error())
+		return fmt.Errorf("unable to set pool name_description. %w", err)
 	}
 
 	if poolParams.DefaultSRUUID != "" {
 		srRef, err := xenapi.SR.GetByUUID(session, poolParams.DefaultSRUUID)
 		if err != nil {
-			return errors.New("unable to get SR by UUID " + poolParams.DefaultSRUUID + "!\n" + err.Error())
+			return fmt.Errorf("unable to get SR by UUID %s!\n%w", poolParams.DefaultSRUUID, err)
 		}
 
 		// Check if the SR is non-shared, return error if it is
 		shared, err := xenapi.SR.GetShared(session, srRef)
 		if err != nil {
-			return errors.New("unable to get SR shared status. " + err.Error())
+			return fmt.Errorf("unable to get SR shared status. %w", err)
 		}
 
 		if !shared {
@@ -362,23 +393,26 @@ func setPool(session *xenapi.Session, poolRef xenapi.PoolRef, poolParams poolPar
 
 		err = xenapi.Pool.SetDefaultSR(session, poolRef, srRef)
 		if err != nil {
-			return errors.New("unable to set pool default_SR. " + err.Error())
+			return fmt.Errorf("unable to set pool default_SR. %w", err)
 		}
 	}
 
 	if poolParams.ManagementNetworkUUID != "" {
 		networkRef, err := xenapi.Network.GetByUUID(session, poolParams.ManagementNetworkUUID)
 		if err != nil {
-			return errors.New("unable to get network by UUID " + poolParams.ManagementNetworkUUID + "!\n" + err.Error())
+			return fmt.Errorf("unable to get network by UUID %s!\n%w", poolParams.ManagementNetworkUUID, err)
 		}
 
 		err = xenapi.Pool.ManagementReconfigure(session, networkRef)
 		if err != nil {
-			return errors.New("unable to reconfigure pool management network " + poolParams.ManagementNetworkUUID + "!\n" + err.Error())
+			return fmt.Errorf("unable to reconfigure pool management network %s!\n%w", poolParams.ManagementNetworkUUID, err)
 		}
 
 		// wait for toolstack restart
-		time.Sleep(60 * time.Second)
+		err = sleepWithContext(ctx, 60*time.Second)
+		if err != nil {
+			return fmt.Errorf("unable to wait for the toolstack restart. %w", err)
+		}
 	}
 
 	return nil
@@ -387,24 +421,24 @@ func setPool(session *xenapi.Session, poolRef xenapi.PoolRef, poolParams poolPar
 func getManagementNetworkUUID(session *xenapi.Session, coordinatorRef xenapi.HostRef) (string, error) {
 	pifRefs, err := xenapi.Host.GetPIFs(session, coordinatorRef)
 	if err != nil {
-		return "", errors.New("unable to get host PIFs. " + err.Error())
+		return "", fmt.Errorf("unable to get host PIFs. %w", err)
 	}
 
 	for _, pifRef := range pifRefs {
 		isManagement, err := xenapi.PIF.GetManagement(session, pifRef)
 		if err != nil {
-			return "", errors.New("unable to get PIF management. " + err.Error())
+			return "", fmt.Errorf("unable to get PIF management. %w", err)
 		}
 
 		if isManagement {
 			networkRef, err := xenapi.PIF.GetNetwork(session, pifRef)
 			if err != nil {
-				return "", errors.New("unable to get PIF network. " + err.Error())
+				return "", fmt.Errorf("unable to get PIF network. %w", err)
 			}
 
 			networkRecord, err := xenapi.Network.GetRecord(session, networkRef)
 			if err != nil {
-				return "", errors.New("unable to get network record. " + err.Error())
+				return "", fmt.Errorf("unable to get network record. %w", err)
 			}
 
 			return networkRecord.UUID, nil
@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/function"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
@@ -31,10 +34,12 @@ type xsProvider struct {
 	version         string
 	session         *xenapi.Session
 	coordinatorConf coordinatorConf
+	clientConf      clientConf
 }
 
 type coordinatorConf struct {
 	Host     string
+	Hosts    []string
 	Username string
 	Password string
 }
@@ -49,9 +54,23 @@ func New(version string) func() provider.Provider {
 
 // providerModel describes the provider data model.
 type providerModel struct {
-	Host     types.String `tfsdk:"host"`
-	Username types.String `tfsdk:"username"`
-	Password types.String `tfsdk:"password"`
+	Host                    types.String `tfsdk:"host"`
+	Hosts                   types.List   `tfsdk:"hosts"`
+	Username                types.String `tfsdk:"username"`
+	Password                types.String `tfsdk:"password"`
+	SessionID               types.String `tfsdk:"session_id"`
+	CACertificate           types.String `tfsdk:"ca_certificate"`
+	CAFile                  types.String `tfsdk:"ca_file"`
+	ClientCertificate       types.String `tfsdk:"client_certificate"`
+	ClientKey               types.String `tfsdk:"client_key"`
+	InsecureSkipVerify      types.Bool   `tfsdk:"insecure_skip_verify"`
+	CertificateFingerprints types.Set    `tfsdk:"certificate_fingerprints"`
+	MaxRetries              types.Int64  `tfsdk:"max_retries"`
+	RetryMaxInterval        types.String `tfsdk:"retry_max_interval"`
+	RetryableErrors         types.List   `tfsdk:"retryable_errors"`
+	APILogFile              types.String `tfsdk:"api_log_file"`
+	RecordCacheTTL          types.String `tfsdk:"record_cache_ttl"`
+	ReadOnly                types.Bool   `tfsdk:"read_only"`
 }
 
 func (p *xsProvider) Metadata(_ context.Context, _ provider.MetadataRequest, resp *provider.MetadataResponse) {
@@ -68,17 +87,95 @@ func (p *xsProvider) Schema(_ context.Context, _ provider.SchemaRequest, resp *p
 					"Can be set by using the environment variable **XENSERVER_HOST**.",
 				Optional: true,
 			},
+			"hosts": schema.ListAttribute{
+				MarkdownDescription: "The list of addresses of the hosts in the pool, tried in order after `host` when logging in, and used to find the new coordinator when the current one stops answering, e.g. after an HA failover." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_HOSTS** with comma separated values." +
+					"\n\n-> **Note:** When the address is a pool supporter, the provider follows the redirect to the pool coordinator.",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
 			"username": schema.StringAttribute{
-				MarkdownDescription: "The user name of target XenServer host." + "<br />" +
+				MarkdownDescription: "The user name of target XenServer host, which can be a user of the external authentication, e.g. Active Directory, when it is enabled on the pool. Conflicts with `session_id`." + "<br />" +
 					"Can be set by using the environment variable **XENSERVER_USERNAME**.",
 				Optional: true,
 			},
 			"password": schema.StringAttribute{
-				MarkdownDescription: "The password of target XenServer host." + "<br />" +
+				MarkdownDescription: "The password of target XenServer host. Conflicts with `session_id`." + "<br />" +
 					"Can be set by using the environment variable **XENSERVER_PASSWORD**.",
 				Optional:  true,
 				Sensitive: true,
 			},
+			"session_id": schema.StringAttribute{
+				MarkdownDescription: "The reference of an existing XAPI session to use instead of `username` and `password`, e.g. `OpaqueRef:...` obtained by a credential broker. The session is neither logged in again when it expires nor logged out by the provider. Conflicts with `username` and `password`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_SESSION_ID**." +
+					"\n\n-> **Note:** `join_supporters` of `xenserver_pool` requires `username` and `password`, which the supporters use to join the pool.",
+				Optional:  true,
+				Sensitive: true,
+			},
+			"ca_certificate": schema.StringAttribute{
+				MarkdownDescription: "The PEM encoded CA certificate used to verify the certificate of XenServer hosts. Conflicts with `ca_file`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_CA_CERTIFICATE**.",
+				Optional: true,
+			},
+			"ca_file": schema.StringAttribute{
+				MarkdownDescription: "The path of a PEM encoded CA certificate file used to verify the certificate of XenServer hosts. Conflicts with `ca_certificate`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_CA_FILE**.",
+				Optional: true,
+			},
+			"client_certificate": schema.StringAttribute{
+				MarkdownDescription: "The PEM encoded client certificate presented to XenServer hosts, requires `client_key`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_CLIENT_CERTIFICATE**.",
+				Optional: true,
+			},
+			"client_key": schema.StringAttribute{
+				MarkdownDescription: "The PEM encoded private key of `client_certificate`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_CLIENT_KEY**.",
+				Optional:  true,
+				Sensitive: true,
+			},
+			"insecure_skip_verify": schema.BoolAttribute{
+				MarkdownDescription: "Set to `true` to skip the verification of the certificate of XenServer hosts, only for test environments with self-signed certificates. Set to `false` to verify the certificate with the system CA certificates." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_INSECURE_SKIP_VERIFY**." +
+					"\n\n-> **Note:** When none of `insecure_skip_verify`, `ca_certificate`, `ca_file` and `certificate_fingerprints` is set, the certificate is not verified to keep the behavior of earlier versions.",
+				Optional: true,
+			},
+			"certificate_fingerprints": schema.SetAttribute{
+				MarkdownDescription: "The set of SHA-256 fingerprints of the certificates the XenServer hosts are allowed to present, e.g. `AB:CD:...` or `abcd...`. The certificate chain is verified as well when `ca_certificate` or `ca_file` is set. Include the fingerprints of the hosts in `join_supporters` of `xenserver_pool`, if any." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_CERTIFICATE_FINGERPRINTS** with comma separated values.",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"max_retries": schema.Int64Attribute{
+				MarkdownDescription: "The maximum number of times a XAPI call is sent again after a transient error, see `retryable_errors`. Set to `0` to disable the retries, default to be `3`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_MAX_RETRIES**.",
+				Optional: true,
+			},
+			"retry_max_interval": schema.StringAttribute{
+				MarkdownDescription: "The maximum interval between two retries of a XAPI call, the interval grows exponentially up to this value, e.g. `10s` or `1m`. Default to be `30s`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_RETRY_MAX_INTERVAL**.",
+				Optional: true,
+			},
+			"retryable_errors": schema.ListAttribute{
+				MarkdownDescription: "The XAPI error codes after which a call is retried, default to be `[\"OTHER_OPERATION_IN_PROGRESS\", \"VDI_IN_USE\", \"HOST_OFFLINE\"]`. Failures to connect to the host are always retried, a connection reset is retried for calls that only read data." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_RETRYABLE_ERRORS** with comma separated values.",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"api_log_file": schema.StringAttribute{
+				MarkdownDescription: "The path of a file the XAPI calls are appended to as JSON lines, with the method, the parameters, the duration and the result of each call, e.g. for incident reviews. The session references, passwords and secrets are masked." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_API_LOG_FILE**.",
+				Optional: true,
+			},
+			"read_only": schema.BoolAttribute{
+				MarkdownDescription: "Whether the provider only reads the pool, e.g. to run `terraform plan` in audit pipelines. The creation, the update and the deletion of the resources fail before any change is sent to the pool. The provider warns when the role of the subject logged in doesn't match the mode, e.g. a read-only provider should use the Read Only role. Default to be `false`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_READ_ONLY**.",
+				Optional: true,
+			},
+			"record_cache_ttl": schema.StringAttribute{
+				MarkdownDescription: "How long the records of a class returned by XAPI, e.g. all the VMs, are reused by the resources and the data sources, which cuts the time of a plan on large pools, e.g. `10s` or `1m`. The records are read again once the provider changes an object of the pool. Set to `0s` to disable the cache, default to be `10s`." + "<br />" +
+					"Can be set by using the environment variable **XENSERVER_RECORD_CACHE_TTL**.",
+				Optional: true,
+			},
 		},
 	}
 }
@@ -96,6 +193,7 @@ func (p *xsProvider) Configure(ctx context.Context, req provider.ConfigureReques
 	host := os.Getenv("XENSERVER_HOST")
 	username := os.Getenv("XENSERVER_USERNAME")
 	password := os.Getenv("XENSERVER_PASSWORD")
+	sessionID := os.Getenv("XENSERVER_SESSION_ID")
 
 	if !data.Host.IsNull() {
 		host = data.Host.ValueString()
@@ -106,6 +204,28 @@ func (p *xsProvider) Configure(ctx context.Context, req provider.ConfigureReques
 	if !data.Password.IsNull() {
 		password = data.Password.ValueString()
 	}
+	if !data.SessionID.IsNull() {
+		sessionID = data.SessionID.ValueString()
+	}
+
+	hosts := []string{}
+	if value := os.Getenv("XENSERVER_HOSTS"); value != "" {
+		for _, candidate := range strings.Split(value, ",") {
+			if strings.TrimSpace(candidate) != "" {
+				hosts = append(hosts, strings.TrimSpace(candidate))
+			}
+		}
+	}
+	if !data.Hosts.IsNull() {
+		hosts = []string{}
+		resp.Diagnostics.Append(data.Hosts.ElementsAs(ctx, &hosts, false)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+	if host == "" && len(hosts) > 0 {
+		host = hosts[0]
+	}
 
 	// If any of the expected configurations are missing, return
 	// errors with provider-specific guidance.
@@ -115,25 +235,33 @@ func (p *xsProvider) Configure(ctx context.Context, req provider.ConfigureReques
 			path.Root("host"),
 			"Missing Host Configuration",
 			"The provider cannot create the XenServer API client as there is a missing or empty value for the host. "+
-				"Set the host value in the configuration or use the XENSERVER_HOST environment variable. "+
+				"Set the host or hosts value in the configuration or use the XENSERVER_HOST or XENSERVER_HOSTS environment variable. "+
 				"If either is already set, ensure the value is not empty.",
 		)
 	}
-	if username == "" {
+	if sessionID != "" && (username != "" || password != "") {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("session_id"),
+			"Conflicting Authentication Configuration",
+			"The provider cannot create the XenServer API client as both a session ID and a username or password are set. "+
+				"Set either the session_id value or the username and password values, in the configuration or with the environment variables.",
+		)
+	}
+	if sessionID == "" && username == "" {
 		resp.Diagnostics.AddAttributeError(
 			path.Root("username"),
 			"Missing Username Configuration",
 			"The provider cannot create the XenServer API client as there is a missing or empty value for the username. "+
-				"Set the username value in the configuration or use the XENSERVER_USERNAME environment variable. "+
+				"Set the username value in the configuration or use the XENSERVER_USERNAME environment variable, or set a session ID instead. "+
 				"If either is already set, ensure the value is not empty.",
 		)
 	}
-	if password == "" {
+	if sessionID == "" && password == "" {
 		resp.Diagnostics.AddAttributeError(
 			path.Root("password"),
 			"Missing Password Configuration",
 			"The provider cannot create the XenServer API client as there is a missing or empty value for the password. "+
-				"Set the password value in the configuration or use the XENSERVER_PASSWORD environment variable. "+
+				"Set the password value in the configuration or use the XENSERVER_PASSWORD environment variable, or set a session ID instead. "+
 				"If either is already set, ensure the value is not empty.",
 		)
 	}
@@ -142,11 +270,26 @@ func (p *xsProvider) Configure(ctx context.Context, req provider.ConfigureReques
 		return
 	}
 
+	clientConf, diags := getClientConf(ctx, data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if legacyTLSVerify(&clientConf) {
+		tflog.Warn(ctx, "The certificate of XenServer hosts is not verified, set insecure_skip_verify, ca_certificate, ca_file or certificate_fingerprints to configure the verification")
+	}
+
 	ctx = tflog.SetField(ctx, "host", host)
 	ctx = tflog.SetField(ctx, "username", username)
 	tflog.Debug(ctx, "Creating XenServer API session")
 
-	session, err := loginServer(host, username, password)
+	var session *xenapi.Session
+	var err error
+	if sessionID != "" {
+		session, err = attachSession(host, hosts, sessionID, &clientConf)
+	} else {
+		session, err = loginCoordinator(host, hosts, username, password, &clientConf)
+	}
 	if err != nil {
 		resp.Diagnostics.AddError(
 			"Unable to create XenServer API client",
@@ -157,9 +300,13 @@ func (p *xsProvider) Configure(ctx context.Context, req provider.ConfigureReques
 		return
 	}
 
+	resp.Diagnostics.Append(checkRbacPermissions(session, clientConf.ReadOnly)...)
+
 	p.coordinatorConf.Host = host
+	p.coordinatorConf.Hosts = hosts
 	p.coordinatorConf.Username = username
 	p.coordinatorConf.Password = password
+	p.clientConf = clientConf
 	p.session = session
 
 	// the xsProvider type itself is made available for resources and data sources
@@ -167,27 +314,210 @@ func (p *xsProvider) Configure(ctx context.Context, req provider.ConfigureReques
 	resp.ResourceData = p
 }
 
-func loginServer(host string, username string, password string) (*xenapi.Session, error) {
+func getClientConf(ctx context.Context, data providerModel) (clientConf, diag.Diagnostics) {
+	var conf clientConf
+	var diags diag.Diagnostics
+
+	conf.CACertificate = os.Getenv("XENSERVER_CA_CERTIFICATE")
+	conf.CAFile = os.Getenv("XENSERVER_CA_FILE")
+	conf.ClientCertificate = os.Getenv("XENSERVER_CLIENT_CERTIFICATE")
+	conf.ClientKey = os.Getenv("XENSERVER_CLIENT_KEY")
+	if value := os.Getenv("XENSERVER_INSECURE_SKIP_VERIFY"); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			diags.AddAttributeError(
+				path.Root("insecure_skip_verify"),
+				"Invalid Insecure Skip Verify Configuration",
+				"The value of the XENSERVER_INSECURE_SKIP_VERIFY environment variable must be a boolean, got: "+value,
+			)
+		}
+		conf.InsecureSkipVerify = &insecure
+	}
+	if value := os.Getenv("XENSERVER_READ_ONLY"); value != "" {
+		readOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			diags.AddAttributeError(
+				path.Root("read_only"),
+				"Invalid Read Only Configuration",
+				"The value of the XENSERVER_READ_ONLY environment variable must be a boolean, got: "+value,
+			)
+		}
+		conf.ReadOnly = readOnly
+	}
+	if value := os.Getenv("XENSERVER_CERTIFICATE_FINGERPRINTS"); value != "" {
+		for _, fingerprint := range strings.Split(value, ",") {
+			if strings.TrimSpace(fingerprint) != "" {
+				conf.Fingerprints = append(conf.Fingerprints, strings.TrimSpace(fingerprint))
+			}
+		}
+	}
+
+	conf.Retry = defaultRetryConf()
+	if value := os.Getenv("XENSERVER_MAX_RETRIES"); value != "" {
+		maxRetries, err := strconv.Atoi(value)
+		if err != nil {
+			diags.AddAttributeError(
+				path.Root("max_retries"),
+				"Invalid Max Retries Configuration",
+				"The value of the XENSERVER_MAX_RETRIES environment variable must be an integer, got: "+value,
+			)
+		}
+		conf.Retry.MaxRetries = maxRetries
+	}
+	retryMaxInterval := os.Getenv("XENSERVER_RETRY_MAX_INTERVAL")
+	recordCacheTTL := os.Getenv("XENSERVER_RECORD_CACHE_TTL")
+	conf.RecordCacheTTL = defaultRecordCacheTTL
+	conf.APILogFile = os.Getenv("XENSERVER_API_LOG_FILE")
+	conf.CassetteFile = os.Getenv("XENSERVER_CASSETTE_RECORD")
+	if value := os.Getenv("XENSERVER_RETRYABLE_ERRORS"); value != "" {
+		conf.Retry.RetryableErrors = []string{}
+		for _, code := range strings.Split(value, ",") {
+			if strings.TrimSpace(code) != "" {
+				conf.Retry.RetryableErrors = append(conf.Retry.RetryableErrors, strings.TrimSpace(code))
+			}
+		}
+	}
+
+	if !data.CACertificate.IsNull() {
+		conf.CACertificate = data.CACertificate.ValueString()
+	}
+	if !data.CAFile.IsNull() {
+		conf.CAFile = data.CAFile.ValueString()
+	}
+	if !data.ClientCertificate.IsNull() {
+		conf.ClientCertificate = data.ClientCertificate.ValueString()
+	}
+	if !data.ClientKey.IsNull() {
+		conf.ClientKey = data.ClientKey.ValueString()
+	}
+	if !data.InsecureSkipVerify.IsNull() {
+		insecure := data.InsecureSkipVerify.ValueBool()
+		conf.InsecureSkipVerify = &insecure
+	}
+	if !data.CertificateFingerprints.IsNull() {
+		conf.Fingerprints = []string{}
+		diags.Append(data.CertificateFingerprints.ElementsAs(ctx, &conf.Fingerprints, false)...)
+	}
+	if !data.MaxRetries.IsNull() {
+		conf.Retry.MaxRetries = int(data.MaxRetries.ValueInt64())
+	}
+	if !data.RetryMaxInterval.IsNull() {
+		retryMaxInterval = data.RetryMaxInterval.ValueString()
+	}
+	if retryMaxInterval != "" {
+		interval, err := time.ParseDuration(retryMaxInterval)
+		if err != nil {
+			diags.AddAttributeError(
+				path.Root("retry_max_interval"),
+				"Invalid Retry Max Interval Configuration",
+				"The value of retry_max_interval must be a duration, e.g. 30s, got: "+retryMaxInterval,
+			)
+		}
+		conf.Retry.RetryMaxInterval = interval
+	}
+	if !data.RetryableErrors.IsNull() {
+		conf.Retry.RetryableErrors = []string{}
+		diags.Append(data.RetryableErrors.ElementsAs(ctx, &conf.Retry.RetryableErrors, false)...)
+	}
+	if !data.APILogFile.IsNull() {
+		conf.APILogFile = data.APILogFile.ValueString()
+	}
+	if !data.ReadOnly.IsNull() {
+		conf.ReadOnly = data.ReadOnly.ValueBool()
+	}
+	if !data.RecordCacheTTL.IsNull() {
+		recordCacheTTL = data.RecordCacheTTL.ValueString()
+	}
+	if recordCacheTTL != "" {
+		ttl, err := time.ParseDuration(recordCacheTTL)
+		if err != nil {
+			diags.AddAttributeError(
+				path.Root("record_cache_ttl"),
+				"Invalid Record Cache TTL Configuration",
+				"The value of record_cache_ttl must be a duration, e.g. 10s, got: "+recordCacheTTL,
+			)
+		}
+		conf.RecordCacheTTL = ttl
+	}
+
+	if diags.HasError() {
+		return conf, diags
+	}
+
+	err := validateClientConf(&conf)
+	if err != nil {
+		diags.AddError(
+			"Invalid Client Configuration",
+			"The provider cannot create the XenServer API client as the configuration is invalid. "+err.Error(),
+		)
+	}
+
+	return conf, diags
+}
+
+// loginServer logs in to a standalone host or a pool coordinator, failing
+// with HOST_IS_SLAVE when the host is a pool supporter.
+func loginServer(host string, username string, password string, conf *clientConf) (*xenapi.Session, error) {
+	return login(host, nil, false, username, password, conf)
+}
+
+// loginCoordinator logs in to the pool coordinator through host or one of the
+// candidate hosts, following the redirect when a supporter is reached. The
+// session keeps following the coordinator if it changes later on.
+func loginCoordinator(host string, hosts []string, username string, password string, conf *clientConf) (*xenapi.Session, error) {
+	return login(host, hosts, true, username, password, conf)
+}
+
+// attachSession uses an existing session of the pool coordinator, reached
+// through host or one of the candidate hosts like loginCoordinator.
+func attachSession(host string, hosts []string, sessionID string, conf *clientConf) (*xenapi.Session, error) {
+	if host == "" || sessionID == "" {
+		return nil, errors.New("host, session ID cannot be empty")
+	}
+	return openSession(host, hosts, true, "", "", sessionID, conf)
+}
+
+func login(host string, hosts []string, followCoordinator bool, username string, password string, conf *clientConf) (*xenapi.Session, error) {
 	// check if host, username, password are non-empty
 	if host == "" || username == "" || password == "" {
 		return nil, errors.New("host, username, password cannot be empty")
 	}
+	return openSession(host, hosts, followCoordinator, username, password, "", conf)
+}
 
+// openSession creates the SDK session behind a relay to the host and logs it
+// in, the relay answering the login itself with the session ID when set.
+func openSession(host string, hosts []string, followCoordinator bool, username string, password string, sessionID string, conf *clientConf) (*xenapi.Session, error) {
 	if !strings.HasPrefix(host, "http") {
 		host = "https://" + host
 	}
 
+	relay, err := newXAPIRelay(host, conf)
+	if err != nil {
+		return nil, err
+	}
+	relay.followCoordinator = followCoordinator
+	relay.session.externalRef = sessionID
+	for _, candidate := range hosts {
+		if !strings.HasPrefix(candidate, "http") {
+			candidate = "https://" + candidate
+		}
+		relay.candidates = append(relay.candidates, candidate)
+	}
+
 	session := xenapi.NewSession(&xenapi.ClientOpts{
-		URL: host,
+		URL: relay.URL(),
 		Headers: map[string]string{
 			"User-Agent": "XenServerTerraformProvider/" + terraformProviderVersion,
 		},
 	})
 
-	_, err := session.LoginWithPassword(username, password, "1.0", "terraform provider")
+	_, err = session.LoginWithPassword(username, password, "1.0", "terraform provider")
 	if err != nil {
-		return nil, errors.New(err.Error())
+		_ = relay.Close()
+		return nil, newXAPIError(err)
 	}
+	trackSession(session, relay)
 
 	return session, nil
 }
@@ -1,8 +1,12 @@
 package xenserver
 
 import (
+	"cmp"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"testing"
 
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
@@ -25,3 +29,33 @@ provider "xenserver" {
 }
 `, os.Getenv("XENSERVER_HOST"), os.Getenv("XENSERVER_USERNAME"), os.Getenv("XENSERVER_PASSWORD"))
 )
+
+// TestMain runs the acceptance tests against the responses recorded in the
+// cassette set with XENSERVER_CASSETTE, or against an in-memory fake of XAPI
+// when no XenServer host is configured, e.g. in CI.
+func TestMain(m *testing.M) {
+	if os.Getenv("TF_ACC") == "" || (os.Getenv("XENSERVER_HOST") != "" && os.Getenv("XENSERVER_CASSETTE") == "") {
+		os.Exit(m.Run())
+	}
+
+	var handler http.Handler = newFakeXAPI("root", "password")
+	if cassette := os.Getenv("XENSERVER_CASSETTE"); cassette != "" {
+		replayer, err := newCassetteReplayer(cassette)
+		if err != nil {
+			fmt.Println("unable to load the cassette " + cassette + ". " + err.Error()) //nolint:forbidigo // the tests can't start
+			os.Exit(1)
+		}
+		handler = replayer
+	}
+	server := httptest.NewServer(handler)
+	providerConfig = fmt.Sprintf(`
+provider "xenserver" {
+	host     = "%s"
+	username = "%s"
+	password = "%s"
+}
+`, server.URL, cmp.Or(os.Getenv("XENSERVER_USERNAME"), "root"), cmp.Or(os.Getenv("XENSERVER_PASSWORD"), "password"))
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
@@ -0,0 +1,114 @@
+package xenserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+
+	"xenapi"
+)
+
+// readOnlyErrorCode is the error code of the calls refused by the relay of a
+// read-only session, in the format of the XAPI errors.
+const readOnlyErrorCode = "PROVIDER_READ_ONLY"
+
+// writePermissions are the RBAC permissions of the main operations of the
+// resources, which the roles allowed to change the pool have, e.g. VM Admin,
+// and the Read Only role doesn't.
+var writePermissions = []string{"vm.clone", "vm.destroy", "vdi.create", "sr.create", "network.create"}
+
+// isReadOnlyAllowedMethod reports whether a call is allowed in read-only mode,
+// i.e. it doesn't change the pool. The assert_* calls only check an operation,
+// e.g. while planning.
+func isReadOnlyAllowedMethod(method string) bool {
+	_, name, _ := strings.Cut(method, ".")
+	return isReadOnlyMethod(method) || strings.HasPrefix(name, "assert_") || slices.Contains([]string{"session.logout", "event.from"}, method)
+}
+
+// newReadOnlyResponse returns the response of a call refused by the relay of a
+// read-only session.
+func newReadOnlyResponse(request *xapiRequest) *relayResponse {
+	body, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"error":   map[string]any{"code": 1, "message": readOnlyErrorCode, "data": []string{request.Method}},
+		"id":      request.ID,
+	})
+	return &relayResponse{
+		status: http.StatusOK,
+		header: http.Header{"Content-Type": []string{"application/json"}},
+		body:   body,
+	}
+}
+
+// isReadOnlySession reports whether the session was opened with read_only.
+func isReadOnlySession(session *xenapi.Session) bool {
+	relay, ok := getSessionRelay(session)
+	return ok && relay.readOnly
+}
+
+// readOnlyDiagnostic returns the error of an operation of a resource refused
+// in read-only mode, before any change is sent to the pool.
+func readOnlyDiagnostic(operation string) diag.Diagnostic {
+	return diag.NewErrorDiagnostic(
+		"Unable to "+operation+" the resource in read-only mode",
+		"The provider is configured with read_only, so it doesn't change the pool. Unset read_only of the provider to apply the changes.",
+	)
+}
+
+// getRbacPermissions returns the RBAC permissions of the subject logged in
+// with the session.
+func getRbacPermissions(session *xenapi.Session) ([]string, error) {
+	relay, ok := getSessionRelay(session)
+	if !ok {
+		return nil, errors.New("unable to find the reference of the session")
+	}
+	relay.session.mu.Lock()
+	sessionRef := relay.session.sessionRef
+	relay.session.mu.Unlock()
+
+	permissions, err := session.GetRbacPermissions(xenapi.SessionRef(sessionRef))
+	if err != nil {
+		return nil, newXAPIError(err)
+	}
+	return permissions, nil
+}
+
+// checkRbacPermissions warns when the role of the subject logged in doesn't
+// match the read_only mode of the provider: a read-only provider should use
+// credentials which can't change the pool, e.g. of the Read Only role, and the
+// other providers need a role allowing the changes.
+func checkRbacPermissions(session *xenapi.Session, readOnly bool) diag.Diagnostics {
+	var diags diag.Diagnostics
+	permissions, err := getRbacPermissions(session)
+	if err != nil {
+		diags.Append(xapiErrorDiagnostic("Unable to get the RBAC permissions of the session", err))
+		return diags
+	}
+
+	canWrite := slices.ContainsFunc(permissions, func(permission string) bool {
+		return slices.Contains(writePermissions, strings.ToLower(permission))
+	})
+	switch {
+	case readOnly && canWrite:
+		diags.AddAttributeWarning(
+			path.Root("read_only"),
+			"The credentials allow changes of the pool",
+			"The provider is configured with read_only, but the role of the subject logged in allows changes of the pool. "+
+				"The provider doesn't change the pool anyway, use the credentials of a subject with the Read Only role to make sure nothing is changed.",
+		)
+	case !readOnly && !canWrite:
+		diags.AddAttributeWarning(
+			path.Root("read_only"),
+			"The credentials don't allow changes of the pool",
+			"The role of the subject logged in doesn't allow changes of the pool, e.g. the Read Only role, so terraform apply will fail. "+
+				"Set read_only of the provider to run terraform plan only.",
+		)
+	}
+
+	return diags
+}
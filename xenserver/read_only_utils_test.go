@@ -0,0 +1,61 @@
+package xenserver
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"xenapi"
+)
+
+func TestReadOnlySession(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isReadOnlySession(session) {
+		t.Fatal("expected a read-only session")
+	}
+
+	vmRecords, err := xenapi.VM.GetAllRecords(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for vmRef := range vmRecords {
+		err = xenapi.VM.SetNameLabel(session, vmRef, "changed")
+		if err == nil || !strings.Contains(err.Error(), readOnlyErrorCode) {
+			t.Fatalf("expected the change to be refused, got: %v", err)
+		}
+		break
+	}
+
+	// the root user can change the pool
+	diags := checkRbacPermissions(session, true)
+	if diags.WarningsCount() != 1 || diags.HasError() {
+		t.Fatalf("expected a warning, got: %v", diags)
+	}
+}
+
+func TestCheckRbacPermissions(t *testing.T) {
+	// the fake host has no permission
+	server := httptest.NewServer(&fakeSessionHost{})
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isReadOnlySession(session) {
+		t.Fatal("expected a session allowed to change the pool")
+	}
+
+	diags := checkRbacPermissions(session, false)
+	if diags.WarningsCount() != 1 || diags.HasError() {
+		t.Fatalf("expected a warning, got: %v", diags)
+	}
+	diags = checkRbacPermissions(session, true)
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostic, got: %v", diags)
+	}
+}
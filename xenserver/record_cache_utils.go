@@ -0,0 +1,107 @@
+package xenserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRecordCacheTTL = 10 * time.Second
+
+// recordCache keeps the results of the get_all_records calls of a relay by
+// class, e.g. "VM.get_all_records", so that the resources and the data sources
+// reading the same class during a plan or an apply share one call. All the
+// results are dropped once a call may have changed an object of the pool.
+type recordCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]recordCacheEntry
+	// generation is increased by each invalidation, the results of the calls
+	// sent before it are not kept.
+	generation int
+}
+
+type recordCacheEntry struct {
+	result  json.RawMessage
+	expires time.Time
+}
+
+// newRecordCache returns the cache of a relay, nil when the TTL disables it.
+func newRecordCache(ttl time.Duration) *recordCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &recordCache{ttl: ttl, entries: map[string]recordCacheEntry{}}
+}
+
+func isCachedMethod(method string) bool {
+	return strings.HasSuffix(method, ".get_all_records")
+}
+
+// invalidatesRecordCache reports whether a call may change the objects of the
+// pool. The calls of the tasks are included as the asynchronous operations
+// change the objects while they run, and the events report such changes.
+func invalidatesRecordCache(method string) bool {
+	class, _, _ := strings.Cut(strings.ToLower(method), ".")
+	return !isReadOnlyMethod(method) || class == "task" || class == "event"
+}
+
+// lookup returns the cached response of the request, if any, and the
+// generation to store the response of the host with.
+func (c *recordCache) lookup(request *xapiRequest) (*relayResponse, int) {
+	if c == nil {
+		return nil, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if invalidatesRecordCache(request.Method) {
+		c.invalidateLocked()
+		return nil, c.generation
+	}
+	entry, ok := c.entries[request.Method]
+	if !ok || !isCachedMethod(request.Method) || time.Now().After(entry.expires) {
+		return nil, c.generation
+	}
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "result": entry.result, "id": request.ID})
+	if err != nil {
+		return nil, c.generation
+	}
+
+	return &relayResponse{
+		status: http.StatusOK,
+		header: http.Header{"Content-Type": []string{"application/json"}},
+		body:   body,
+	}, c.generation
+}
+
+// store keeps the result of a successful get_all_records call, unless the
+// cache was invalidated since the call was sent. The calls changing the pool
+// invalidate the cache again once they are done.
+func (c *recordCache) store(request *xapiRequest, generation int, resp *relayResponse, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if invalidatesRecordCache(request.Method) {
+		c.invalidateLocked()
+		return
+	}
+	if !isCachedMethod(request.Method) || err != nil || resp.status != http.StatusOK || generation != c.generation {
+		return
+	}
+	response := parseXAPIResponse(resp.body)
+	if response == nil || response.Error != nil || len(response.Result) == 0 {
+		return
+	}
+	c.entries[request.Method] = recordCacheEntry{result: response.Result, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *recordCache) invalidateLocked() {
+	c.generation++
+	clear(c.entries)
+}
@@ -0,0 +1,55 @@
+package xenserver
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"xenapi"
+)
+
+func TestRecordCache(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		ttl      time.Duration
+		expected int
+	}{
+		{name: "enabled", ttl: time.Minute, expected: 2},
+		{name: "disabled", ttl: 0, expected: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			host := &fakeSessionHost{members: []string{"192.0.2.1"}}
+			server := httptest.NewServer(host)
+			defer server.Close()
+
+			session, err := loginServer(server.URL, "root", "password", &clientConf{RecordCacheTTL: tc.ttl})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				records, err := xenapi.Host.GetAllRecords(session)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(records) != 1 {
+					t.Fatalf("unexpected records: %v", records)
+				}
+			}
+			// the records are read again once an object is changed
+			err = xenapi.VM.SetNameLabel(session, "OpaqueRef:vm", "vm")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = xenapi.Host.GetAllRecords(session)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			host.mu.Lock()
+			defer host.mu.Unlock()
+			if host.calls["host.get_all_records"] != tc.expected {
+				t.Fatalf("expected %d calls, got %d", tc.expected, host.calls["host.get_all_records"])
+			}
+		})
+	}
+}
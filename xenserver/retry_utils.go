@@ -0,0 +1,63 @@
+package xenserver
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+const (
+	defaultMaxRetries       = 3
+	defaultRetryMaxInterval = 30 * time.Second
+)
+
+// defaultRetryableErrors are the XAPI error codes of operations that usually
+// succeed once the concurrent operation on the same object is finished.
+var defaultRetryableErrors = []string{"OTHER_OPERATION_IN_PROGRESS", "VDI_IN_USE", "HOST_OFFLINE"}
+
+// retryConf is the retry policy of the XAPI calls sent through the relay.
+type retryConf struct {
+	MaxRetries       int
+	RetryMaxInterval time.Duration
+	RetryableErrors  []string
+}
+
+func defaultRetryConf() retryConf {
+	return retryConf{
+		MaxRetries:       defaultMaxRetries,
+		RetryMaxInterval: defaultRetryMaxInterval,
+		RetryableErrors:  slices.Clone(defaultRetryableErrors),
+	}
+}
+
+func (c *retryConf) newBackOff(ctx context.Context) backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.MaxInterval = c.RetryMaxInterval
+	// the number of retries bounds the policy, not the elapsed time
+	b.MaxElapsedTime = 0
+	if b.InitialInterval > b.MaxInterval {
+		b.InitialInterval = b.MaxInterval
+	}
+
+	return backoff.WithContext(backoff.WithMaxRetries(b, uint64(c.MaxRetries)), ctx) //nolint:gosec // MaxRetries is validated not to be negative
+}
+
+// isConnectionReset reports whether the host closed the connection, e.g. when
+// xapi restarts.
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// shouldRetry reports whether a request can be sent again after a transient
+// failure. Connection failures are only retried when the request did not
+// reach the host or only reads data, as the host may have executed it.
+func (c *retryConf) shouldRetry(request *xapiRequest, resp *relayResponse, err error) bool {
+	if err != nil {
+		return isDialError(err) || (isConnectionReset(err) && isReadOnlyMethod(request.Method))
+	}
+	return slices.Contains(c.RetryableErrors, getErrorCode(resp))
+}
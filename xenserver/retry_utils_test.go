@@ -0,0 +1,51 @@
+package xenserver
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"xenapi"
+)
+
+func TestRelayRetry(t *testing.T) {
+	testCases := []struct {
+		name    string
+		busy    int
+		success bool
+	}{
+		{"retried until the operation is done", 2, true},
+		{"retries exhausted", 4, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			host := &fakeSessionHost{}
+			server := httptest.NewServer(host)
+			defer server.Close()
+
+			conf := clientConf{Retry: retryConf{
+				MaxRetries:       3,
+				RetryMaxInterval: time.Millisecond,
+				RetryableErrors:  defaultRetryableErrors,
+			}}
+			session, err := loginServer(server.URL, "root", "password", &conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = logoutSession(t.Context(), session) }()
+
+			host.mu.Lock()
+			host.busy = tc.busy
+			host.mu.Unlock()
+
+			_, err = xenapi.VM.GetAll(session)
+			if tc.success && err != nil {
+				t.Fatalf("expected the call to be retried, got: %v", err)
+			}
+			if !tc.success && (err == nil || !strings.Contains(err.Error(), "OTHER_OPERATION_IN_PROGRESS")) {
+				t.Fatalf("expected OTHER_OPERATION_IN_PROGRESS, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,564 @@
+package xenserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"xenapi"
+)
+
+// xapiRequest is the JSON-RPC envelope of a XAPI call, params are kept raw so
+// they are relayed unchanged.
+type xapiRequest struct {
+	JSONRPC string            `json:"jsonrpc,omitempty"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+}
+
+type xapiResponse struct {
+	Result json.RawMessage  `json:"result"`
+	Error  *xapiErrorObject `json:"error"`
+}
+
+// xapiErrorObject is the error object of a failed XAPI call, the message is
+// the XAPI error code, e.g. SESSION_INVALID, and data holds its parameters.
+type xapiErrorObject struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// relaySession follows the session of the SDK through the relay so that it
+// can be logged in again once XAPI invalidates it, e.g. when xapi restarts on
+// the coordinator or the session times out. The SDK keeps using the reference
+// it got at login, which the relay maps to the current one.
+type relaySession struct {
+	mu         sync.Mutex
+	login      *xapiRequest
+	path       string
+	header     http.Header
+	sessionRef string
+	currentRef string
+	// members are the addresses of the pool hosts seen at the last login,
+	// the candidates to fail over to when the coordinator changes.
+	members []string
+	// externalRef is the existing session the provider is configured with
+	// instead of credentials. It is neither logged in again nor logged out,
+	// as it belongs to whoever created it.
+	externalRef string
+}
+
+var sessionLoginMethods = []string{"session.login_with_password"}
+
+var sessionExpiredErrors = []string{"SESSION_INVALID", "SESSION_NOT_REGISTERED"}
+
+// sessionLogoutTimeout bounds the logout of a session, Terraform does not wait
+// long for the provider to exit once it has been stopped.
+const sessionLogoutTimeout = 2 * time.Second
+
+// openSessions are the sessions logged in by the provider with their relay,
+// they are logged out once no longer used or when the provider stops.
+var openSessions = struct {
+	mu     sync.Mutex
+	relays map[*xenapi.Session]*xapiRelay
+}{relays: map[*xenapi.Session]*xapiRelay{}}
+
+func trackSession(session *xenapi.Session, relay *xapiRelay) {
+	openSessions.mu.Lock()
+	defer openSessions.mu.Unlock()
+	openSessions.relays[session] = relay
+}
+
+// getSessionRelay returns the relay of a session logged in by the provider.
+func getSessionRelay(session *xenapi.Session) (*xapiRelay, bool) {
+	openSessions.mu.Lock()
+	defer openSessions.mu.Unlock()
+	relay, ok := openSessions.relays[session]
+	return relay, ok
+}
+
+// logoutSession logs out a session opened by loginServer or loginCoordinator
+// and stops its relay.
+func logoutSession(ctx context.Context, session *xenapi.Session) error {
+	openSessions.mu.Lock()
+	relay, ok := openSessions.relays[session]
+	delete(openSessions.relays, session)
+	openSessions.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sessionLogoutTimeout)
+	defer cancel()
+	err := relay.logout(ctx)
+	_ = relay.Close()
+
+	return err
+}
+
+// LogoutSessions logs out all the sessions still opened by the provider, it is
+// meant to be called once the provider server has stopped.
+func LogoutSessions() {
+	openSessions.mu.Lock()
+	sessions := slices.Collect(maps.Keys(openSessions.relays))
+	openSessions.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = logoutSession(context.Background(), session)
+		}()
+	}
+	wg.Wait()
+}
+
+func newXAPIRequest(method string, sessionRef string) (*xapiRequest, error) {
+	sessionParam, err := json.Marshal(sessionRef)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode session ref: %w", err)
+	}
+	return &xapiRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  []json.RawMessage{sessionParam},
+		ID:      json.RawMessage("0"),
+	}, nil
+}
+
+func parseXAPIResponse(body []byte) *xapiResponse {
+	var response xapiResponse
+	if json.Unmarshal(body, &response) != nil {
+		return nil
+	}
+	return &response
+}
+
+func getErrorCode(resp *relayResponse) string {
+	response := parseXAPIResponse(resp.body)
+	if response == nil || response.Error == nil {
+		return ""
+	}
+	return response.Error.Message
+}
+
+// getErrorParams returns the parameters of a XAPI failure, e.g. the address
+// of the coordinator for HOST_IS_SLAVE.
+func getErrorParams(resp *relayResponse) []string {
+	response := parseXAPIResponse(resp.body)
+	if response == nil || response.Error == nil {
+		return nil
+	}
+	var params []string
+	if json.Unmarshal(response.Error.Data, &params) != nil {
+		return nil
+	}
+	return params
+}
+
+// isReadOnlyMethod reports whether a XAPI call can be sent again without
+// side effects when its outcome is unknown.
+func isReadOnlyMethod(method string) bool {
+	_, name, _ := strings.Cut(method, ".")
+	return strings.HasPrefix(name, "get_")
+}
+
+// isDialError reports whether the request failed before reaching the host.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// call relays one request to the host. It logs in again when the session of
+// the request has expired and, for the coordinator session, moves to the new
+// coordinator when the current one stops answering or is demoted, then sends
+// the request once more. Transient failures are retried with the retry policy
+// of the relay. The results of get_all_records are answered from the record
+// cache of the relay while they are fresh.
+func (r *xapiRelay) call(ctx context.Context, path string, header http.Header, body []byte) (*relayResponse, error) {
+	var request xapiRequest
+	if json.Unmarshal(body, &request) != nil || request.Method == "" {
+		return r.send(ctx, r.getUpstream(), path, header, body)
+	}
+
+	if slices.Contains(sessionLoginMethods, request.Method) {
+		if r.session.externalRef != "" {
+			return r.sessionAttach(ctx, path, header, &request)
+		}
+		return r.sessionLogin(ctx, path, header, &request)
+	}
+
+	if r.readOnly && !isReadOnlyAllowedMethod(request.Method) {
+		return newReadOnlyResponse(&request), nil
+	}
+
+	cached, generation := r.records.lookup(&request)
+	if cached != nil {
+		return cached, nil
+	}
+	resp, err := r.callRetry(ctx, path, header, &request)
+	r.records.store(&request, generation, resp, err)
+
+	return resp, err
+}
+
+// callRetry sends a request of the session, retrying the transient failures
+// with the retry policy of the relay.
+func (r *xapiRelay) callRetry(ctx context.Context, path string, header http.Header, request *xapiRequest) (*relayResponse, error) {
+	b := r.retry.newBackOff(ctx)
+	for {
+		resp, err := r.callSession(ctx, path, header, request)
+		if !r.retry.shouldRetry(request, resp, err) {
+			return resp, err
+		}
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			return resp, err
+		}
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// callSession sends a request of the session, logging in again or moving to
+// the new coordinator once if needed.
+func (r *xapiRelay) callSession(ctx context.Context, path string, header http.Header, request *xapiRequest) (*relayResponse, error) {
+	relogged, movedOver := false, false
+	for {
+		usedRef := r.session.mapRef(request)
+		upstream := r.getUpstream()
+		resp, err := r.sendRequest(ctx, upstream, path, header, request)
+		if usedRef == "" || request.Method == "session.logout" {
+			return resp, err
+		}
+
+		if err != nil || getErrorCode(resp) == "HOST_IS_SLAVE" {
+			if movedOver || !r.followCoordinator {
+				return resp, err
+			}
+			movedOver = true
+			var hints []string
+			if err == nil {
+				hints = getErrorParams(resp)
+			}
+			if r.coordinatorFailover(ctx, path, header, upstream, hints) != nil {
+				return resp, err
+			}
+			// the host may have executed the call before it failed
+			if err != nil && !isDialError(err) && !isReadOnlyMethod(request.Method) {
+				return resp, err
+			}
+			continue
+		}
+
+		if slices.Contains(sessionExpiredErrors, getErrorCode(resp)) && !relogged {
+			relogged = true
+			if r.sessionRelogin(ctx, path, header, usedRef) != nil {
+				// let the SDK get the original failure
+				return resp, nil
+			}
+			continue
+		}
+
+		return resp, nil
+	}
+}
+
+func (r *xapiRelay) sendRequest(ctx context.Context, upstream string, path string, header http.Header, request *xapiRequest) (*relayResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode request %s: %w", request.Method, err)
+	}
+	if r.audit == nil && r.cassette == nil {
+		return r.send(ctx, upstream, path, header, body)
+	}
+
+	start := time.Now()
+	resp, err := r.send(ctx, upstream, path, header, body)
+	if r.audit != nil {
+		r.audit.write(newAuditEntry(upstream, request, start, resp, err))
+	}
+	if r.cassette != nil && err == nil {
+		r.cassette.write(newCassetteInteraction(request, resp))
+	}
+
+	return resp, err
+}
+
+// sessionLogin relays the login of the SDK and keeps the request to log in
+// again with the same credentials later.
+func (r *xapiRelay) sessionLogin(ctx context.Context, path string, header http.Header, request *xapiRequest) (*relayResponse, error) {
+	r.session.mu.Lock()
+	defer r.session.mu.Unlock()
+
+	resp, err := r.findCoordinator(ctx, path, header, request, []string{r.getUpstream()})
+	if err != nil {
+		return nil, err
+	}
+
+	ref, ok := r.loginResult(ctx, path, header, resp)
+	if !ok {
+		return resp, nil
+	}
+	r.session.login = request
+	r.session.path = path
+	r.session.header = header.Clone()
+	r.session.sessionRef = ref
+	r.session.currentRef = ref
+
+	return resp, nil
+}
+
+// sessionAttach answers the login of the SDK with the existing session the
+// relay is configured with, once the host accepted it. The SDK has no other
+// way to be given a session reference.
+func (r *xapiRelay) sessionAttach(ctx context.Context, path string, header http.Header, request *xapiRequest) (*relayResponse, error) {
+	r.session.mu.Lock()
+	defer r.session.mu.Unlock()
+
+	ref := r.session.externalRef
+	check, err := newXAPIRequest("session.get_this_host", ref)
+	if err != nil {
+		return nil, err
+	}
+	check.Params = append(check.Params, check.Params[0])
+	resp, err := r.findCoordinator(ctx, path, header, check, []string{r.getUpstream()})
+	if err != nil {
+		return nil, err
+	}
+	if getErrorCode(resp) != "" {
+		return resp, nil
+	}
+
+	result, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "result": ref, "id": request.ID})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode login result: %w", err)
+	}
+	if r.followCoordinator {
+		r.session.members = r.getPoolMembers(ctx, path, header, ref)
+	}
+	r.session.path = path
+	r.session.header = header.Clone()
+	r.session.sessionRef = ref
+	r.session.currentRef = ref
+
+	return &relayResponse{
+		status: http.StatusOK,
+		header: http.Header{"Content-Type": []string{"application/json"}},
+		body:   result,
+	}, nil
+}
+
+// sessionRelogin logs in again unless another request already did it since
+// the expired session reference was used.
+func (r *xapiRelay) sessionRelogin(ctx context.Context, path string, header http.Header, expiredRef string) error {
+	r.session.mu.Lock()
+	defer r.session.mu.Unlock()
+
+	if r.session.login == nil {
+		return errors.New("no login to replay")
+	}
+	if r.session.currentRef != expiredRef {
+		return nil
+	}
+
+	resp, err := r.findCoordinator(ctx, path, header, r.session.login, []string{r.getUpstream()})
+	if err != nil {
+		return err
+	}
+	ref, ok := r.loginResult(ctx, path, header, resp)
+	if !ok {
+		return errors.New("unable to log in again to " + r.getUpstream())
+	}
+	r.session.currentRef = ref
+
+	return nil
+}
+
+// coordinatorFailover logs in to the new coordinator after the failed one,
+// trying the hinted addresses, the configured hosts and the pool members.
+func (r *xapiRelay) coordinatorFailover(ctx context.Context, path string, header http.Header, failedUpstream string, hints []string) error {
+	r.session.mu.Lock()
+	defer r.session.mu.Unlock()
+
+	if r.session.login == nil {
+		return errors.New("no login to replay")
+	}
+	if r.getUpstream() != failedUpstream {
+		// another request already moved over
+		return nil
+	}
+
+	resp, err := r.findCoordinator(ctx, path, header, r.session.login, hints)
+	if err != nil {
+		return err
+	}
+	ref, ok := r.loginResult(ctx, path, header, resp)
+	if !ok {
+		return errors.New("unable to log in to the new coordinator")
+	}
+	r.session.currentRef = ref
+
+	return nil
+}
+
+// findCoordinator sends the login request to the first hosts, then to the
+// candidate hosts, following HOST_IS_SLAVE redirects when allowed, until a
+// host answers with something else than a redirect. The relay keeps sending
+// requests to that host from then on.
+func (r *xapiRelay) findCoordinator(ctx context.Context, path string, header http.Header, login *xapiRequest, first []string) (*relayResponse, error) {
+	queue := slices.Clone(first)
+	if r.followCoordinator {
+		queue = append(queue, r.candidates...)
+		queue = append(queue, r.session.members...)
+		// the failed coordinator is tried last
+		queue = append(queue, r.getUpstream())
+	}
+
+	var lastResp *relayResponse
+	var lastErr error
+	visited := map[string]bool{}
+	for len(queue) > 0 {
+		upstream := r.hostURL(queue[0])
+		queue = queue[1:]
+		if visited[upstream] {
+			continue
+		}
+		visited[upstream] = true
+
+		resp, err := r.sendRequest(ctx, upstream, path, header, login)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if r.followCoordinator && getErrorCode(resp) == "HOST_IS_SLAVE" {
+			lastResp = resp
+			queue = append(getErrorParams(resp), queue...)
+			continue
+		}
+
+		r.setUpstream(upstream)
+		return resp, nil
+	}
+
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	return nil, lastErr
+}
+
+// loginResult returns the session reference of a successful login and, for
+// the coordinator session, refreshes the addresses of the pool members.
+func (r *xapiRelay) loginResult(ctx context.Context, path string, header http.Header, resp *relayResponse) (string, bool) {
+	response := parseXAPIResponse(resp.body)
+	var ref string
+	if response == nil || response.Error != nil || json.Unmarshal(response.Result, &ref) != nil {
+		return "", false
+	}
+
+	if r.followCoordinator {
+		r.session.members = r.getPoolMembers(ctx, path, header, ref)
+	}
+
+	return ref, true
+}
+
+func (r *xapiRelay) getPoolMembers(ctx context.Context, path string, header http.Header, ref string) []string {
+	request, err := newXAPIRequest("host.get_all_records", ref)
+	if err != nil {
+		return nil
+	}
+	resp, err := r.sendRequest(ctx, r.getUpstream(), path, header, request)
+	if err != nil {
+		return nil
+	}
+	response := parseXAPIResponse(resp.body)
+	if response == nil || response.Error != nil {
+		return nil
+	}
+	var records map[string]struct {
+		Address string `json:"address"`
+	}
+	if json.Unmarshal(response.Result, &records) != nil {
+		return nil
+	}
+
+	members := []string{}
+	for _, record := range records {
+		if record.Address != "" {
+			members = append(members, record.Address)
+		}
+	}
+	slices.Sort(members)
+
+	return members
+}
+
+// logout ends the current session on the host the relay is connected to,
+// unless the session was given to the provider.
+func (r *xapiRelay) logout(ctx context.Context) error {
+	r.session.mu.Lock()
+	defer r.session.mu.Unlock()
+
+	if r.session.currentRef == "" || r.session.externalRef != "" {
+		return nil
+	}
+	request, err := newXAPIRequest("session.logout", r.session.currentRef)
+	if err != nil {
+		return err
+	}
+	resp, err := r.sendRequest(ctx, r.getUpstream(), r.session.path, r.session.header, request)
+	if err != nil {
+		return err
+	}
+	if code := getErrorCode(resp); code != "" {
+		return errors.New("unable to log out of " + r.getUpstream() + ". " + code)
+	}
+	r.session.currentRef = ""
+
+	return nil
+}
+
+// mapRef replaces the session reference known by the SDK with the current one
+// and returns the reference the request is sent with, or "" when the request
+// does not belong to the session.
+func (s *relaySession) mapRef(request *xapiRequest) string {
+	if len(request.Params) == 0 {
+		return ""
+	}
+	var ref string
+	if json.Unmarshal(request.Params[0], &ref) != nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessionRef == "" || (ref != s.sessionRef && ref != s.currentRef) {
+		return ""
+	}
+	if ref != s.currentRef {
+		current, err := json.Marshal(s.currentRef)
+		if err != nil {
+			return ""
+		}
+		request.Params[0] = current
+	}
+
+	return s.currentRef
+}
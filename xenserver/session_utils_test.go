@@ -0,0 +1,197 @@
+package xenserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"xenapi"
+)
+
+// fakeSessionHost answers logins with a new session reference each time and
+// only accepts calls with the latest one, or redirects to the coordinator
+// when it is a supporter.
+type fakeSessionHost struct {
+	mu          sync.Mutex
+	logins      int
+	logouts     int
+	valid       string
+	coordinator string
+	members     []string
+	// busy is the number of calls failing with OTHER_OPERATION_IN_PROGRESS
+	busy int
+	// calls are the numbers of calls by method
+	calls map[string]int
+}
+
+func (h *fakeSessionHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request xapiRequest
+	_ = json.NewDecoder(r.Body).Decode(&request)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.calls == nil {
+		h.calls = map[string]int{}
+	}
+	h.calls[request.Method]++
+
+	response := map[string]any{"jsonrpc": "2.0", "id": request.ID}
+	var ref string
+	_ = json.Unmarshal(request.Params[0], &ref)
+	switch {
+	case h.coordinator != "":
+		response["error"] = map[string]any{"code": 1, "message": "HOST_IS_SLAVE", "data": []string{h.coordinator}}
+	case request.Method == "session.login_with_password":
+		h.logins++
+		h.valid = "OpaqueRef:session-" + strconv.Itoa(h.logins)
+		response["result"] = h.valid
+	case ref != h.valid:
+		response["error"] = map[string]any{"code": 1, "message": "SESSION_INVALID", "data": []string{ref}}
+	case h.busy > 0:
+		h.busy--
+		response["error"] = map[string]any{"code": 1, "message": "OTHER_OPERATION_IN_PROGRESS", "data": []string{"VM", ref}}
+	case request.Method == "session.logout":
+		h.logouts++
+		h.valid = ""
+		response["result"] = ""
+	case request.Method == "host.get_all_records":
+		records := map[string]any{}
+		for i, member := range h.members {
+			records["OpaqueRef:host-"+strconv.Itoa(i)] = map[string]string{"address": member}
+		}
+		response["result"] = records
+	default:
+		response["result"] = []string{}
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func TestRelaySessionRelogin(t *testing.T) {
+	host := &fakeSessionHost{}
+	server := httptest.NewServer(host)
+	defer server.Close()
+
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalidate the session as if xapi had restarted
+	host.mu.Lock()
+	host.valid = ""
+	host.mu.Unlock()
+
+	_, err = xenapi.VM.GetAll(session)
+	if err != nil {
+		t.Fatalf("expected the call to succeed after logging in again, got: %v", err)
+	}
+	_, err = xenapi.VM.GetAll(session)
+	if err != nil {
+		t.Fatalf("expected the new session to be reused, got: %v", err)
+	}
+	if host.logins != 2 {
+		t.Fatalf("expected 2 logins, got %d", host.logins)
+	}
+}
+
+func TestRelayFollowCoordinator(t *testing.T) {
+	coordinator := &fakeSessionHost{}
+	coordinatorServer := httptest.NewServer(coordinator)
+	defer coordinatorServer.Close()
+	supporter := &fakeSessionHost{coordinator: strings.TrimPrefix(coordinatorServer.URL, "http://")}
+	supporterServer := httptest.NewServer(supporter)
+	defer supporterServer.Close()
+
+	// a supporter is rejected by a plain login but followed by the coordinator login
+	_, err := loginServer(supporterServer.URL, "root", "password", &clientConf{})
+	if err == nil || !strings.Contains(err.Error(), "HOST_IS_SLAVE") {
+		t.Fatalf("expected HOST_IS_SLAVE, got: %v", err)
+	}
+	session, err := loginCoordinator(supporterServer.URL, nil, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.VM.GetAll(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coordinator.logins != 1 {
+		t.Fatalf("expected 1 login on the coordinator, got %d", coordinator.logins)
+	}
+}
+
+func TestRelayCoordinatorFailover(t *testing.T) {
+	newCoordinator := &fakeSessionHost{}
+	newCoordinatorServer := httptest.NewServer(newCoordinator)
+	defer newCoordinatorServer.Close()
+	oldCoordinator := &fakeSessionHost{members: []string{strings.TrimPrefix(newCoordinatorServer.URL, "http://")}}
+	oldCoordinatorServer := httptest.NewServer(oldCoordinator)
+
+	session, err := loginCoordinator(oldCoordinatorServer.URL, nil, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the coordinator goes away, the pool member learnt at login takes over
+	oldCoordinatorServer.Close()
+	_, err = xenapi.VM.GetAll(session)
+	if err != nil {
+		t.Fatalf("expected the call to be sent to the new coordinator, got: %v", err)
+	}
+	if newCoordinator.logins != 1 {
+		t.Fatalf("expected 1 login on the new coordinator, got %d", newCoordinator.logins)
+	}
+}
+
+func TestLogoutSessions(t *testing.T) {
+	hosts := []*fakeSessionHost{{}, {}}
+	for _, host := range hosts {
+		server := httptest.NewServer(host)
+		t.Cleanup(server.Close)
+		_, err := loginCoordinator(server.URL, nil, "root", "password", &clientConf{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	LogoutSessions()
+	for _, host := range hosts {
+		if host.logouts != 1 {
+			t.Fatalf("expected 1 logout, got %d", host.logouts)
+		}
+	}
+	if len(openSessions.relays) != 0 {
+		t.Fatalf("expected no open session left, got %d", len(openSessions.relays))
+	}
+}
+
+func TestAttachSession(t *testing.T) {
+	host := &fakeSessionHost{valid: "OpaqueRef:broker"}
+	server := httptest.NewServer(host)
+	defer server.Close()
+
+	_, err := attachSession(server.URL, nil, "OpaqueRef:expired", &clientConf{})
+	if err == nil || !strings.Contains(err.Error(), "SESSION_INVALID") {
+		t.Fatalf("expected SESSION_INVALID, got: %v", err)
+	}
+
+	session, err := attachSession(server.URL, nil, "OpaqueRef:broker", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.VM.GetAll(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = logoutSession(context.Background(), session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.logins != 0 || host.logouts != 0 {
+		t.Fatalf("expected the session to be neither logged in nor out, got %d logins and %d logouts", host.logins, host.logouts)
+	}
+}
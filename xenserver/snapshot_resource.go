@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
@@ -20,6 +19,7 @@ var (
 	_ resource.Resource                = &snapshotResource{}
 	_ resource.ResourceWithConfigure   = &snapshotResource{}
 	_ resource.ResourceWithImportState = &snapshotResource{}
+	_ resource.ResourceWithIdentity    = &snapshotResource{}
 )
 
 func NewSnapshotResource() resource.Resource {
@@ -35,7 +35,7 @@ func (r *snapshotResource) Metadata(_ context.Context, req resource.MetadataRequ
 	resp.TypeName = req.ProviderTypeName + "_snapshot"
 }
 
-func (r *snapshotResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *snapshotResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "Provides a VM snapshot resource.",
 		Attributes: map[string]schema.Attribute{
@@ -90,9 +90,16 @@ func (r *snapshotResource) Schema(_ context.Context, _ resource.SchemaRequest, r
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
+func (r *snapshotResource) IdentitySchema(_ context.Context, _ resource.IdentitySchemaRequest, resp *resource.IdentitySchemaResponse) {
+	resp.IdentitySchema = uuidIdentitySchema("snapshot")
+}
+
 // Set the parameter of the resource, pass value from provider
 func (r *snapshotResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
 	// Prevent panic if the provider has not been configured.
@@ -112,29 +119,52 @@ func (r *snapshotResource) Configure(_ context.Context, req resource.ConfigureRe
 }
 
 func (r *snapshotResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("create"))
+		return
+	}
+
 	var data snapshotResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	createTimeout, diags := data.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	tflog.Debug(ctx, "Creating snapshot...")
 	vmRef, err := xenapi.VM.GetByUUID(r.session, data.VM.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VM by UUID",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
+	// the VM isn't updated while it is snapshotted
+	unlock, err := lockObject(ctx, string(vmRef))
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock VM",
+			err,
+		))
+		return
+	}
+	defer unlock()
 	var snapshotRef xenapi.VMRef
 	if !data.WithMemory.IsNull() && data.WithMemory.ValueBool() {
 		vmPowerState, err := xenapi.VM.GetPowerState(r.session, vmRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to get VM power state",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 		if vmPowerState != xenapi.VMPowerStateRunning {
@@ -146,28 +176,28 @@ func (r *snapshotResource) Create(ctx context.Context, req resource.CreateReques
 		}
 		srRef, err := xenapi.VM.GetSuspendSR(r.session, vmRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to get VM suspend SR",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 		// Set the suspend SR to default SR if it is not set
 		if string(srRef) == "OpaqueRef:NULL" {
 			poolRefs, err := xenapi.Pool.GetAll(r.session)
 			if err != nil {
-				resp.Diagnostics.AddError(
+				resp.Diagnostics.Append(xapiErrorDiagnostic(
 					"Unable to get pool refs",
-					err.Error(),
-				)
+					err,
+				))
 				return
 			}
 			defaultSRRef, err := xenapi.Pool.GetDefaultSR(r.session, poolRefs[0])
 			if err != nil {
-				resp.Diagnostics.AddError(
+				resp.Diagnostics.Append(xapiErrorDiagnostic(
 					"Unable to get default SR",
-					err.Error(),
-				)
+					err,
+				))
 				return
 			}
 			srRef = defaultSRRef
@@ -175,20 +205,20 @@ func (r *snapshotResource) Create(ctx context.Context, req resource.CreateReques
 			if string(defaultSRRef) == "OpaqueRef:NULL" {
 				srRecords, err := xenapi.SR.GetAllRecords(r.session)
 				if err != nil {
-					resp.Diagnostics.AddError(
+					resp.Diagnostics.Append(xapiErrorDiagnostic(
 						"Unable to get SR records",
-						err.Error(),
-					)
+						err,
+					))
 					return
 				}
 				for _, srRecord := range srRecords {
 					if srRecord.Type == "nfs" || srRecord.Type == "lvm" {
 						srRef, err = xenapi.SR.GetByUUID(r.session, srRecord.UUID)
 						if err != nil {
-							resp.Diagnostics.AddError(
+							resp.Diagnostics.Append(xapiErrorDiagnostic(
 								"Unable to get SR UUID",
-								err.Error(),
-							)
+								err,
+							))
 							return
 						}
 						break
@@ -197,65 +227,68 @@ func (r *snapshotResource) Create(ctx context.Context, req resource.CreateReques
 			}
 			err = xenapi.VM.SetSuspendSR(r.session, vmRef, srRef)
 			if err != nil {
-				resp.Diagnostics.AddError(
+				resp.Diagnostics.Append(xapiErrorDiagnostic(
 					"Unable to set VM suspend SR",
-					err.Error(),
-				)
+					err,
+				))
 				return
 			}
 		}
 		snapshotRef, err = xenapi.VM.Checkpoint(r.session, vmRef, data.NameLabel.ValueString())
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to create snapshot with memory",
-				err.Error(),
-			)
+				err,
+				"with_memory",
+			))
 			return
 		}
 	} else {
 		snapshotRef, err = xenapi.VM.Snapshot(r.session, vmRef, data.NameLabel.ValueString(), []xenapi.VDIRef{})
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to create snapshot",
-				err.Error(),
-			)
+				err,
+				"vm_uuid",
+			))
 			return
 		}
 	}
 
 	snapshotRecord, err := xenapi.VM.GetRecord(r.session, snapshotRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get snapshot record",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupSnapshotResource(r.session, snapshotRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up snapshot resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 	err = updateSnapshotResourceModelComputed(ctx, r.session, snapshotRecord, &data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of snapshotResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupSnapshotResource(r.session, snapshotRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up snapshot resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 	tflog.Debug(ctx, "Snapshot created")
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 func (r *snapshotResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
@@ -265,21 +298,29 @@ func (r *snapshotResource) Read(ctx context.Context, req resource.ReadRequest, r
 		return
 	}
 
+	readTimeout, diags := data.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	// Overwrite data with refreshed resource state
 	snapshotRef, err := xenapi.VM.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get snapshot by UUID",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	snapshotRecord, err := xenapi.VM.GetRecord(r.session, snapshotRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get snapshot record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -293,135 +334,174 @@ func (r *snapshotResource) Read(ctx context.Context, req resource.ReadRequest, r
 
 	err = updateSnapshotResourceModel(ctx, r.session, snapshotRecord, &data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the fields of snapshotResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 func (r *snapshotResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("update"))
+		return
+	}
+
 	var plan, state snapshotResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+
+	updateTimeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
 	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 	err := snapshotResourceModelUpdateCheck(plan, state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Error update xenserver_snapshot configuration",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	// Update the resource with new configuration
 	snapshotRef, err := xenapi.VM.GetByUUID(r.session, plan.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get snapshot by UUID",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = snapshotResourceModelUpdate(r.session, snapshotRef, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update snapshot resource",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	snapshotRecord, err := xenapi.VM.GetRecord(r.session, snapshotRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get snapshot record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	if !plan.Revert.IsNull() && plan.Revert.ValueBool() {
+		// the VM isn't updated while it is reverted
+		unlock, err := lockObject(ctx, string(snapshotRecord.SnapshotOf))
+		if err != nil {
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
+				"Unable to lock VM",
+				err,
+			))
+			return
+		}
+		defer unlock()
+
 		tflog.Debug(ctx, "Reverting snapshot")
-		err := revertSnapshot(r.session, snapshotRef)
+		err = revertSnapshot(r.session, snapshotRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to revert snapshot to VM",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 		tflog.Debug(ctx, "Reverting VM power state")
 		err = revertPowerState(r.session, snapshotRecord)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to revert VM power state",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 	}
 
 	err = updateSnapshotResourceModelComputed(ctx, r.session, snapshotRecord, &plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of snapshotResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, plan.UUID)...)
 }
 
 func (r *snapshotResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("delete"))
+		return
+	}
+
 	var data snapshotResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
 	tflog.Debug(ctx, "Deleting snapshot...")
 	snapshotRef, err := xenapi.VM.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get snapshot by UUID",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	powerState, err := xenapi.VM.GetPowerState(r.session, snapshotRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get snapshot power state",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	if powerState == xenapi.VMPowerStateSuspended {
 		err = xenapi.VM.HardShutdown(r.session, snapshotRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to hard shutdown snapshot",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 	}
 
 	err = cleanupSnapshotResource(r.session, snapshotRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to delete snapshot",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -429,5 +509,7 @@ func (r *snapshotResource) Delete(ctx context.Context, req resource.DeleteReques
 }
 
 func (r *snapshotResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("uuid"), req, resp)
+	importStateByName(ctx, req, resp, "snapshot", []string{"name", "vm"}, func(selectors map[string]string) ([]string, error) {
+		return findSnapshotsForImport(r.session, selectors)
+	})
 }
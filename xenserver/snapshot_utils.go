@@ -3,21 +3,22 @@ package xenserver
 import (
 	"context"
 	"errors"
-	"strings"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 
 	"xenapi"
 )
 
 type snapshotResourceModel struct {
-	NameLabel  types.String `tfsdk:"name_label"`
-	VM         types.String `tfsdk:"vm_uuid"`
-	WithMemory types.Bool   `tfsdk:"with_memory"`
-	Revert     types.Bool   `tfsdk:"revert"`
-	RevertVDIs types.Set    `tfsdk:"revert_vdis"`
-	UUID       types.String `tfsdk:"uuid"`
-	ID         types.String `tfsdk:"id"`
+	NameLabel  types.String   `tfsdk:"name_label"`
+	VM         types.String   `tfsdk:"vm_uuid"`
+	WithMemory types.Bool     `tfsdk:"with_memory"`
+	Revert     types.Bool     `tfsdk:"revert"`
+	RevertVDIs types.Set      `tfsdk:"revert_vdis"`
+	UUID       types.String   `tfsdk:"uuid"`
+	ID         types.String   `tfsdk:"id"`
+	Timeouts   timeouts.Value `tfsdk:"timeouts"`
 }
 
 func updateSnapshotResourceModel(ctx context.Context, session *xenapi.Session, record xenapi.VMRecord, data *snapshotResourceModel) error {
@@ -35,17 +36,17 @@ func getAllDiskTypeVDIs(session *xenapi.Session, vmRef xenapi.VMRef) ([]xenapi.V
 	vdiRefs := []xenapi.VDIRef{}
 	vbdRefs, err := xenapi.VM.GetVBDs(session, vmRef)
 	if err != nil {
-		return vdiRefs, errors.New(err.Error())
+		return vdiRefs, newXAPIError(err)
 	}
 	for _, vbdRef := range vbdRefs {
 		vbdType, err := xenapi.VBD.GetType(session, vbdRef)
 		if err != nil {
-			return vdiRefs, errors.New(err.Error())
+			return vdiRefs, newXAPIError(err)
 		}
 		if vbdType == xenapi.VbdTypeDisk {
 			vdiRef, err := xenapi.VBD.GetVDI(session, vbdRef)
 			if err != nil {
-				return vdiRefs, errors.New(err.Error())
+				return vdiRefs, newXAPIError(err)
 			}
 			if string(vdiRef) != "OpaqueRef:NULL" {
 				vdiRefs = append(vdiRefs, vdiRef)
@@ -73,7 +74,7 @@ func updateSnapshotResourceModelComputed(ctx context.Context, session *xenapi.Se
 		for _, vdiRef := range vdiRefs {
 			vdiRecord, err := xenapi.VDI.GetRecord(session, vdiRef)
 			if err != nil {
-				return errors.New(err.Error())
+				return newXAPIError(err)
 			}
 			srUUID, err := getUUIDFromSRRef(session, vdiRecord.SR)
 			if err != nil {
@@ -120,7 +121,7 @@ func snapshotResourceModelUpdateCheck(plan snapshotResourceModel, state snapshot
 func snapshotResourceModelUpdate(session *xenapi.Session, ref xenapi.VMRef, data snapshotResourceModel) error {
 	err := xenapi.VM.SetNameLabel(session, ref, data.NameLabel.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
@@ -133,13 +134,13 @@ func cleanupSnapshotResource(session *xenapi.Session, ref xenapi.VMRef) error {
 	}
 	for _, vdiRef := range vdiRefs {
 		err := xenapi.VDI.Destroy(session, vdiRef)
-		if err != nil && !strings.Contains(err.Error(), "HANDLE_INVALID") {
-			return errors.New(err.Error())
+		if err != nil && !isXAPIError(err, "HANDLE_INVALID") {
+			return newXAPIError(err)
 		}
 	}
 	err = xenapi.VM.Destroy(session, ref)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	return nil
 }
@@ -147,7 +148,7 @@ func cleanupSnapshotResource(session *xenapi.Session, ref xenapi.VMRef) error {
 func revertSnapshot(session *xenapi.Session, ref xenapi.VMRef) error {
 	err := xenapi.VM.Revert(session, ref)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
@@ -171,11 +172,11 @@ func revertPowerState(session *xenapi.Session, record xenapi.VMRecord) error {
 	}
 	vmRecord, err := xenapi.VM.GetRecord(session, record.SnapshotOf)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	vmRef, err := xenapi.VM.GetByUUID(session, vmRecord.UUID)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	vmCanBootOnHost := vmCanBootOnHost(session, vmRef, vmRecord.ResidentOn)
 
@@ -185,30 +186,30 @@ func revertPowerState(session *xenapi.Session, record xenapi.VMRecord) error {
 			if vmCanBootOnHost {
 				err := xenapi.VM.StartOn(session, vmRef, vmRecord.ResidentOn, false, false)
 				if err != nil {
-					return errors.New(err.Error())
+					return newXAPIError(err)
 				}
 			} else {
 				err := xenapi.VM.Start(session, vmRef, false, false)
 				if err != nil {
-					return errors.New(err.Error())
+					return newXAPIError(err)
 				}
 			}
 		case xenapi.VMPowerStateSuspended:
 			if vmCanBootOnHost {
 				err := xenapi.VM.ResumeOn(session, vmRef, vmRecord.ResidentOn, false, false)
 				if err != nil {
-					return errors.New(err.Error())
+					return newXAPIError(err)
 				}
 			} else {
 				err := xenapi.VM.Resume(session, vmRef, false, false)
 				if err != nil {
-					return errors.New(err.Error())
+					return newXAPIError(err)
 				}
 			}
 		case xenapi.VMPowerStatePaused:
 			err := xenapi.VM.Unpause(session, vmRef)
 			if err != nil {
-				return errors.New(err.Error())
+				return newXAPIError(err)
 			}
 		case xenapi.VMPowerStateRunning:
 			// No action needed
@@ -218,3 +219,29 @@ func revertPowerState(session *xenapi.Session, record xenapi.VMRecord) error {
 	}
 	return nil
 }
+
+// findSnapshotsForImport returns the UUIDs of the snapshots with the name of
+// the selectors, of the VM with the UUID of the vm selector if set.
+func findSnapshotsForImport(session *xenapi.Session, selectors map[string]string) ([]string, error) {
+	var vmRef xenapi.VMRef
+	if vmUUID, ok := selectors["vm"]; ok {
+		var err error
+		vmRef, err = xenapi.VM.GetByUUID(session, vmUUID)
+		if err != nil {
+			return nil, newXAPIError(err)
+		}
+	}
+	vmRecords, err := xenapi.VM.GetAllRecords(session)
+	if err != nil {
+		return nil, newXAPIError(err)
+	}
+	var uuids []string
+	for _, vmRecord := range vmRecords {
+		if !vmRecord.IsASnapshot || vmRecord.NameLabel != selectors["name"] || (vmRef != "" && vmRecord.SnapshotOf != vmRef) {
+			continue
+		}
+		uuids = append(uuids, vmRecord.UUID)
+	}
+
+	return uuids, nil
+}
@@ -176,10 +176,10 @@ func (d *srDataSource) Read(ctx context.Context, req datasource.ReadRequest, res
 
 	srRecords, err := xenapi.SR.GetAllRecords(d.session)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR records",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -196,10 +196,10 @@ func (d *srDataSource) Read(ctx context.Context, req datasource.ReadRequest, res
 		var srData srRecordData
 		err = updateSRRecordData(ctx, d.session, srRecord, &srData)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to update SR record data",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 		srItems = append(srItems, srData)
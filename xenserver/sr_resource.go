@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/hashicorp/terraform-plugin-framework/attr"
-	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
@@ -24,6 +23,8 @@ var (
 	_ resource.Resource                = &srResource{}
 	_ resource.ResourceWithConfigure   = &srResource{}
 	_ resource.ResourceWithImportState = &srResource{}
+	_ resource.ResourceWithIdentity    = &srResource{}
+	_ resource.ResourceWithModifyPlan  = &srResource{}
 )
 
 func NewSRResource() resource.Resource {
@@ -39,7 +40,7 @@ func (r *srResource) Metadata(_ context.Context, req resource.MetadataRequest, r
 	resp.TypeName = req.ProviderTypeName + "_sr"
 }
 
-func (r *srResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *srResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "Provides a general storage repository resource.",
 		Attributes: map[string]schema.Attribute{
@@ -110,9 +111,16 @@ func (r *srResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *r
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
+func (r *srResource) IdentitySchema(_ context.Context, _ resource.IdentitySchemaRequest, resp *resource.IdentitySchemaResponse) {
+	resp.IdentitySchema = uuidIdentitySchema("storage repository")
+}
+
 // Set the parameter of the resource, pass value from provider
 func (r *srResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
 	// Prevent panic if the provider has not been configured.
@@ -130,63 +138,102 @@ func (r *srResource) Configure(_ context.Context, req resource.ConfigureRequest,
 	r.session = providerData.session
 }
 
+// ModifyPlan checks the plan against the pool, so that the invalid references
+// are reported by terraform plan.
+func (r *srResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// nothing to check when the resource is destroyed or the provider isn't
+	// configured yet
+	if req.Plan.Raw.IsNull() || r.session == nil {
+		return
+	}
+	var plan srResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	var state *srResourceModel
+	if !req.State.Raw.IsNull() {
+		state = &srResourceModel{}
+		resp.Diagnostics.Append(req.State.Get(ctx, state)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(srResourceModelPlanCheck(r.session, plan, state)...)
+}
+
 func (r *srResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("create"))
+		return
+	}
+
 	var data srResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	createTimeout, diags := data.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	tflog.Debug(ctx, "Creating SR ...")
 	params, err := getSRCreateParams(ctx, r.session, data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR create params",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
-	srRef, err := createSRResource(r.session, params)
+	srRef, err := createSRResource(ctx, r.session, params)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to create SR",
-			err.Error(),
-		)
+			err,
+			"type",
+			"device_config",
+			"host",
+		))
 		return
 	}
 	srRecord, pbdRecord, err := getSRRecordAndPBDRecord(r.session, srRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR or PBDrecord",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupSRResource(r.session, srRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up SR resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 	err = updateSRResourceModelComputed(ctx, r.session, srRecord, pbdRecord, &data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of SRResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupSRResource(r.session, srRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up SR resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 	tflog.Debug(ctx, "SR created")
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 // Read data from State, retrieve the resource's information, update to State
@@ -198,42 +245,64 @@ func (r *srResource) Read(ctx context.Context, req resource.ReadRequest, resp *r
 		return
 	}
 
+	readTimeout, diags := data.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	// Overwrite data with refreshed resource state
 	srRef, err := xenapi.SR.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	srRecord, pbdRecord, err := getSRRecordAndPBDRecord(r.session, srRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR or PBDrecord",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = updateSRResourceModel(ctx, r.session, srRecord, pbdRecord, &data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the fields of SRResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 func (r *srResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("update"))
+		return
+	}
+
 	var plan, state srResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	updateTimeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	// Checking if configuration changes are allowed
 	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
 	if resp.Diagnostics.HasError() {
@@ -241,75 +310,109 @@ func (r *srResource) Update(ctx context.Context, req resource.UpdateRequest, res
 	}
 	err := srResourceModelUpdateCheck(plan, state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Error update xenserver_sr configuration",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	// Update the resource with new configuration
 	srRef, err := xenapi.SR.GetByUUID(r.session, plan.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
+	unlock, err := lockObject(ctx, string(srRef))
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock SR",
+			err,
+		))
+		return
+	}
+	defer unlock()
 	err = srResourceModelUpdate(ctx, r.session, srRef, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update SR resource",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	srRecord, pbdRecord, err := getSRRecordAndPBDRecord(r.session, srRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR or PBDrecord",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = updateSRResourceModelComputed(ctx, r.session, srRecord, pbdRecord, &plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of SRResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, plan.UUID)...)
 }
 
 func (r *srResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("delete"))
+		return
+	}
+
 	var data srResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
 	srRef, err := xenapi.SR.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
+	unlock, err := lockObject(ctx, string(srRef))
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock SR",
+			err,
+		))
+		return
+	}
+	defer unlock()
 	err = cleanupSRResource(r.session, srRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to delete NFS SR",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 }
 
 func (r *srResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("uuid"), req, resp)
+	importStateByName(ctx, req, resp, "SR", []string{"name"}, func(selectors map[string]string) ([]string, error) {
+		return findSRsForImport(r.session, "", "", selectors)
+	})
 }
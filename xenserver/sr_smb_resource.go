@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
-	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
@@ -22,6 +21,7 @@ var (
 	_ resource.Resource                = &smbResource{}
 	_ resource.ResourceWithConfigure   = &smbResource{}
 	_ resource.ResourceWithImportState = &smbResource{}
+	_ resource.ResourceWithIdentity    = &smbResource{}
 )
 
 func NewSMBResource() resource.Resource {
@@ -37,7 +37,7 @@ func (r *smbResource) Metadata(_ context.Context, req resource.MetadataRequest,
 	resp.TypeName = req.ProviderTypeName + "_sr_smb"
 }
 
-func (r *smbResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *smbResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "Provides an SMB storage repository resource.",
 		Attributes: map[string]schema.Attribute{
@@ -93,9 +93,16 @@ func (r *smbResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
+func (r *smbResource) IdentitySchema(_ context.Context, _ resource.IdentitySchemaRequest, resp *resource.IdentitySchemaResponse) {
+	resp.IdentitySchema = uuidIdentitySchema("storage repository")
+}
+
 // Set the parameter of the resource, pass value from provider
 func (r *smbResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
 	// Prevent panic if the provider has not been configured.
@@ -114,62 +121,77 @@ func (r *smbResource) Configure(_ context.Context, req resource.ConfigureRequest
 }
 
 func (r *smbResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("create"))
+		return
+	}
+
 	var data smbResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	createTimeout, diags := data.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	tflog.Debug(ctx, "Creating SMB SR...")
 	params, err := getSMBCreateParams(r.session, data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR create params",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
-	srRef, err := createSRResource(r.session, params)
+	srRef, err := createSRResource(ctx, r.session, params)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to create SR",
-			err.Error(),
-		)
+			err,
+			"storage_location",
+		))
 		return
 	}
 	srRecord, _, err := getSRRecordAndPBDRecord(r.session, srRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR or PBD record",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupSRResource(r.session, srRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up SR resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 	err = updateSMBResourceModelComputed(srRecord, &data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of SMBResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupSRResource(r.session, srRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up SR resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 	tflog.Debug(ctx, "SMB SR created")
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 // Read data from State, retrieve the resource's information, update to State
@@ -181,42 +203,64 @@ func (r *smbResource) Read(ctx context.Context, req resource.ReadRequest, resp *
 		return
 	}
 
+	readTimeout, diags := data.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	// Overwrite data with refreshed resource state
 	srRef, err := xenapi.SR.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	srRecord, pbdRecord, err := getSRRecordAndPBDRecord(r.session, srRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR or PBDrecord",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = updateSMBResourceModel(srRecord, pbdRecord, &data)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the fields of SMBResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 func (r *smbResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("update"))
+		return
+	}
+
 	var plan, state smbResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	updateTimeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	// Checking if configuration changes are allowed
 	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
 	if resp.Diagnostics.HasError() {
@@ -224,75 +268,109 @@ func (r *smbResource) Update(ctx context.Context, req resource.UpdateRequest, re
 	}
 	err := smbResourceModelUpdateCheck(plan, state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Error update xenserver_sr_smb configuration",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	// Update the resource with new configuration
 	srRef, err := xenapi.SR.GetByUUID(r.session, plan.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
+	unlock, err := lockObject(ctx, string(srRef))
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock SR",
+			err,
+		))
+		return
+	}
+	defer unlock()
 	err = smbResourceModelUpdate(r.session, srRef, plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update SMB SR resource",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	srRecord, _, err := getSRRecordAndPBDRecord(r.session, srRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR or PBDrecord",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = updateSMBResourceModelComputed(srRecord, &plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of SMBResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, plan.UUID)...)
 }
 
 func (r *smbResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("delete"))
+		return
+	}
+
 	var data smbResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
 	srRef, err := xenapi.SR.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get SR ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
+	unlock, err := lockObject(ctx, string(srRef))
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock SR",
+			err,
+		))
+		return
+	}
+	defer unlock()
 	err = cleanupSRResource(r.session, srRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to delete SMB SR",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 }
 
 func (r *smbResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("uuid"), req, resp)
+	importStateByName(ctx, req, resp, "SR", []string{"name"}, func(selectors map[string]string) ([]string, error) {
+		return findSRsForImport(r.session, "smb", "cifs", selectors)
+	})
 }
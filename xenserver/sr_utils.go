@@ -3,10 +3,14 @@ package xenserver
 import (
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 
 	"xenapi"
@@ -123,16 +127,17 @@ type srCreateParams struct {
 
 // srResourceModel describes the resource data model.
 type srResourceModel struct {
-	NameLabel       types.String `tfsdk:"name_label"`
-	NameDescription types.String `tfsdk:"name_description"`
-	Type            types.String `tfsdk:"type"`
-	ContentType     types.String `tfsdk:"content_type"`
-	Shared          types.Bool   `tfsdk:"shared"`
-	SmConfig        types.Map    `tfsdk:"sm_config"`
-	DeviceConfig    types.Map    `tfsdk:"device_config"`
-	Host            types.String `tfsdk:"host"`
-	UUID            types.String `tfsdk:"uuid"`
-	ID              types.String `tfsdk:"id"`
+	NameLabel       types.String   `tfsdk:"name_label"`
+	NameDescription types.String   `tfsdk:"name_description"`
+	Type            types.String   `tfsdk:"type"`
+	ContentType     types.String   `tfsdk:"content_type"`
+	Shared          types.Bool     `tfsdk:"shared"`
+	SmConfig        types.Map      `tfsdk:"sm_config"`
+	DeviceConfig    types.Map      `tfsdk:"device_config"`
+	Host            types.String   `tfsdk:"host"`
+	UUID            types.String   `tfsdk:"uuid"`
+	ID              types.String   `tfsdk:"id"`
+	Timeouts        timeouts.Value `tfsdk:"timeouts"`
 }
 
 func getSRCreateParams(ctx context.Context, session *xenapi.Session, data srResourceModel) (srCreateParams, error) {
@@ -158,7 +163,7 @@ func getSRCreateParams(ctx context.Context, session *xenapi.Session, data srReso
 	if !data.Host.IsUnknown() {
 		hostRef, err := xenapi.Host.GetByUUID(session, data.Host.ValueString())
 		if err != nil {
-			return params, errors.New(err.Error())
+			return params, newXAPIError(err)
 		}
 		if params.Shared && hostRef != params.Host {
 			return params, errors.New("shared SR can only created with coordinator host")
@@ -172,11 +177,11 @@ func getSRCreateParams(ctx context.Context, session *xenapi.Session, data srReso
 func getSRRecordAndPBDRecord(session *xenapi.Session, srRef xenapi.SRRef) (xenapi.SRRecord, xenapi.PBDRecord, error) {
 	srRecord, err := xenapi.SR.GetRecord(session, srRef)
 	if err != nil {
-		return xenapi.SRRecord{}, xenapi.PBDRecord{}, errors.New(err.Error())
+		return xenapi.SRRecord{}, xenapi.PBDRecord{}, newXAPIError(err)
 	}
 	pbdRecord, err := xenapi.PBD.GetRecord(session, srRecord.PBDs[0])
 	if err != nil {
-		return xenapi.SRRecord{}, xenapi.PBDRecord{}, errors.New(err.Error())
+		return xenapi.SRRecord{}, xenapi.PBDRecord{}, newXAPIError(err)
 	}
 	return srRecord, pbdRecord, nil
 }
@@ -241,11 +246,11 @@ func srResourceModelUpdateCheck(data srResourceModel, dataState srResourceModel)
 func srResourceModelUpdate(ctx context.Context, session *xenapi.Session, ref xenapi.SRRef, data srResourceModel) error {
 	err := xenapi.SR.SetNameLabel(session, ref, data.NameLabel.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	err = xenapi.SR.SetNameDescription(session, ref, data.NameDescription.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	smConfig := make(map[string]string)
 	diags := data.SmConfig.ElementsAs(ctx, &smConfig, false)
@@ -254,7 +259,7 @@ func srResourceModelUpdate(ctx context.Context, session *xenapi.Session, ref xen
 	}
 	err = xenapi.SR.SetSmConfig(session, ref, smConfig)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	return nil
 }
@@ -275,7 +280,7 @@ func unplugPBDs(session *xenapi.Session, pbdRefs []xenapi.PBDRef) error {
 	for _, pbdRef := range pbdRefs {
 		pbdRecord, err := xenapi.PBD.GetRecord(session, pbdRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 		if pbdRecord.CurrentlyAttached {
 			if string(pbdRecord.Host) != "OpaqueRef:NULL" && pbdRecord.Host == coordinatorRef {
@@ -292,7 +297,7 @@ func unplugPBDs(session *xenapi.Session, pbdRefs []xenapi.PBDRef) error {
 	for _, pbdRef := range allPBDRefs {
 		err = xenapi.PBD.Unplug(session, pbdRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
@@ -302,7 +307,7 @@ func unplugPBDs(session *xenapi.Session, pbdRefs []xenapi.PBDRef) error {
 func cleanupSRResource(session *xenapi.Session, ref xenapi.SRRef) error {
 	pbdRefs, err := xenapi.SR.GetPBDs(session, ref)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	err = unplugPBDs(session, pbdRefs)
 	if err != nil {
@@ -310,12 +315,12 @@ func cleanupSRResource(session *xenapi.Session, ref xenapi.SRRef) error {
 	}
 	err = xenapi.SR.Forget(session, ref)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	return nil
 }
 
-func createSRResource(session *xenapi.Session, params srCreateParams) (xenapi.SRRef, error) {
+func createSRResource(ctx context.Context, session *xenapi.Session, params srCreateParams) (xenapi.SRRef, error) {
 	var srRef xenapi.SRRef
 	// Create secret for password
 	var secretRef xenapi.SecretRef
@@ -328,11 +333,11 @@ func createSRResource(session *xenapi.Session, params srCreateParams) (xenapi.SR
 				secretRecord := xenapi.SecretRecord{Value: value}
 				secretRef, err := xenapi.Secret.Create(session, secretRecord)
 				if err != nil {
-					return srRef, errors.New(err.Error())
+					return srRef, newXAPIError(err)
 				}
 				secretUUID, err := xenapi.Secret.GetUUID(session, secretRef)
 				if err != nil {
-					return srRef, errors.New(err.Error())
+					return srRef, newXAPIError(err)
 				}
 				params.DeviceConfig[key+"_secret"] = secretUUID
 				break
@@ -340,34 +345,39 @@ func createSRResource(session *xenapi.Session, params srCreateParams) (xenapi.SR
 		}
 	}
 	// Create SR
-	srRef, err := xenapi.SR.Create(session, params.Host, params.DeviceConfig, params.PhysicalSize, params.NameLabel, params.NameDescription, params.TypeKey, params.ContentType, params.Shared, params.SmConfig)
+	taskRef, err := xenapi.SR.AsyncCreate(session, params.Host, params.DeviceConfig, params.PhysicalSize, params.NameLabel, params.NameDescription, params.TypeKey, params.ContentType, params.Shared, params.SmConfig)
+	if err == nil {
+		var result string
+		result, err = waitForTask(ctx, session, taskRef)
+		srRef = xenapi.SRRef(result)
+	}
 	if err != nil {
 		errDestroy := xenapi.Secret.Destroy(session, secretRef)
 		if errDestroy != nil {
-			return srRef, errors.New(err.Error() + "\n" + errDestroy.Error())
+			return srRef, fmt.Errorf("%w\n%w", err, errDestroy)
 		}
-		return srRef, errors.New(err.Error())
+		return srRef, newXAPIError(err)
 	}
 	// Checking that SR.Create actually succeeded
 	pbdRefs, err := xenapi.SR.GetPBDs(session, srRef)
 	if err != nil {
-		return srRef, errors.New(err.Error())
+		return srRef, newXAPIError(err)
 	}
 	for _, pbdRef := range pbdRefs {
 		currentlyAttached, err := xenapi.PBD.GetCurrentlyAttached(session, pbdRef)
 		if err != nil {
-			return srRef, errors.New(err.Error())
+			return srRef, newXAPIError(err)
 		}
 		if !currentlyAttached {
 			err = xenapi.PBD.Plug(session, pbdRef)
 			if err != nil {
-				return srRef, errors.New(err.Error())
+				return srRef, newXAPIError(err)
 			}
 		}
 	}
 	otherConfig, err := xenapi.SR.GetOtherConfig(session, srRef)
 	if err != nil {
-		return srRef, errors.New(err.Error())
+		return srRef, newXAPIError(err)
 	}
 	otherConfig["auto-scan"] = "false"
 	if params.ContentType == "iso" {
@@ -375,20 +385,21 @@ func createSRResource(session *xenapi.Session, params srCreateParams) (xenapi.SR
 	}
 	err = xenapi.SR.SetOtherConfig(session, srRef, otherConfig)
 	if err != nil {
-		return srRef, errors.New(err.Error())
+		return srRef, newXAPIError(err)
 	}
 	return srRef, nil
 }
 
 type nfsResourceModel struct {
-	NameLabel       types.String `tfsdk:"name_label"`
-	NameDescription types.String `tfsdk:"name_description"`
-	Type            types.String `tfsdk:"type"`
-	StorageLocation types.String `tfsdk:"storage_location"`
-	Version         types.String `tfsdk:"version"`
-	AdvancedOptions types.String `tfsdk:"advanced_options"`
-	UUID            types.String `tfsdk:"uuid"`
-	ID              types.String `tfsdk:"id"`
+	NameLabel       types.String   `tfsdk:"name_label"`
+	NameDescription types.String   `tfsdk:"name_description"`
+	Type            types.String   `tfsdk:"type"`
+	StorageLocation types.String   `tfsdk:"storage_location"`
+	Version         types.String   `tfsdk:"version"`
+	AdvancedOptions types.String   `tfsdk:"advanced_options"`
+	UUID            types.String   `tfsdk:"uuid"`
+	ID              types.String   `tfsdk:"id"`
+	Timeouts        timeouts.Value `tfsdk:"timeouts"`
 }
 
 func getNFSCreateParams(session *xenapi.Session, data nfsResourceModel) (srCreateParams, error) {
@@ -482,25 +493,26 @@ func nfsResourceModelUpdateCheck(data nfsResourceModel, dataState nfsResourceMod
 func nfsResourceModelUpdate(session *xenapi.Session, ref xenapi.SRRef, data nfsResourceModel) error {
 	err := xenapi.SR.SetNameLabel(session, ref, data.NameLabel.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	err = xenapi.SR.SetNameDescription(session, ref, data.NameDescription.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
 }
 
 type smbResourceModel struct {
-	NameLabel       types.String `tfsdk:"name_label"`
-	NameDescription types.String `tfsdk:"name_description"`
-	Type            types.String `tfsdk:"type"`
-	StorageLocation types.String `tfsdk:"storage_location"`
-	Username        types.String `tfsdk:"username"`
-	Password        types.String `tfsdk:"password"`
-	UUID            types.String `tfsdk:"uuid"`
-	ID              types.String `tfsdk:"id"`
+	NameLabel       types.String   `tfsdk:"name_label"`
+	NameDescription types.String   `tfsdk:"name_description"`
+	Type            types.String   `tfsdk:"type"`
+	StorageLocation types.String   `tfsdk:"storage_location"`
+	Username        types.String   `tfsdk:"username"`
+	Password        types.String   `tfsdk:"password"`
+	UUID            types.String   `tfsdk:"uuid"`
+	ID              types.String   `tfsdk:"id"`
+	Timeouts        timeouts.Value `tfsdk:"timeouts"`
 }
 
 func getSMBCreateParams(session *xenapi.Session, data smbResourceModel) (srCreateParams, error) {
@@ -602,12 +614,89 @@ func smbResourceModelUpdateCheck(data smbResourceModel, dataState smbResourceMod
 func smbResourceModelUpdate(session *xenapi.Session, ref xenapi.SRRef, data smbResourceModel) error {
 	err := xenapi.SR.SetNameLabel(session, ref, data.NameLabel.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	err = xenapi.SR.SetNameDescription(session, ref, data.NameDescription.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
 }
+
+// srResourceModelPlanCheck checks that the SR type is supported by the pool
+// and that the host of the plan exists. The state is nil when the SR is going
+// to be created.
+func srResourceModelPlanCheck(session *xenapi.Session, plan srResourceModel, state *srResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if state != nil {
+		return diags
+	}
+
+	if !plan.Type.IsUnknown() {
+		smRecords, err := xenapi.SM.GetAllRecords(session)
+		if err != nil {
+			diags.AddAttributeError(path.Root("type"), "Unable to get SM records", err.Error())
+		} else {
+			smTypes := []string{}
+			for _, smRecord := range smRecords {
+				smTypes = append(smTypes, smRecord.Type)
+			}
+			slices.Sort(smTypes)
+			if !slices.Contains(smTypes, plan.Type.ValueString()) {
+				diags.AddAttributeError(path.Root("type"), "Invalid SR type",
+					"the SR type "+plan.Type.ValueString()+" isn't supported by the pool, supported types: "+strings.Join(smTypes, ", "))
+			}
+		}
+	}
+
+	if !plan.Host.IsUnknown() && !plan.Host.IsNull() {
+		hostRef, err := xenapi.Host.GetByUUID(session, plan.Host.ValueString())
+		if err != nil {
+			diags.AddAttributeError(path.Root("host"), "Invalid host", "unable to find the host "+plan.Host.ValueString()+". "+err.Error())
+			return diags
+		}
+		coordinatorRef, _, err := getCoordinatorRef(session)
+		if err != nil {
+			diags.AddAttributeError(path.Root("host"), "Unable to get the coordinator host", err.Error())
+			return diags
+		}
+		if !plan.Shared.IsUnknown() && plan.Shared.ValueBool() && hostRef != coordinatorRef {
+			diags.AddAttributeError(path.Root("host"), "Invalid host", "shared SR can only created with coordinator host")
+		}
+	}
+
+	return diags
+}
+
+// findSRsForImport returns the UUIDs of the SRs of the type with the name of
+// the selectors. The ISO library SRs are found when the type of their storage,
+// in the device config of their PBDs, is isoType, e.g. "nfs_iso" for the NFS
+// SRs. The SRs of all the types are found when srType is empty.
+func findSRsForImport(session *xenapi.Session, srType string, isoType string, selectors map[string]string) ([]string, error) {
+	srRecords, err := xenapi.SR.GetAllRecords(session)
+	if err != nil {
+		return nil, newXAPIError(err)
+	}
+	var uuids []string
+	for _, srRecord := range srRecords {
+		if srRecord.NameLabel != selectors["name"] {
+			continue
+		}
+		if srType != "" && srRecord.Type != srType {
+			if srRecord.Type != "iso" || len(srRecord.PBDs) == 0 {
+				continue
+			}
+			pbdRecord, err := xenapi.PBD.GetRecord(session, srRecord.PBDs[0])
+			if err != nil {
+				return nil, newXAPIError(err)
+			}
+			if pbdRecord.DeviceConfig["type"] != isoType {
+				continue
+			}
+		}
+		uuids = append(uuids, srRecord.UUID)
+	}
+
+	return uuids, nil
+}
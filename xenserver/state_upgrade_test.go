@@ -0,0 +1,264 @@
+package xenserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+// The prior states below are the resource attributes as written in
+// terraform.tfstate by the prior releases of the provider. The resource schemas
+// are still at the version 0: the attributes and blocks added since are null
+// and the removed ones are dropped, so no state upgrader is needed.
+const (
+	vmStateV0 = `{
+  "boot_mode": "uefi",
+  "boot_order": "ncd",
+  "cdrom": "",
+  "check_ip_timeout": 0,
+  "cores_per_socket": 2,
+  "default_ip": "",
+  "dynamic_mem_max": 4294967296,
+  "dynamic_mem_min": 4294967296,
+  "hard_drive": [
+    {
+      "bootable": true,
+      "mode": "RW",
+      "vbd_ref": "OpaqueRef:6b4b3a2c-0d6c-4a4e-8d4f-2b7f3a5e1c90",
+      "vdi_uuid": "9e6b6a0e-6f1d-4c1a-8a56-3d1e2f4b7c01"
+    }
+  ],
+  "id": "4b1e4a2c-5f6d-7e8f-9a0b-1c2d3e4f5a6b",
+  "name_description": "",
+  "name_label": "test vm 1",
+  "network_interface": [
+    {
+      "device": "0",
+      "mac": "11:22:33:44:55:66",
+      "network_uuid": "2c6f0b8e-1d3a-4e5f-8a9b-0c1d2e3f4a5b",
+      "other_config": {
+        "ethtool-gso": "off"
+      },
+      "vif_ref": "OpaqueRef:1f2e3d4c-5b6a-7980-a1b2-c3d4e5f6a7b8"
+    }
+  ],
+  "other_config": {
+    "flag": "1"
+  },
+  "sr_for_full_disk_copy": "",
+  "static_mem_max": 4294967296,
+  "static_mem_min": 4294967296,
+  "template_name": "Windows 11",
+  "uuid": "4b1e4a2c-5f6d-7e8f-9a0b-1c2d3e4f5a6b",
+  "vcpus": 4
+}`
+	vdiStateV0 = `{
+  "id": "9e6b6a0e-6f1d-4c1a-8a56-3d1e2f4b7c01",
+  "name_description": "",
+  "name_label": "local-storage-vdi",
+  "other_config": {},
+  "read_only": false,
+  "sharable": false,
+  "sr_uuid": "5d4c3b2a-1f0e-4d9c-8b7a-6f5e4d3c2b1a",
+  "type": "user",
+  "uuid": "9e6b6a0e-6f1d-4c1a-8a56-3d1e2f4b7c01",
+  "virtual_size": 107374182400
+}`
+	snapshotStateV0 = `{
+  "id": "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d",
+  "name_label": "snapshot 1",
+  "revert": null,
+  "revert_vdis": [
+    {
+      "id": "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+      "name_description": "",
+      "name_label": "local-storage-vdi",
+      "other_config": {},
+      "read_only": false,
+      "sharable": false,
+      "sr_uuid": "5d4c3b2a-1f0e-4d9c-8b7a-6f5e4d3c2b1a",
+      "type": "user",
+      "uuid": "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+      "virtual_size": 107374182400
+    }
+  ],
+  "uuid": "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d",
+  "vm_uuid": "4b1e4a2c-5f6d-7e8f-9a0b-1c2d3e4f5a6b",
+  "with_memory": false
+}`
+	poolStateV0 = `{
+  "default_sr": "5d4c3b2a-1f0e-4d9c-8b7a-6f5e4d3c2b1a",
+  "eject_supporters": null,
+  "id": "3c2b1a0f-9e8d-4c7b-a6f5-e4d3c2b1a0f9",
+  "join_supporters": [
+    {
+      "host": "192.0.2.2",
+      "password": "password",
+      "username": "root"
+    }
+  ],
+  "management_network": "2c6f0b8e-1d3a-4e5f-8a9b-0c1d2e3f4a5b",
+  "name_description": "",
+  "name_label": "pool",
+  "uuid": "3c2b1a0f-9e8d-4c7b-a6f5-e4d3c2b1a0f9"
+}`
+	srStateV0 = `{
+  "content_type": "",
+  "device_config": {
+    "device": "/dev/sdb"
+  },
+  "host": "OpaqueRef:8d7c6b5a-4f3e-4d2c-9b1a-0f9e8d7c6b5a",
+  "id": "6e5d4c3b-2a1f-4e0d-9c8b-7a6f5e4d3c2b",
+  "name_description": "",
+  "name_label": "local storage",
+  "shared": false,
+  "sm_config": {},
+  "type": "ext",
+  "uuid": "6e5d4c3b-2a1f-4e0d-9c8b-7a6f5e4d3c2b"
+}`
+	nfsStateV0 = `{
+  "advanced_options": "",
+  "id": "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d",
+  "name_description": "",
+  "name_label": "nfs storage",
+  "storage_location": "192.0.2.10:/nfs/share",
+  "type": "nfs",
+  "uuid": "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d",
+  "version": "3"
+}`
+	smbStateV0 = `{
+  "id": "2b3c4d5e-6f7a-4b8c-9d0e-1f2a3b4c5d6e",
+  "name_description": "",
+  "name_label": "smb storage",
+  "password": "password",
+  "storage_location": "\\\\192.0.2.11\\share",
+  "type": "smb",
+  "username": "user",
+  "uuid": "2b3c4d5e-6f7a-4b8c-9d0e-1f2a3b4c5d6e"
+}`
+)
+
+// upgradeTestState upgrades a prior state of the resource as Terraform does
+// when the state was written by a prior release of the provider.
+func upgradeTestState(t *testing.T, r resource.Resource, version int64, state string) tfsdk.State {
+	t.Helper()
+	ctx := context.Background()
+	var metadataResp resource.MetadataResponse
+	r.Metadata(ctx, resource.MetadataRequest{ProviderTypeName: "xenserver"}, &metadataResp)
+	var schemaResp resource.SchemaResponse
+	r.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+
+	server, err := providerserver.NewProtocol6WithError(New("test")())()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.UpgradeResourceState(ctx, &tfprotov6.UpgradeResourceStateRequest{
+		TypeName: metadataResp.TypeName,
+		Version:  version,
+		RawState: &tfprotov6.RawState{JSON: []byte(state)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, diagnostic := range resp.Diagnostics {
+		if diagnostic.Severity == tfprotov6.DiagnosticSeverityError {
+			t.Fatalf("unable to upgrade the state: %s: %s", diagnostic.Summary, diagnostic.Detail)
+		}
+	}
+	value, err := resp.UpgradedState.Unmarshal(schemaResp.Schema.Type().TerraformType(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tfsdk.State{Schema: schemaResp.Schema, Raw: value}
+}
+
+func TestUpgradeVMResourceStateV0(t *testing.T) {
+	state := upgradeTestState(t, &vmResource{}, 0, vmStateV0)
+	var data vmResourceModel
+	diags := state.Get(context.Background(), &data)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if data.NameLabel.ValueString() != "test vm 1" || data.StaticMemMax.ValueInt64() != 4294967296 || !data.Timeouts.IsNull() {
+		t.Fatalf("unexpected VM state: %+v", data)
+	}
+	var hardDrives []vbdResourceModel
+	diags = data.HardDrive.ElementsAs(context.Background(), &hardDrives, false)
+	if diags.HasError() || len(hardDrives) != 1 || hardDrives[0].VDI.ValueString() != "9e6b6a0e-6f1d-4c1a-8a56-3d1e2f4b7c01" {
+		t.Fatalf("unexpected VM hard drives: %v", data.HardDrive)
+	}
+	if len(data.NetworkInterface.Elements()) != 1 || len(data.OtherConfig.Elements()) != 1 {
+		t.Fatalf("unexpected VM state: %+v", data)
+	}
+}
+
+func TestUpgradeVDIResourceStateV0(t *testing.T) {
+	state := upgradeTestState(t, &vdiResource{}, 0, vdiStateV0)
+	var data vdiResourceTimeoutsModel
+	diags := state.Get(context.Background(), &data)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if data.VirtualSize.ValueInt64() != 107374182400 || data.Type.ValueString() != "user" || !data.Timeouts.IsNull() {
+		t.Fatalf("unexpected VDI state: %+v", data)
+	}
+}
+
+func TestUpgradeSnapshotResourceStateV0(t *testing.T) {
+	state := upgradeTestState(t, &snapshotResource{}, 0, snapshotStateV0)
+	var data snapshotResourceModel
+	diags := state.Get(context.Background(), &data)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if data.VM.ValueString() != "4b1e4a2c-5f6d-7e8f-9a0b-1c2d3e4f5a6b" || !data.Revert.IsNull() || len(data.RevertVDIs.Elements()) != 1 || !data.Timeouts.IsNull() {
+		t.Fatalf("unexpected snapshot state: %+v", data)
+	}
+}
+
+func TestUpgradePoolResourceStateV0(t *testing.T) {
+	state := upgradeTestState(t, &poolResource{}, 0, poolStateV0)
+	var data poolResourceModel
+	diags := state.Get(context.Background(), &data)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if data.NameLabel.ValueString() != "pool" || len(data.JoinSupporters.Elements()) != 1 || !data.EjectSupporters.IsNull() || !data.Timeouts.IsNull() {
+		t.Fatalf("unexpected pool state: %+v", data)
+	}
+}
+
+func TestUpgradeSRResourcesStateV0(t *testing.T) {
+	ctx := context.Background()
+
+	var sr srResourceModel
+	diags := upgradeTestState(t, &srResource{}, 0, srStateV0).Get(ctx, &sr)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if sr.Type.ValueString() != "ext" || sr.DeviceConfig.Elements()["device"].String() != `"/dev/sdb"` || !sr.Timeouts.IsNull() {
+		t.Fatalf("unexpected SR state: %+v", sr)
+	}
+
+	var nfs nfsResourceModel
+	diags = upgradeTestState(t, &nfsResource{}, 0, nfsStateV0).Get(ctx, &nfs)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if nfs.StorageLocation.ValueString() != "192.0.2.10:/nfs/share" || nfs.Version.ValueString() != "3" || !nfs.Timeouts.IsNull() {
+		t.Fatalf("unexpected NFS SR state: %+v", nfs)
+	}
+
+	var smb smbResourceModel
+	diags = upgradeTestState(t, &smbResource{}, 0, smbStateV0).Get(ctx, &smb)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if smb.StorageLocation.ValueString() != `\\192.0.2.11\share` || smb.Username.ValueString() != "user" || !smb.Timeouts.IsNull() {
+		t.Fatalf("unexpected SMB SR state: %+v", smb)
+	}
+}
@@ -0,0 +1,117 @@
+package xenserver
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+
+	"xenapi"
+)
+
+// taskCancelTimeout bounds the wait for XAPI to roll back a cancelled task.
+const taskCancelTimeout = time.Minute
+
+// waitForTask waits for an asynchronous XAPI task to complete, logging its
+// progress, and returns its result, e.g. the reference of the created object.
+// The task is cancelled when the context is done, e.g. when the operation
+// times out or Terraform is interrupted, so that XAPI rolls it back.
+func waitForTask(ctx context.Context, session *xenapi.Session, taskRef xenapi.TaskRef) (string, error) {
+	defer func() {
+		_ = xenapi.Task.Destroy(session, taskRef)
+	}()
+
+	var record xenapi.TaskRecord
+	lastProgress := -1
+	err := waitForEvents(ctx, session, func() (bool, []string, error) {
+		var err error
+		record, err = xenapi.Task.GetRecord(session, taskRef)
+		if err != nil {
+			return false, nil, fmt.Errorf("unable to get task record. %w", err)
+		}
+		if record.Status != xenapi.TaskStatusTypePending {
+			return true, nil, nil
+		}
+
+		progress := int(record.Progress * 100)
+		if progress != lastProgress {
+			tflog.Debug(ctx, "Task "+record.NameLabel+" progress: "+strconv.Itoa(progress)+"%")
+			lastProgress = progress
+		}
+		return false, []string{"task/" + string(taskRef)}, nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			cancelTask(ctx, session, taskRef)
+			return "", fmt.Errorf("task %s was cancelled. %w", record.NameLabel, err)
+		}
+		return "", err
+	}
+
+	switch record.Status {
+	case xenapi.TaskStatusTypeSuccess:
+		tflog.Debug(ctx, "Task "+record.NameLabel+" succeeded")
+		return parseTaskResult(record.Result), nil
+	case xenapi.TaskStatusTypeFailure:
+		return "", newTaskError(record)
+	case xenapi.TaskStatusTypeCancelling, xenapi.TaskStatusTypeCancelled:
+		return "", errors.New("task " + record.NameLabel + " was cancelled")
+	case xenapi.TaskStatusTypePending, xenapi.TaskStatusTypeUnrecognized:
+	}
+	return "", errors.New("task " + record.NameLabel + " has the unexpected status " + string(record.Status))
+}
+
+// cancelTask asks XAPI to cancel the task and waits for the cancellation to
+// complete, the context being already done.
+func cancelTask(ctx context.Context, session *xenapi.Session, taskRef xenapi.TaskRef) {
+	tflog.Debug(ctx, "Cancelling task "+string(taskRef))
+	err := xenapi.Task.Cancel(session, taskRef)
+	if err != nil {
+		tflog.Debug(ctx, "Unable to cancel task "+string(taskRef)+". "+err.Error())
+		return
+	}
+
+	cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), taskCancelTimeout)
+	defer cancel()
+	err = waitForEvents(cancelCtx, session, func() (bool, []string, error) {
+		status, err := xenapi.Task.GetStatus(session, taskRef)
+		if err != nil {
+			return false, nil, newXAPIError(err)
+		}
+		done := status != xenapi.TaskStatusTypePending && status != xenapi.TaskStatusTypeCancelling
+		return done, []string{"task/" + string(taskRef)}, nil
+	})
+	if err != nil {
+		tflog.Debug(ctx, "Task "+string(taskRef)+" is still being cancelled. "+err.Error())
+	}
+}
+
+// newTaskError returns the XAPI failure of a task, formatted as the errors of
+// the SDK.
+func newTaskError(record xenapi.TaskRecord) error {
+	if len(record.ErrorInfo) == 0 {
+		return errors.New("task " + record.NameLabel + " failed")
+	}
+	return &xapiError{
+		Code:   record.ErrorInfo[0],
+		Params: record.ErrorInfo[1:],
+		err:    errors.New("task " + record.NameLabel + " failed, message " + record.ErrorInfo[0] + ", data [" + strings.Join(record.ErrorInfo[1:], " ") + "]"),
+	}
+}
+
+// parseTaskResult returns the value of a task result, which XAPI encodes as
+// an XML-RPC value, e.g. "<value>OpaqueRef:...</value>".
+func parseTaskResult(result string) string {
+	var value struct {
+		Value string `xml:",chardata"`
+	}
+	if xml.Unmarshal([]byte(result), &value) != nil {
+		return result
+	}
+	return value.Value
+}
@@ -0,0 +1,106 @@
+package xenserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xenapi"
+)
+
+// fakeTaskHost runs one task, which stays pending for a number of polls
+// before it completes with the status of the host.
+type fakeTaskHost struct {
+	mu        sync.Mutex
+	pending   int
+	status    string
+	cancelled bool
+	destroyed bool
+}
+
+func (h *fakeTaskHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request xapiRequest
+	_ = json.NewDecoder(r.Body).Decode(&request)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	record := map[string]any{"name_label": "Async.VM.clone", "progress": 0.5, "status": "pending"}
+	switch {
+	case h.cancelled:
+		record["status"] = "cancelled"
+	case h.pending > 0:
+		h.pending--
+	case h.status == "failure":
+		record["status"] = "failure"
+		record["error_info"] = []string{"OTHER_OPERATION_IN_PROGRESS", "VM", "OpaqueRef:template"}
+	default:
+		record["status"] = "success"
+		record["result"] = "<value>OpaqueRef:vm</value>"
+	}
+
+	response := map[string]any{"jsonrpc": "2.0", "id": request.ID}
+	switch request.Method {
+	case "session.login_with_password":
+		response["result"] = "OpaqueRef:session"
+	case "task.get_record":
+		response["result"] = record
+	case "task.get_status":
+		response["result"] = record["status"]
+	case "task.cancel":
+		h.cancelled = true
+		response["result"] = ""
+	case "event.from":
+		response["result"] = map[string]any{"events": []any{}, "valid_ref_counts": map[string]int{}, "token": "1"}
+	case "task.destroy":
+		h.destroyed = true
+		response["result"] = ""
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func TestWaitForTask(t *testing.T) {
+	testCases := []struct {
+		name      string
+		host      *fakeTaskHost
+		timeout   time.Duration
+		result    string
+		err       string
+		cancelled bool
+	}{
+		{"success", &fakeTaskHost{pending: 2}, time.Minute, "OpaqueRef:vm", "", false},
+		{"failure", &fakeTaskHost{status: "failure"}, time.Minute, "", "OTHER_OPERATION_IN_PROGRESS", false},
+		{"cancelled on timeout", &fakeTaskHost{pending: 1 << 30}, 50 * time.Millisecond, "", "cancelled", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.host)
+			defer server.Close()
+			session, err := loginServer(server.URL, "root", "password", &clientConf{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = logoutSession(t.Context(), session) }()
+
+			ctx, cancel := context.WithTimeout(t.Context(), tc.timeout)
+			defer cancel()
+			result, err := waitForTask(ctx, session, xenapi.TaskRef("OpaqueRef:task"))
+			if tc.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected an error with %s, got: %v", tc.err, err)
+			}
+			if result != tc.result {
+				t.Fatalf("expected result %q, got %q", tc.result, result)
+			}
+			if tc.host.cancelled != tc.cancelled || !tc.host.destroyed {
+				t.Fatalf("expected cancelled to be %t and the task destroyed, got %t and %t", tc.cancelled, tc.host.cancelled, tc.host.destroyed)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package xenserver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+)
+
+const (
+	defaultCreateTimeout = 30 * time.Minute
+	defaultReadTimeout   = 5 * time.Minute
+	defaultUpdateTimeout = 30 * time.Minute
+	defaultDeleteTimeout = 20 * time.Minute
+)
+
+// timeoutsBlock returns the `timeouts` block of the resources. The timeout of
+// an operation is applied to its context, which bounds every wait in it.
+func timeoutsBlock(ctx context.Context) schema.Block {
+	return timeouts.Block(ctx, timeouts.Opts{
+		Create:            true,
+		Read:              true,
+		Update:            true,
+		Delete:            true,
+		CreateDescription: "The timeout of the create operation, a duration such as `\"30s\"` or `\"2h45m\"`, default to be `\"30m\"`.",
+		ReadDescription:   "The timeout of the read operation, a duration such as `\"30s\"` or `\"2h45m\"`, default to be `\"5m\"`.",
+		UpdateDescription: "The timeout of the update operation, a duration such as `\"30s\"` or `\"2h45m\"`, default to be `\"30m\"`.",
+		DeleteDescription: "The timeout of the delete operation, a duration such as `\"30s\"` or `\"2h45m\"`, default to be `\"20m\"`.",
+	})
+}
+
+// sleepWithContext waits for the duration unless the context is done first,
+// e.g. when the timeout of the operation expires.
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return errors.New("operation timed out. " + ctx.Err().Error())
+	case <-timer.C:
+		return nil
+	}
+}
@@ -1,7 +1,7 @@
 package xenserver
 
 import (
-	"errors"
+	"fmt"
 
 	"xenapi"
 )
@@ -23,7 +23,7 @@ func getUUIDFromBlobRef(session *xenapi.Session, ref xenapi.BlobRef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.Blob.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get blob UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get blob UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -48,7 +48,7 @@ func getUUIDFromBondRef(session *xenapi.Session, ref xenapi.BondRef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.Bond.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get bond UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get bond UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -73,7 +73,7 @@ func getUUIDFromConsoleRef(session *xenapi.Session, ref xenapi.ConsoleRef) (stri
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.Console.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get console UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get console UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -98,7 +98,7 @@ func getUUIDFromCrashdumpRef(session *xenapi.Session, ref xenapi.CrashdumpRef) (
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.Crashdump.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get crash dump UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get crash dump UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -109,7 +109,7 @@ func getUUIDFromDRTaskRef(session *xenapi.Session, ref xenapi.DRTaskRef) (string
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.DRTask.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get DR task UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get DR task UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -120,7 +120,7 @@ func getUUIDFromHostRef(session *xenapi.Session, ref xenapi.HostRef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.Host.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get host UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get host UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -131,7 +131,7 @@ func getUUIDFromNetworkRef(session *xenapi.Session, ref xenapi.NetworkRef) (stri
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.Network.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get network UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get network UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -156,7 +156,7 @@ func getUUIDFromNetworkSriovRef(session *xenapi.Session, ref xenapi.NetworkSriov
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.NetworkSriov.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get network sr-iov UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get network sr-iov UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -181,7 +181,7 @@ func getUUIDFromPBDRef(session *xenapi.Session, ref xenapi.PBDRef) (string, erro
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.PBD.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get PBD UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get PBD UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -206,7 +206,7 @@ func getUUIDFromPCIRef(session *xenapi.Session, ref xenapi.PCIRef) (string, erro
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.PCI.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get PCI UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get PCI UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -231,7 +231,7 @@ func getUUIDFromPIFRef(session *xenapi.Session, ref xenapi.PIFRef) (string, erro
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.PIF.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get PIF UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get PIF UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -242,7 +242,7 @@ func getUUIDFromSRRef(session *xenapi.Session, ref xenapi.SRRef) (string, error)
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.SR.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get SR UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get SR UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -267,7 +267,7 @@ func getUUIDFromTunnelRef(session *xenapi.Session, ref xenapi.TunnelRef) (string
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.Tunnel.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get tunnel UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get tunnel UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -292,7 +292,7 @@ func getUUIDFromVBDRef(session *xenapi.Session, ref xenapi.VBDRef) (string, erro
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VBD.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VBD UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VBD UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -317,7 +317,7 @@ func getUUIDFromVDIRef(session *xenapi.Session, ref xenapi.VDIRef) (string, erro
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VDI.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VDI UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VDI UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -342,7 +342,7 @@ func getUUIDFromVGPURef(session *xenapi.Session, ref xenapi.VGPURef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VGPU.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get vGPU UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get vGPU UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -380,7 +380,7 @@ func getUUIDFromVIFRef(session *xenapi.Session, ref xenapi.VIFRef) (string, erro
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VIF.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VIF UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VIF UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -405,7 +405,7 @@ func getUUIDFromVLANRef(session *xenapi.Session, ref xenapi.VLANRef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VLAN.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get vlan UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get vlan UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -432,7 +432,7 @@ func getUUIDFromVMRef(session *xenapi.Session, ref xenapi.VMRef) (string, error)
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VM.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VM UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VM UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -443,7 +443,7 @@ func getUUIDFromVMApplianceRef(session *xenapi.Session, ref xenapi.VMApplianceRe
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VMAppliance.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VM appliance UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VM appliance UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -468,7 +468,7 @@ func getUUIDFromVMGroupRef(session *xenapi.Session, ref xenapi.VMGroupRef) (stri
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VMGroup.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VM group UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VM group UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -479,7 +479,7 @@ func getUUIDFromVMPPRef(session *xenapi.Session, ref xenapi.VMPPRef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VMPP.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VMPP UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VMPP UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -490,7 +490,7 @@ func getUUIDFromVMSSRef(session *xenapi.Session, ref xenapi.VMSSRef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VMSS.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VMSS UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VMSS UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -501,7 +501,7 @@ func getUUIDFromVMMetricsRef(session *xenapi.Session, ref xenapi.VMMetricsRef) (
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VMMetrics.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VM metrics UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VM metrics UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -512,7 +512,7 @@ func getUUIDFromVMGuestMetricsRef(session *xenapi.Session, ref xenapi.VMGuestMet
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VMGuestMetrics.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VM guest metrics UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VM guest metrics UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -537,7 +537,7 @@ func getUUIDFromVTPMRef(session *xenapi.Session, ref xenapi.VTPMRef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VTPM.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VTPM UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VTPM UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -562,7 +562,7 @@ func getUUIDFromVUSBRef(session *xenapi.Session, ref xenapi.VUSBRef) (string, er
 	if string(ref) != "" && string(ref) != "OpaqueRef:NULL" {
 		uuid, err := xenapi.VUSB.GetUUID(session, ref)
 		if err != nil {
-			return uuid, errors.New("unable to get VUSB UUID. " + err.Error())
+			return uuid, fmt.Errorf("unable to get VUSB UUID. %w", err)
 		}
 		return uuid, nil
 	}
@@ -3,12 +3,16 @@ package xenserver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"sort"
-	"strings"
+	"strconv"
 	"xenapi"
 
+	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
 	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/types"
@@ -20,6 +24,8 @@ type vbdResourceModel struct {
 	VBD      types.String `tfsdk:"vbd_ref"`
 	Mode     types.String `tfsdk:"mode"`
 	Bootable types.Bool   `tfsdk:"bootable"`
+	Size     types.Int64  `tfsdk:"size"`
+	SR       types.String `tfsdk:"sr_uuid"`
 }
 
 var vbdResourceModelAttrTypes = map[string]attr.Type{
@@ -27,14 +33,37 @@ var vbdResourceModelAttrTypes = map[string]attr.Type{
 	"vbd_ref":  types.StringType,
 	"mode":     types.StringType,
 	"bootable": types.BoolType,
+	"size":     types.Int64Type,
+	"sr_uuid":  types.StringType,
 }
 
 func vbdSchema() map[string]schema.Attribute {
 	return map[string]schema.Attribute{
 		"vdi_uuid": schema.StringAttribute{
-			MarkdownDescription: "VDI UUID to attach to VBD." + "<br />" +
+			MarkdownDescription: "VDI UUID to attach to VBD, or the UUID of the VDI created with `size` and `sr_uuid`." + "<br />" +
 				"**Note**: Using the same VDI UUID for multiple VBDs is not supported.",
-			Required: true,
+			Optional: true,
+			Computed: true,
+			Validators: []validator.String{
+				stringvalidator.ExactlyOneOf(path.MatchRelative().AtParent().AtName("size")),
+			},
+		},
+		"size": schema.Int64Attribute{
+			MarkdownDescription: "The size (in bytes) of the VDI to create on `sr_uuid` instead of attaching an existing VDI, the VDI is destroyed with the item." + "<br />" +
+				"**Note**: The size can only be increased, the VDI is resized online when the VM is running.",
+			Optional: true,
+			Validators: []validator.Int64{
+				int64validator.AtLeast(1),
+				int64validator.AlsoRequires(path.MatchRelative().AtParent().AtName("sr_uuid")),
+			},
+		},
+		"sr_uuid": schema.StringAttribute{
+			MarkdownDescription: "The UUID of the storage repository of the VDI created with `size`." + "<br />" +
+				"**Note**: Updating `sr_uuid` moves the VDI to the new storage repository, the moved VDI gets a new `vdi_uuid`.",
+			Optional: true,
+			Validators: []validator.String{
+				stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("size")),
+			},
 		},
 		"vbd_ref": schema.StringAttribute{
 			Computed: true,
@@ -67,20 +96,22 @@ func setVBDDefaults(vbd *vbdResourceModel) {
 	}
 }
 
-func createVBD(session *xenapi.Session, vmRef xenapi.VMRef, vbd vbdResourceModel, vbdType xenapi.VbdType) error {
+// createVBD attaches a disk to the VM. The caller holds the lock of the VM, so
+// that the device allowed for the VBD isn't used by another VBD meanwhile.
+func createVBD(session *xenapi.Session, vmRef xenapi.VMRef, vbd vbdResourceModel, vbdType xenapi.VbdType) (xenapi.VBDRef, error) {
 	var vbdRef xenapi.VBDRef
 	vdiRef, err := xenapi.VDI.GetByUUID(session, vbd.VDI.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return vbdRef, newXAPIError(err)
 	}
 
 	userDevices, err := xenapi.VM.GetAllowedVBDDevices(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return vbdRef, newXAPIError(err)
 	}
 
 	if len(userDevices) == 0 {
-		return errors.New("unable to find available vbd devices to attach to vm " + string(vmRef))
+		return vbdRef, errors.New("unable to find available vbd devices to attach to vm " + string(vmRef))
 	}
 
 	setVBDDefaults(&vbd)
@@ -102,26 +133,178 @@ func createVBD(session *xenapi.Session, vmRef xenapi.VMRef, vbd vbdResourceModel
 
 	vbdRef, err = xenapi.VBD.Create(session, vbdRecord)
 	if err != nil {
-		return errors.New(err.Error())
+		return vbdRef, newXAPIError(err)
 	}
 
 	// plug VBDs if VM is running
 	vmPowerState, err := xenapi.VM.GetPowerState(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return vbdRef, newXAPIError(err)
 	}
 
 	if vmPowerState == xenapi.VMPowerStateRunning {
 		err = xenapi.VBD.Plug(session, vbdRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return vbdRef, newXAPIError(err)
+		}
+	}
+
+	return vbdRef, nil
+}
+
+// isInlineHardDrive returns true if the VDI of the hard drive is created with
+// its size rather than referred by its UUID.
+func isInlineHardDrive(vbd vbdResourceModel) bool {
+	return !vbd.Size.IsNull()
+}
+
+// createInlineHardDrive creates the VDI of the inline hard drive and attaches
+// it to the VM. The VBD is added to the inline VBDs of the private state, so
+// that the VDI is destroyed with the VM.
+func createInlineHardDrive(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, vbd vbdResourceModel, vmPrivate *vmPrivateState) error {
+	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	srRef, err := xenapi.SR.GetByUUID(session, vbd.SR.ValueString())
+	if err != nil {
+		return fmt.Errorf("unable to find the SR %s. %w", vbd.SR.ValueString(), err)
+	}
+
+	tflog.Debug(ctx, "---> Create the VDI of the hard drive on the SR "+vbd.SR.ValueString())
+	vdiRef, err := xenapi.VDI.Create(session, xenapi.VDIRecord{
+		NameLabel:       vmRecord.NameLabel + " disk",
+		NameDescription: "A hard drive of the VM " + vmRecord.UUID,
+		SR:              srRef,
+		VirtualSize:     int(vbd.Size.ValueInt64()),
+		Type:            xenapi.VdiTypeUser,
+		OtherConfig:     map[string]string{},
+	})
+	if err != nil {
+		return newXAPIError(err)
+	}
+	vdiUUID, err := xenapi.VDI.GetUUID(session, vdiRef)
+	if err != nil {
+		_ = xenapi.VDI.Destroy(session, vdiRef)
+		return newXAPIError(err)
+	}
+
+	vbd.VDI = types.StringValue(vdiUUID)
+	vbdRef, err := createVBD(session, vmRef, vbd, xenapi.VbdTypeDisk)
+	if err != nil {
+		if vbdRef != "" {
+			_ = xenapi.VBD.Destroy(session, vbdRef)
+		}
+		_ = xenapi.VDI.Destroy(session, vdiRef)
+		return err
+	}
+	vmPrivate.InlineVBDs = append(vmPrivate.InlineVBDs, vbdRef)
+	return nil
+}
+
+// updateInlineHardDrive moves the VDI of the inline hard drive to the SR and
+// resizes it as planned.
+func updateInlineHardDrive(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vbdResourceModel, state vbdResourceModel, vmPrivate *vmPrivateState) error {
+	if plan.Size.ValueInt64() < state.Size.ValueInt64() {
+		return errors.New("unable to decrease the size of the item in hard_drive, from " +
+			strconv.FormatInt(state.Size.ValueInt64(), 10) + " to " + strconv.FormatInt(plan.Size.ValueInt64(), 10) + " bytes")
+	}
+	vbdRef := xenapi.VBDRef(state.VBD.ValueString())
+	vdiRef, err := xenapi.VBD.GetVDI(session, vbdRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+
+	if !plan.SR.Equal(state.SR) {
+		srRef, err := xenapi.SR.GetByUUID(session, plan.SR.ValueString())
+		if err != nil {
+			return fmt.Errorf("unable to find the SR %s. %w", plan.SR.ValueString(), err)
+		}
+		vdiRef, err = migrateVDI(ctx, session, vdiRef, srRef)
+		if err != nil {
+			return err
+		}
+		// the VBD is recreated when the VDI is copied to the SR
+		vbdRefs, err := xenapi.VDI.GetVBDs(session, vdiRef)
+		if err != nil {
+			return newXAPIError(err)
+		}
+		for _, ref := range vbdRefs {
+			if vm, err := xenapi.VBD.GetVM(session, ref); err == nil && vm == vmRef {
+				vmPrivate.InlineVBDs = slices.DeleteFunc(vmPrivate.InlineVBDs, func(inline xenapi.VBDRef) bool { return inline == vbdRef })
+				vmPrivate.InlineVBDs = append(vmPrivate.InlineVBDs, ref)
+			}
+		}
+	}
+
+	if plan.Size.ValueInt64() > state.Size.ValueInt64() {
+		err = resizeVDI(ctx, session, vdiRef, plan.Size.ValueInt64())
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
+// destroyInlineHardDrive destroys the VDI of the inline hard drive once its
+// VBD is destroyed.
+func destroyInlineHardDrive(session *xenapi.Session, vbdRef xenapi.VBDRef, vmPrivate *vmPrivateState) error {
+	vdiRef, err := xenapi.VBD.GetVDI(session, vbdRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	err = xenapi.VBD.Destroy(session, vbdRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	err = xenapi.VDI.Destroy(session, vdiRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	vmPrivate.InlineVBDs = slices.DeleteFunc(vmPrivate.InlineVBDs, func(inline xenapi.VBDRef) bool { return inline == vbdRef })
 	return nil
 }
 
-func createVBDs(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, data vmResourceModel, vbdType xenapi.VbdType) error {
+// pairInlineHardDrives matches the new inline hard drives of the plan, which
+// have no VDI UUID yet, with the inline hard drives of the state which aren't
+// in the plan anymore. A hard drive of the same size on the same SR is kept
+// first. Then the hard drive left is resized or moved to another SR only if
+// the pairing is unambiguous, i.e. a single hard drive is left on both sides,
+// otherwise the new hard drives left are created and the others destroyed.
+func pairInlineHardDrives(planHardDrives []vbdResourceModel, stateHardDrivesMap map[string]vbdResourceModel, planHardDrivesMap map[string]vbdResourceModel) []vbdResourceModel {
+	var removed []string
+	for vdiUUID, stateVBD := range stateHardDrivesMap {
+		if _, ok := planHardDrivesMap[vdiUUID]; !ok && isInlineHardDrive(stateVBD) {
+			removed = append(removed, vdiUUID)
+		}
+	}
+	sort.Strings(removed)
+
+	var left []vbdResourceModel
+	for _, planVBD := range planHardDrives {
+		index := slices.IndexFunc(removed, func(vdiUUID string) bool {
+			stateVBD := stateHardDrivesMap[vdiUUID]
+			return stateVBD.Size.Equal(planVBD.Size) && stateVBD.SR.Equal(planVBD.SR)
+		})
+		if index < 0 {
+			left = append(left, planVBD)
+			continue
+		}
+		planVBD.VDI = types.StringValue(removed[index])
+		planHardDrivesMap[removed[index]] = planVBD
+		removed = slices.Delete(removed, index, index+1)
+	}
+
+	if len(removed) == 1 && len(left) == 1 {
+		planVBD := left[0]
+		planVBD.VDI = types.StringValue(removed[0])
+		planHardDrivesMap[removed[0]] = planVBD
+		return nil
+	}
+	return left
+}
+
+func createVBDs(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, data vmResourceModel, vbdType xenapi.VbdType, vmPrivate *vmPrivateState) error {
 	if data.HardDrive.IsUnknown() || len(data.HardDrive.Elements()) == 0 {
 		tflog.Debug(ctx, "---> Skip create VBDs")
 		return nil
@@ -139,8 +322,15 @@ func createVBDs(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef
 	})
 
 	for _, vbd := range elements {
+		if isInlineHardDrive(vbd) {
+			err := createInlineHardDrive(ctx, session, vmRef, vbd, vmPrivate)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		tflog.Debug(ctx, "---> Create VBD with VDI: "+vbd.VDI.String()+"  Mode: "+vbd.Mode.String()+"  Bootable: "+vbd.Bootable.String())
-		err := createVBD(session, vmRef, vbd, vbdType)
+		_, err := createVBD(session, vmRef, vbd, vbdType)
 		if err != nil {
 			return err
 		}
@@ -149,7 +339,7 @@ func createVBDs(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef
 	return nil
 }
 
-func updateVBDs(ctx context.Context, plan vmResourceModel, state vmResourceModel, vmRef xenapi.VMRef, session *xenapi.Session) error {
+func updateVBDs(ctx context.Context, plan vmResourceModel, state vmResourceModel, vmRef xenapi.VMRef, session *xenapi.Session, vmPrivate *vmPrivateState) error {
 	planHardDrives := make([]vbdResourceModel, 0, len(state.HardDrive.Elements()))
 	if !plan.HardDrive.IsUnknown() {
 		diags := plan.HardDrive.ElementsAs(ctx, &planHardDrives, false)
@@ -167,19 +357,27 @@ func updateVBDs(ctx context.Context, plan vmResourceModel, state vmResourceModel
 	}
 
 	var err error
+	var newInlineHardDrives []vbdResourceModel
 	planHardDrivesMap := make(map[string]vbdResourceModel)
 	for _, vbd := range planHardDrives {
+		// the inline hard drives to create have no VDI yet
+		if vbd.VDI.IsUnknown() || vbd.VDI.IsNull() {
+			newInlineHardDrives = append(newInlineHardDrives, vbd)
+			continue
+		}
 		planHardDrivesMap[vbd.VDI.ValueString()] = vbd
 	}
 
+	// the VDI of a VBD changes when the disk is moved to another SR
 	stateHardDrivesMap := make(map[string]vbdResourceModel)
 	for _, vbd := range stateHardDrives {
-		stateHardDrivesMap[vbd.VDI.ValueString()] = vbd
+		stateHardDrivesMap[getVBDVDIUUID(session, vbd)] = vbd
 	}
+	newInlineHardDrives = pairInlineHardDrives(newInlineHardDrives, stateHardDrivesMap, planHardDrivesMap)
 
 	vmState, err := xenapi.VM.GetPowerState(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	// Destroy VBDs that are not in plan
@@ -189,10 +387,17 @@ func updateVBDs(ctx context.Context, plan vmResourceModel, state vmResourceModel
 				return errors.New("unable to delete the item in hard_drive for a running VM")
 			}
 			tflog.Debug(ctx, "---> Destroy VBD:	"+stateVBD.VBD.String())
+			if slices.Contains(vmPrivate.InlineVBDs, xenapi.VBDRef(stateVBD.VBD.ValueString())) {
+				err = destroyInlineHardDrive(session, xenapi.VBDRef(stateVBD.VBD.ValueString()), vmPrivate)
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			err = xenapi.VBD.Destroy(session, xenapi.VBDRef(stateVBD.VBD.ValueString()))
 			if err != nil {
-				if !strings.Contains(err.Error(), "HANDLE_INVALID") {
-					return errors.New(err.Error())
+				if !isXAPIError(err, "HANDLE_INVALID") {
+					return newXAPIError(err)
 				}
 				tflog.Debug(ctx, "HANDLE_INVALID: VBD already been destroyed.")
 			}
@@ -203,11 +408,19 @@ func updateVBDs(ctx context.Context, plan vmResourceModel, state vmResourceModel
 	for vdiUUID, planVBD := range planHardDrivesMap {
 		stateVBD, ok := stateHardDrivesMap[vdiUUID]
 		if !ok {
+			attached, err := isVDIAttachedToVM(session, vdiUUID, vmRef)
+			if err != nil {
+				return err
+			}
+			if attached {
+				// the VBD was recreated when the disk was moved to another SR
+				continue
+			}
 			if vmState == xenapi.VMPowerStateRunning && planVBD.Mode.ValueString() == "RO" {
 				return errors.New("unable to create the item with 'RO' mode in hard_drive for a running VM")
 			}
 			tflog.Debug(ctx, "---> Create VBD for VDI: "+vdiUUID+" <---")
-			err = createVBD(session, vmRef, planVBD, xenapi.VbdTypeDisk)
+			_, err = createVBD(session, vmRef, planVBD, xenapi.VbdTypeDisk)
 			if err != nil {
 				return err
 			}
@@ -222,7 +435,7 @@ func updateVBDs(ctx context.Context, plan vmResourceModel, state vmResourceModel
 				tflog.Debug(ctx, "---> VBD.SetMode:	"+planVBD.Mode.String())
 				err = xenapi.VBD.SetMode(session, xenapi.VBDRef(stateVBD.VBD.ValueString()), xenapi.VbdMode(planVBD.Mode.ValueString()))
 				if err != nil {
-					return errors.New(err.Error())
+					return newXAPIError(err)
 				}
 			}
 
@@ -233,25 +446,79 @@ func updateVBDs(ctx context.Context, plan vmResourceModel, state vmResourceModel
 				tflog.Debug(ctx, "---> VBD.SetBootable:	"+planVBD.Bootable.String())
 				err = xenapi.VBD.SetBootable(session, xenapi.VBDRef(stateVBD.VBD.ValueString()), planVBD.Bootable.ValueBool())
 				if err != nil {
-					return errors.New(err.Error())
+					return newXAPIError(err)
+				}
+			}
+
+			// the VBD is recreated when its VDI is copied to another SR
+			if isInlineHardDrive(planVBD) && isInlineHardDrive(stateVBD) {
+				err = updateInlineHardDrive(ctx, session, vmRef, planVBD, stateVBD, vmPrivate)
+				if err != nil {
+					return err
 				}
 			}
 		}
 	}
 
+	for _, planVBD := range newInlineHardDrives {
+		if vmState == xenapi.VMPowerStateRunning && planVBD.Mode.ValueString() == "RO" {
+			return errors.New("unable to create the item with 'RO' mode in hard_drive for a running VM")
+		}
+		err = createInlineHardDrive(ctx, session, vmRef, planVBD, vmPrivate)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// getVBDVDIUUID returns the UUID of the current VDI of the VBD, or the UUID of
+// the model when the VBD doesn't exist anymore.
+func getVBDVDIUUID(session *xenapi.Session, vbd vbdResourceModel) string {
+	vdiRef, err := xenapi.VBD.GetVDI(session, xenapi.VBDRef(vbd.VBD.ValueString()))
+	if err != nil {
+		return vbd.VDI.ValueString()
+	}
+	vdiUUID, err := xenapi.VDI.GetUUID(session, vdiRef)
+	if err != nil {
+		return vbd.VDI.ValueString()
+	}
+	return vdiUUID
+}
+
+// isVDIAttachedToVM returns true if the VM has a VBD on the VDI.
+func isVDIAttachedToVM(session *xenapi.Session, vdiUUID string, vmRef xenapi.VMRef) (bool, error) {
+	vdiRef, err := xenapi.VDI.GetByUUID(session, vdiUUID)
+	if err != nil {
+		return false, newXAPIError(err)
+	}
+	vbdRefs, err := xenapi.VDI.GetVBDs(session, vdiRef)
+	if err != nil {
+		return false, newXAPIError(err)
+	}
+	for _, vbdRef := range vbdRefs {
+		ref, err := xenapi.VBD.GetVM(session, vbdRef)
+		if err != nil {
+			return false, newXAPIError(err)
+		}
+		if ref == vmRef {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func getAllDiskTypeVBDs(session *xenapi.Session, vmRef xenapi.VMRef) ([]string, error) {
 	var diskRefs []string
 	vbdRefs, err := xenapi.VM.GetVBDs(session, vmRef)
 	if err != nil {
-		return diskRefs, errors.New(err.Error())
+		return diskRefs, newXAPIError(err)
 	}
 	for _, vbdRef := range vbdRefs {
 		vbdType, err := xenapi.VBD.GetType(session, vbdRef)
 		if err != nil {
-			return diskRefs, errors.New(err.Error())
+			return diskRefs, newXAPIError(err)
 		}
 		if vbdType == xenapi.VbdTypeDisk {
 			diskRefs = append(diskRefs, string(vbdRef))
@@ -260,26 +527,11 @@ func getAllDiskTypeVBDs(session *xenapi.Session, vmRef xenapi.VMRef) ([]string,
 	return diskRefs, nil
 }
 
-func getTemplateVBDRefListFromVMRecord(vmRecord xenapi.VMRecord) []xenapi.VBDRef {
-	templateVBDRefs, ok := vmRecord.OtherConfig["tf_template_vbds"]
-	if !ok {
-		templateVBDRefs = ""
-	}
-	templateVBDRefList := []xenapi.VBDRef{}
-	if templateVBDRefs != "" {
-		refs := strings.Split(templateVBDRefs, ",")
-		for _, ref := range refs {
-			templateVBDRefList = append(templateVBDRefList, xenapi.VBDRef(ref))
-		}
-	}
-	return templateVBDRefList
-}
-
 func getVDIUUIDFromISOName(session *xenapi.Session, isoName string) (string, error) {
 	var vdiUUID string
 	vdiRecords, err := xenapi.VDI.GetAllRecords(session)
 	if err != nil {
-		return vdiUUID, errors.New(err.Error())
+		return vdiUUID, newXAPIError(err)
 	}
 
 	vdiUUIDList := make([]string, 0)
@@ -310,7 +562,7 @@ func setCDROM(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef,
 	planCDROM := plan.CDROM.ValueString()
 	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	baseCD, err := getCDFromVMRecord(ctx, session, vmRecord)
 	if err != nil {
@@ -351,18 +603,18 @@ func changeVMISO(ctx context.Context, session *xenapi.Session, cd cdVBD, vdiUUID
 		tflog.Debug(ctx, "---> Eject the exist ISO")
 		err := xenapi.VBD.Eject(session, cd.vbdRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 	if vdiUUID != "" {
 		tflog.Debug(ctx, "---> Insert the new ISO")
 		vdiRef, err := xenapi.VDI.GetByUUID(session, vdiUUID)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 		err = xenapi.VBD.Insert(session, cd.vbdRef, vdiRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 	return nil
@@ -375,7 +627,7 @@ func createCDROM(session *xenapi.Session, vmRef xenapi.VMRef, isoName string) er
 	}
 	var vbdRes vbdResourceModel
 	vbdRes.VDI = types.StringValue(vdiUUID)
-	err = createVBD(session, vmRef, vbdRes, xenapi.VbdTypeCD)
+	_, err = createVBD(session, vmRef, vbdRes, xenapi.VbdTypeCD)
 	if err != nil {
 		return err
 	}
@@ -391,7 +643,7 @@ type cdVBD struct {
 
 func getCDFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord) (cdVBD, error) {
 	var cd cdVBD
-	_, vbdSet, err := getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeCD)
+	_, vbdSet, err := getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeCD, nil, nil)
 	if err != nil {
 		return cd, err
 	}
@@ -409,7 +661,7 @@ func getCDFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord xe
 	if string(cd.vbdRef) != "OpaqueRef:NULL" {
 		empty, err := xenapi.VBD.GetEmpty(session, cd.vbdRef)
 		if err != nil {
-			return cd, errors.New(err.Error())
+			return cd, newXAPIError(err)
 		}
 		cd.empty = empty
 	}
@@ -417,11 +669,11 @@ func getCDFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord xe
 	if vdiUUID != "" {
 		vdiRef, err := xenapi.VDI.GetByUUID(session, vdiUUID)
 		if err != nil {
-			return cd, errors.New(err.Error())
+			return cd, newXAPIError(err)
 		}
 		isoName, err := xenapi.VDI.GetNameLabel(session, vdiRef)
 		if err != nil {
-			return cd, errors.New(err.Error())
+			return cd, newXAPIError(err)
 		}
 		cd.isoName = isoName
 	}
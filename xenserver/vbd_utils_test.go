@@ -0,0 +1,249 @@
+package xenserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"xenapi"
+)
+
+// inlineHardDriveSet returns the hard_drive set of an inline hard drive to
+// create with the size on the SR.
+func inlineHardDriveSet(size int64, srUUID string) types.Set {
+	return types.SetValueMust(types.ObjectType{AttrTypes: vbdResourceModelAttrTypes}, []attr.Value{
+		types.ObjectValueMust(vbdResourceModelAttrTypes, map[string]attr.Value{
+			"vdi_uuid": types.StringUnknown(),
+			"vbd_ref":  types.StringUnknown(),
+			"mode":     types.StringValue("RW"),
+			"bootable": types.BoolValue(false),
+			"size":     types.Int64Value(size),
+			"sr_uuid":  types.StringValue(srUUID),
+		}),
+	})
+}
+
+func TestInlineHardDrive(t *testing.T) {
+	fake := newFakeXAPI("root", "password")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	srRef, err := xenapi.Pool.GetDefaultSR(session, mustGetPool(t, session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srUUID, err := xenapi.SR.GetUUID(session, srRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templateRef, err := getFirstTemplate(session, "Debian Bullseye 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.SetIsATemplate(session, vmRef, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the VDI is created with the VM
+	var vmPrivate vmPrivateState
+	plan := vmResourceModel{HardDrive: inlineHardDriveSet(1073741824, srUUID)}
+	err = createVBDs(ctx, session, vmRef, plan, xenapi.VbdTypeDisk, &vmPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vmPrivate.InlineVBDs) != 1 {
+		t.Fatalf("expected an inline VBD, got %v", vmPrivate.InlineVBDs)
+	}
+	vbdRef := vmPrivate.InlineVBDs[0]
+	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hardDrive, vbds, err := getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeDisk, nil, vmPrivate.InlineVBDs)
+	if err != nil || len(vbds) != 1 || vbds[0].Size.ValueInt64() != 1073741824 || vbds[0].SR.ValueString() != srUUID {
+		t.Fatalf("expected the inline hard drive of 1 GiB on %s, got %v: %v", srUUID, vbds, err)
+	}
+
+	// the VDI is resized online
+	err = xenapi.VM.Start(session, vmRef, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VBD.Plug(session, vbdRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	fake.faults["vdi.resize"] = fakeError("SR_OPERATION_NOT_SUPPORTED", string(srRef))
+	fake.mu.Unlock()
+	state := vmResourceModel{HardDrive: hardDrive}
+	plan = vmResourceModel{HardDrive: inlineHardDriveSet(2147483648, srUUID)}
+	err = updateVBDs(ctx, plan, state, vmRef, session, &vmPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRef, err := xenapi.VBD.GetVDI(session, vbdRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := xenapi.VDI.GetVirtualSize(session, vdiRef)
+	if err != nil || size != 2147483648 {
+		t.Fatalf("expected the VDI to be resized to 2 GiB, got %d: %v", size, err)
+	}
+
+	// the VDI can't be shrunk
+	vmRecord, err = xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.HardDrive, _, err = getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeDisk, nil, vmPrivate.InlineVBDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan = vmResourceModel{HardDrive: inlineHardDriveSet(1073741824, srUUID)}
+	err = updateVBDs(ctx, plan, state, vmRef, session, &vmPrivate)
+	if err == nil {
+		t.Fatal("expected an error when the hard drive is shrunk")
+	}
+
+	// the VDI is moved to another SR while the VM is locked by the update
+	err = xenapi.VM.HardShutdown(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	sr := fake.createSR(fake.ref("host"), map[string]any{"device": "/dev/sdb"}, 536870912000, "Second storage", "", "ext", "user", false)
+	fake.set(sr, "allowed_operations", []any{"vdi_create"})
+	fake.mu.Unlock()
+	secondSRUUID, err := xenapi.SR.GetUUID(session, xenapi.SRRef(sr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan = vmResourceModel{HardDrive: inlineHardDriveSet(2147483648, secondSRUUID)}
+	state.HardDrive, _, err = getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeDisk, nil, vmPrivate.InlineVBDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		unlock, err := lockObject(ctx, string(vmRef))
+		if err != nil {
+			done <- err
+			return
+		}
+		defer unlock()
+		done <- updateVBDs(ctx, plan, state, vmRef, session, &vmPrivate)
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the update of the hard drives locks the VM again")
+	}
+	vmRecord, err = xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.HardDrive, vbds, err = getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeDisk, nil, vmPrivate.InlineVBDs)
+	if err != nil || len(vbds) != 1 || vbds[0].SR.ValueString() != secondSRUUID || vbds[0].Size.ValueInt64() != 2147483648 {
+		t.Fatalf("expected the inline hard drive of 2 GiB on %s, got %v: %v", secondSRUUID, vbds, err)
+	}
+	vdiRef, err = xenapi.VBD.GetVDI(session, xenapi.VBDRef(vbds[0].VBD.ValueString()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the VDI is destroyed with the item
+	plan = vmResourceModel{HardDrive: types.SetValueMust(types.ObjectType{AttrTypes: vbdResourceModelAttrTypes}, []attr.Value{})}
+	err = updateVBDs(ctx, plan, state, vmRef, session, &vmPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = xenapi.VDI.GetRecord(session, vdiRef); err == nil || len(vmPrivate.InlineVBDs) != 0 {
+		t.Fatalf("expected the VDI of the inline hard drive to be destroyed, got %v", vmPrivate.InlineVBDs)
+	}
+}
+
+func TestPairInlineHardDrives(t *testing.T) {
+	inline := func(vdiUUID string, size int64, srUUID string) vbdResourceModel {
+		return vbdResourceModel{VDI: types.StringValue(vdiUUID), Size: types.Int64Value(size), SR: types.StringValue(srUUID)}
+	}
+	planned := func(size int64, srUUID string) vbdResourceModel {
+		return vbdResourceModel{VDI: types.StringUnknown(), Size: types.Int64Value(size), SR: types.StringValue(srUUID)}
+	}
+
+	tests := []struct {
+		name    string
+		state   []vbdResourceModel
+		plan    []vbdResourceModel
+		paired  map[string]int64
+		created int
+	}{
+		{
+			name:   "unchanged hard drives",
+			state:  []vbdResourceModel{inline("a", 1, "sr"), inline("b", 2, "sr")},
+			plan:   []vbdResourceModel{planned(2, "sr"), planned(1, "sr")},
+			paired: map[string]int64{"a": 1, "b": 2},
+		},
+		{
+			name:   "the smaller hard drive is removed",
+			state:  []vbdResourceModel{inline("a", 1, "sr"), inline("b", 2, "sr")},
+			plan:   []vbdResourceModel{planned(2, "sr")},
+			paired: map[string]int64{"b": 2},
+		},
+		{
+			name:   "a single hard drive is resized",
+			state:  []vbdResourceModel{inline("a", 1, "sr"), inline("b", 2, "sr")},
+			plan:   []vbdResourceModel{planned(1, "sr"), planned(3, "sr")},
+			paired: map[string]int64{"a": 1, "b": 3},
+		},
+		{
+			name:    "several hard drives are changed",
+			state:   []vbdResourceModel{inline("a", 1, "sr"), inline("b", 2, "sr")},
+			plan:    []vbdResourceModel{planned(3, "sr"), planned(4, "sr")},
+			paired:  map[string]int64{},
+			created: 2,
+		},
+		{
+			name:    "a hard drive is added on another SR",
+			state:   []vbdResourceModel{inline("a", 1, "sr")},
+			plan:    []vbdResourceModel{planned(1, "sr"), planned(1, "other")},
+			paired:  map[string]int64{"a": 1},
+			created: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stateMap := make(map[string]vbdResourceModel)
+			for _, vbd := range test.state {
+				stateMap[vbd.VDI.ValueString()] = vbd
+			}
+			planMap := make(map[string]vbdResourceModel)
+			created := pairInlineHardDrives(test.plan, stateMap, planMap)
+			if len(created) != test.created || len(planMap) != len(test.paired) {
+				t.Fatalf("expected %d hard drives to create and %v paired, got %v and %v", test.created, test.paired, created, planMap)
+			}
+			for vdiUUID, size := range test.paired {
+				if planMap[vdiUUID].Size.ValueInt64() != size || planMap[vdiUUID].VDI.ValueString() != vdiUUID {
+					t.Fatalf("expected the hard drive %s paired with the size %d, got %v", vdiUUID, size, planMap)
+				}
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 
 	"xenapi"
@@ -17,6 +18,8 @@ var (
 	_ resource.Resource                = &vdiResource{}
 	_ resource.ResourceWithConfigure   = &vdiResource{}
 	_ resource.ResourceWithImportState = &vdiResource{}
+	_ resource.ResourceWithIdentity    = &vdiResource{}
+	_ resource.ResourceWithModifyPlan  = &vdiResource{}
 )
 
 func NewVDIResource() resource.Resource {
@@ -30,15 +33,24 @@ type vdiResource struct {
 
 func (r *vdiResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
 	resp.TypeName = req.ProviderTypeName + "_vdi"
+	// the VDI copied to another SR has a new UUID
+	resp.ResourceBehavior.MutableIdentity = true
 }
 
-func (r *vdiResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *vdiResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "Provides a virtual disk image resource.",
 		Attributes:          vdiSchema(),
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
+func (r *vdiResource) IdentitySchema(_ context.Context, _ resource.IdentitySchemaRequest, resp *resource.IdentitySchemaResponse) {
+	resp.IdentitySchema = uuidIdentitySchema("virtual disk image")
+}
+
 // Set the parameter of the resource, pass value from provider
 func (r *vdiResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
 	// Prevent panic if the provider has not been configured.
@@ -56,184 +68,319 @@ func (r *vdiResource) Configure(_ context.Context, req resource.ConfigureRequest
 	r.session = providerData.session
 }
 
+// ModifyPlan checks the plan against the pool, so that the invalid references
+// are reported by terraform plan.
+func (r *vdiResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// nothing to check when the resource is destroyed or the provider isn't
+	// configured yet
+	if req.Plan.Raw.IsNull() || r.session == nil {
+		return
+	}
+	var plan vdiResourceTimeoutsModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	var stateModel *vdiResourceModel
+	if !req.State.Raw.IsNull() {
+		var state vdiResourceTimeoutsModel
+		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+		stateModel = &state.vdiResourceModel
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(vdiResourceModelPlanCheck(r.session, plan.vdiResourceModel, stateModel)...)
+
+	// the moved VDI gets a new UUID
+	if stateModel != nil && !plan.SR.Equal(stateModel.SR) {
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("uuid"), types.StringUnknown())...)
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("id"), types.StringUnknown())...)
+	}
+}
+
 func (r *vdiResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
-	var data vdiResourceModel
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("create"))
+		return
+	}
+
+	var data vdiResourceTimeoutsModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	createTimeout, diags := data.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	tflog.Debug(ctx, "Creating VDI...")
-	record, err := getVDICreateParams(ctx, r.session, data)
+	record, err := getVDICreateParams(ctx, r.session, data.vdiResourceModel)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VDI create params",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	vdiRef, err := xenapi.VDI.Create(r.session, record)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to create VDI",
-			err.Error(),
-		)
+			err,
+			"sr_uuid",
+			"virtual_size",
+		))
 		return
 	}
 	vdiRecord, err := xenapi.VDI.GetRecord(r.session, vdiRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VDI record",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupVDIResource(r.session, vdiRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up VDI resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
-	err = updateVDIResourceModelComputed(ctx, vdiRecord, &data)
+	err = updateVDIResourceModelComputed(ctx, vdiRecord, &data.vdiResourceModel)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of VDIResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		err = cleanupVDIResource(r.session, vdiRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Error cleaning up VDI resource",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 	tflog.Debug(ctx, "VDI created")
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 func (r *vdiResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
-	var data vdiResourceModel
+	var data vdiResourceTimeoutsModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	readTimeout, diags := data.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	// Overwrite data with refreshed resource state
 	vdiRef, err := xenapi.VDI.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VDI ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	vdiRecord, err := xenapi.VDI.GetRecord(r.session, vdiRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VDI record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
-	err = updateVDIResourceModel(ctx, r.session, vdiRecord, &data)
+	err = updateVDIResourceModel(ctx, r.session, vdiRecord, &data.vdiResourceModel)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the fields of VDIResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, data.UUID)...)
 }
 
 func (r *vdiResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
-	var plan, state vdiResourceModel
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("update"))
+		return
+	}
+
+	var plan, state vdiResourceTimeoutsModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	updateTimeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	// Checking if configuration changes are allowed
 	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
-	err := vdiResourceModelUpdateCheck(plan, state)
+	err := vdiResourceModelUpdateCheck(plan.vdiResourceModel, state.vdiResourceModel)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Error update xenserver_vdi configuration",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	// Update the resource with new configuration
-	vdiRef, err := xenapi.VDI.GetByUUID(r.session, plan.UUID.ValueString())
+	vdiRef, err := xenapi.VDI.GetByUUID(r.session, state.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VDI ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
-	err = vdiResourceModelUpdate(ctx, r.session, vdiRef, plan)
+	if !plan.SR.Equal(state.SR) {
+		srRef, err := xenapi.SR.GetByUUID(r.session, plan.SR.ValueString())
+		if err != nil {
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
+				"Unable to get SR ref",
+				err,
+				"sr_uuid",
+			))
+			return
+		}
+		// The VBDs of the VMs are recreated when the VDI is copied
+		vmRefs, err := getVDIVMs(r.session, vdiRef)
+		if err != nil {
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
+				"Unable to get the VMs of the VDI",
+				err,
+			))
+			return
+		}
+		for _, vmRef := range vmRefs {
+			unlock, err := lockObject(ctx, string(vmRef))
+			if err != nil {
+				resp.Diagnostics.Append(xapiErrorDiagnostic(
+					"Unable to lock VM",
+					err,
+				))
+				return
+			}
+			defer unlock()
+		}
+		vdiRef, err = migrateVDI(ctx, r.session, vdiRef, srRef)
+		if err != nil {
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
+				"Unable to move VDI to the SR",
+				err,
+				"sr_uuid",
+			))
+			return
+		}
+	}
+	if plan.VirtualSize.ValueInt64() > state.VirtualSize.ValueInt64() {
+		err = resizeVDI(ctx, r.session, vdiRef, plan.VirtualSize.ValueInt64())
+		if err != nil {
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
+				"Unable to resize VDI",
+				err,
+				"virtual_size",
+			))
+			return
+		}
+	}
+	err = vdiResourceModelUpdate(ctx, r.session, vdiRef, plan.vdiResourceModel)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update VDI resource",
-			err.Error(),
-		)
+			err,
+			"virtual_size",
+		))
 		return
 	}
 	vdiRecord, err := xenapi.VDI.GetRecord(r.session, vdiRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VDI record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
-	err = updateVDIResourceModelComputed(ctx, vdiRecord, &plan)
+	err = updateVDIResourceModelComputed(ctx, vdiRecord, &plan.vdiResourceModel)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update the computed fields of VDIResourceModel",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, plan.UUID)...)
 }
 
 func (r *vdiResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
-	var data vdiResourceModel
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("delete"))
+		return
+	}
+
+	var data vdiResourceTimeoutsModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
 	vdiRef, err := xenapi.VDI.GetByUUID(r.session, data.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VDI ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 	err = cleanupVDIResource(r.session, vdiRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to delete VDI resource",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 }
 
 func (r *vdiResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("uuid"), req, resp)
+	importStateByName(ctx, req, resp, "VDI", []string{"name", "sr"}, func(selectors map[string]string) ([]string, error) {
+		return findVDIsForImport(r.session, selectors)
+	})
 }
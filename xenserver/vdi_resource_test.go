@@ -55,10 +55,6 @@ func TestAccVDIResource(t *testing.T) {
 				ImportStateVerify:       true,
 				ImportStateVerifyIgnore: []string{},
 			},
-			{
-				Config:      providerConfig + testAccVDIResourceConfig("Test VDI 2", "Test VDI description", "2 * 1024 * 1024 * 1024", ""),
-				ExpectError: regexp.MustCompile(`"virtual_size" doesn't expected to be updated`),
-			},
 			{
 				Config:      providerConfig + testAccVDIResourceConfig("Test VDI 2", "Test VDI description", "1 * 1024 * 1024 * 1024", `type = "dummy"`),
 				ExpectError: regexp.MustCompile(`"type" doesn't expected to be updated`),
@@ -73,17 +69,21 @@ func TestAccVDIResource(t *testing.T) {
 			},
 			// Update and Read testing
 			{
-				Config: providerConfig + testAccVDIResourceConfig("Test VDI 2", "Test VDI description", "1 * 1024 * 1024 * 1024", ""),
+				Config: providerConfig + testAccVDIResourceConfig("Test VDI 2", "Test VDI description", "2 * 1024 * 1024 * 1024", ""),
 				Check: resource.ComposeAggregateTestCheckFunc(
 					resource.TestCheckResourceAttr("xenserver_vdi.test_vdi", "name_label", "Test VDI 2"),
 					resource.TestCheckResourceAttr("xenserver_vdi.test_vdi", "name_description", "Test VDI description"),
-					resource.TestCheckResourceAttr("xenserver_vdi.test_vdi", "virtual_size", "1073741824"),
+					resource.TestCheckResourceAttr("xenserver_vdi.test_vdi", "virtual_size", "2147483648"),
 					resource.TestCheckResourceAttr("xenserver_vdi.test_vdi", "other_config.%", "1"),
 					resource.TestCheckResourceAttr("xenserver_vdi.test_vdi", "other_config.flag", "1"),
 					// Verify dynamic values have any value set in the state.
 					resource.TestCheckResourceAttrSet("xenserver_vdi.test_vdi", "uuid"),
 				),
 			},
+			{
+				Config:      providerConfig + testAccVDIResourceConfig("Test VDI 2", "Test VDI description", "1 * 1024 * 1024 * 1024", ""),
+				ExpectError: regexp.MustCompile(`virtual_size can't be decreased`),
+			},
 			// Delete testing automatically occurs in TestCase
 		},
 	})
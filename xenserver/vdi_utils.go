@@ -3,9 +3,14 @@ package xenserver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/mapdefault"
@@ -13,6 +18,7 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 
 	"xenapi"
 )
@@ -30,6 +36,14 @@ type vdiResourceModel struct {
 	ID              types.String `tfsdk:"id"`
 }
 
+// vdiResourceTimeoutsModel is the data model of the VDI resource, the VDI
+// attributes are shared with the revert_vdis of snapshots, which have no
+// timeouts.
+type vdiResourceTimeoutsModel struct {
+	vdiResourceModel
+	Timeouts timeouts.Value `tfsdk:"timeouts"`
+}
+
 var vdiResourceModelAttrTypes = map[string]attr.Type{
 	"name_label":       types.StringType,
 	"name_description": types.StringType,
@@ -57,12 +71,14 @@ func vdiSchema() map[string]schema.Attribute {
 		},
 		"sr_uuid": schema.StringAttribute{
 			MarkdownDescription: "The UUID of the storage repository used." +
-				"\n\n-> **Note:** `sr_uuid` is not allowed to be updated.",
+				"\n\n-> **Note:** Updating `sr_uuid` moves the virtual disk image to the new storage repository, live when it is attached to a running VM, " +
+				"otherwise it is copied and the VBDs of its VMs are recreated on the copy. " +
+				"The moved virtual disk image gets a new UUID, so the `vdi_uuid` in the `hard_drive` of a VM should refer to the `uuid` of this resource rather than a fixed value.",
 			Required: true,
 		},
 		"virtual_size": schema.Int64Attribute{
 			MarkdownDescription: "The size of virtual disk image (in bytes)." +
-				"\n\n-> **Note:** `virtual_size` is not allowed to be updated.",
+				"\n\n-> **Note:** `virtual_size` can only be increased, the virtual disk image is resized online when it is attached to a running VM.",
 			Required: true,
 		},
 		"type": schema.StringAttribute{
@@ -116,7 +132,7 @@ func getVDICreateParams(ctx context.Context, session *xenapi.Session, data vdiRe
 	record.NameDescription = data.NameDescription.ValueString()
 	srRef, err := xenapi.SR.GetByUUID(session, data.SR.ValueString())
 	if err != nil {
-		return record, errors.New(err.Error())
+		return record, newXAPIError(err)
 	}
 	record.SR = srRef
 	record.VirtualSize = int(data.VirtualSize.ValueInt64())
@@ -161,11 +177,8 @@ func updateVDIResourceModelComputed(ctx context.Context, record xenapi.VDIRecord
 }
 
 func vdiResourceModelUpdateCheck(data vdiResourceModel, dataState vdiResourceModel) error {
-	if data.SR != dataState.SR {
-		return errors.New(`"sr_uuid" doesn't expected to be updated`)
-	}
-	if data.VirtualSize != dataState.VirtualSize {
-		return errors.New(`"virtual_size" doesn't expected to be updated`)
+	if data.VirtualSize.ValueInt64() < dataState.VirtualSize.ValueInt64() {
+		return errors.New(`"virtual_size" doesn't expected to be decreased`)
 	}
 	if data.Type != dataState.Type {
 		return errors.New(`"type" doesn't expected to be updated`)
@@ -182,11 +195,11 @@ func vdiResourceModelUpdateCheck(data vdiResourceModel, dataState vdiResourceMod
 func vdiResourceModelUpdate(ctx context.Context, session *xenapi.Session, ref xenapi.VDIRef, data vdiResourceModel) error {
 	err := xenapi.VDI.SetNameLabel(session, ref, data.NameLabel.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	err = xenapi.VDI.SetNameDescription(session, ref, data.NameDescription.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	otherConfig := make(map[string]string)
 	diags := data.OtherConfig.ElementsAs(ctx, &otherConfig, false)
@@ -195,15 +208,257 @@ func vdiResourceModelUpdate(ctx context.Context, session *xenapi.Session, ref xe
 	}
 	err = xenapi.VDI.SetOtherConfig(session, ref, otherConfig)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
+	}
+	return nil
+}
+
+// migrateVDI moves the VDI to the SR and returns the reference of the moved
+// VDI. A VDI attached to a running VM is migrated live, otherwise it is copied
+// to the SR, the VBDs are recreated on the copy and the VDI is destroyed. The
+// VBDs are restored on the VDI and the copy is destroyed when a VBD can't be
+// recreated. The VMs of the VDI must be locked by the caller.
+func migrateVDI(ctx context.Context, session *xenapi.Session, vdiRef xenapi.VDIRef, srRef xenapi.SRRef) (xenapi.VDIRef, error) {
+	vbdRefs, err := xenapi.VDI.GetVBDs(session, vdiRef)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	vbdRecords := make(map[xenapi.VBDRef]xenapi.VBDRecord, len(vbdRefs))
+	live := false
+	for _, vbdRef := range vbdRefs {
+		vbdRecord, err := xenapi.VBD.GetRecord(session, vbdRef)
+		if err != nil {
+			return "", newXAPIError(err)
+		}
+		vbdRecords[vbdRef] = vbdRecord
+		live = live || vbdRecord.CurrentlyAttached
+	}
+
+	if live {
+		tflog.Debug(ctx, "-----> Migrate the VDI live to the SR "+string(srRef))
+		taskRef, err := xenapi.VDI.AsyncPoolMigrate(session, vdiRef, srRef, map[string]string{})
+		if err != nil {
+			return "", newXAPIError(err)
+		}
+		result, err := waitForTask(ctx, session, taskRef)
+		if err != nil {
+			return "", fmt.Errorf("unable to migrate VDI. %w", err)
+		}
+		return xenapi.VDIRef(result), nil
+	}
+
+	tflog.Debug(ctx, "-----> Copy the VDI to the SR "+string(srRef))
+	taskRef, err := xenapi.VDI.AsyncCopy(session, vdiRef, srRef)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	result, err := waitForTask(ctx, session, taskRef)
+	if err != nil {
+		return "", fmt.Errorf("unable to copy VDI. %w", err)
+	}
+	copyRef := xenapi.VDIRef(result)
+	movedVBDs := make(map[xenapi.VBDRef]xenapi.VBDRecord, len(vbdRecords))
+	for vbdRef, vbdRecord := range vbdRecords {
+		movedRef, err := moveVBD(session, vbdRef, vbdRecord, copyRef)
+		if err != nil {
+			return "", undoCopyVDI(session, vdiRef, copyRef, movedVBDs, err)
+		}
+		vbdRecord.VDI = copyRef
+		movedVBDs[movedRef] = vbdRecord
+	}
+	err = xenapi.VDI.Destroy(session, vdiRef)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	return copyRef, nil
+}
+
+// resizeVDI increases the size of the VDI, online when it is attached to a
+// running VM.
+func resizeVDI(ctx context.Context, session *xenapi.Session, vdiRef xenapi.VDIRef, size int64) error {
+	vbdRefs, err := xenapi.VDI.GetVBDs(session, vdiRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	online := false
+	for _, vbdRef := range vbdRefs {
+		vbdRecord, err := xenapi.VBD.GetRecord(session, vbdRef)
+		if err != nil {
+			return newXAPIError(err)
+		}
+		powerState, err := xenapi.VM.GetPowerState(session, vbdRecord.VM)
+		if err != nil {
+			return newXAPIError(err)
+		}
+		online = online || (vbdRecord.CurrentlyAttached && powerState == xenapi.VMPowerStateRunning)
+	}
+
+	if online {
+		tflog.Debug(ctx, "-----> Resize the VDI online to "+strconv.FormatInt(size, 10))
+		err = xenapi.VDI.ResizeOnline(session, vdiRef, int(size))
+	} else {
+		tflog.Debug(ctx, "-----> Resize the VDI to "+strconv.FormatInt(size, 10))
+		err = xenapi.VDI.Resize(session, vdiRef, int(size))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to resize VDI. %w", err)
 	}
 	return nil
 }
 
+// getVDIVMs returns the sorted references of the VMs the VDI is attached to.
+func getVDIVMs(session *xenapi.Session, vdiRef xenapi.VDIRef) ([]xenapi.VMRef, error) {
+	vbdRefs, err := xenapi.VDI.GetVBDs(session, vdiRef)
+	if err != nil {
+		return nil, newXAPIError(err)
+	}
+	var vmRefs []xenapi.VMRef
+	for _, vbdRef := range vbdRefs {
+		vmRef, err := xenapi.VBD.GetVM(session, vbdRef)
+		if err != nil {
+			return nil, newXAPIError(err)
+		}
+		if !slices.Contains(vmRefs, vmRef) {
+			vmRefs = append(vmRefs, vmRef)
+		}
+	}
+	slices.Sort(vmRefs)
+	return vmRefs, nil
+}
+
+// moveVBD replaces the VBD by a VBD of the same record on the VDI and returns
+// its reference, the VBD is restored when the new one can't be created. The VM
+// of the VBD must be locked by the caller.
+func moveVBD(session *xenapi.Session, vbdRef xenapi.VBDRef, vbdRecord xenapi.VBDRecord, vdiRef xenapi.VDIRef) (xenapi.VBDRef, error) {
+	err := xenapi.VBD.Destroy(session, vbdRef)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	movedRecord := vbdRecord
+	movedRecord.VDI = vdiRef
+	movedRef, err := xenapi.VBD.Create(session, movedRecord)
+	if err != nil {
+		err = newXAPIError(err)
+		_, restoreErr := xenapi.VBD.Create(session, vbdRecord)
+		if restoreErr != nil {
+			return "", fmt.Errorf("%w, unable to restore the VBD of the VM %s. %w", err, vbdRecord.VM, newXAPIError(restoreErr))
+		}
+		return "", err
+	}
+	return movedRef, nil
+}
+
+// undoCopyVDI moves the VBDs back from the copy to the VDI and destroys the
+// copy, after the error err of the migration.
+func undoCopyVDI(session *xenapi.Session, vdiRef xenapi.VDIRef, copyRef xenapi.VDIRef, movedVBDs map[xenapi.VBDRef]xenapi.VBDRecord, err error) error {
+	for movedRef, movedRecord := range movedVBDs {
+		_, undoErr := moveVBD(session, movedRef, movedRecord, vdiRef)
+		if undoErr != nil {
+			return fmt.Errorf("%w, unable to restore the VBD of the VM %s. %w", err, movedRecord.VM, undoErr)
+		}
+	}
+	undoErr := xenapi.VDI.Destroy(session, copyRef)
+	if undoErr != nil {
+		return fmt.Errorf("%w, unable to destroy the copy of the VDI. %w", err, newXAPIError(undoErr))
+	}
+	return err
+}
+
 func cleanupVDIResource(session *xenapi.Session, ref xenapi.VDIRef) error {
 	err := xenapi.VDI.Destroy(session, ref)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
+	}
+	return nil
+}
+
+// checkVDIAvailable checks that the VDI exists and isn't used by another VM
+// than the given one, unless it is sharable.
+func checkVDIAvailable(session *xenapi.Session, vdiUUID string, vmUUID string) error {
+	vdiRef, err := xenapi.VDI.GetByUUID(session, vdiUUID)
+	if err != nil {
+		return fmt.Errorf("unable to find the VDI %s. %w", vdiUUID, err)
+	}
+	vdiRecord, err := xenapi.VDI.GetRecord(session, vdiRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	if vdiRecord.Sharable {
+		return nil
+	}
+	for _, vbdRef := range vdiRecord.VBDs {
+		vmRef, err := xenapi.VBD.GetVM(session, vbdRef)
+		if err != nil {
+			return newXAPIError(err)
+		}
+		uuid, err := xenapi.VM.GetUUID(session, vmRef)
+		if err != nil {
+			return newXAPIError(err)
+		}
+		if uuid != vmUUID {
+			return errors.New("the VDI " + vdiUUID + " is already in use by the VM " + uuid)
+		}
 	}
 	return nil
 }
+
+// vdiResourceModelPlanCheck checks that the VDI can be created on, or moved
+// to, the SR of the plan, and that it isn't shrunk. The state is nil when the
+// VDI is going to be created.
+func vdiResourceModelPlanCheck(session *xenapi.Session, plan vdiResourceModel, state *vdiResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if state != nil && !plan.VirtualSize.IsUnknown() && plan.VirtualSize.ValueInt64() < state.VirtualSize.ValueInt64() {
+		diags.AddAttributeError(path.Root("virtual_size"), "Invalid virtual size",
+			"virtual_size can't be decreased, the virtual disk image can only be grown.")
+	}
+	if plan.SR.IsUnknown() || (state != nil && plan.SR.Equal(state.SR)) {
+		return diags
+	}
+
+	srRef, err := xenapi.SR.GetByUUID(session, plan.SR.ValueString())
+	if err != nil {
+		diags.AddAttributeError(path.Root("sr_uuid"), "Invalid SR", "unable to find the SR "+plan.SR.ValueString()+". "+err.Error())
+		return diags
+	}
+	srRecord, err := xenapi.SR.GetRecord(session, srRef)
+	if err != nil {
+		diags.AddAttributeError(path.Root("sr_uuid"), "Invalid SR", err.Error())
+		return diags
+	}
+	if !slices.Contains(srRecord.AllowedOperations, xenapi.StorageOperationsVdiCreate) {
+		diags.AddAttributeError(path.Root("sr_uuid"), "Invalid SR", "the SR "+srRecord.NameLabel+" doesn't allow creating VDIs")
+	}
+	if !plan.VirtualSize.IsUnknown() && srRecord.PhysicalSize > 0 && plan.VirtualSize.ValueInt64() > int64(srRecord.PhysicalSize) {
+		diags.AddAttributeWarning(path.Root("virtual_size"), "Virtual size exceeds the SR size",
+			"virtual_size is greater than the physical size of the SR "+srRecord.NameLabel+", the VDI can only be created on a thin provisioned SR.")
+	}
+
+	return diags
+}
+
+// findVDIsForImport returns the UUIDs of the VDIs with the name of the
+// selectors, on the SR with the UUID of the sr selector if set. The snapshots
+// of the VDIs are excluded.
+func findVDIsForImport(session *xenapi.Session, selectors map[string]string) ([]string, error) {
+	var srRef xenapi.SRRef
+	if srUUID, ok := selectors["sr"]; ok {
+		var err error
+		srRef, err = xenapi.SR.GetByUUID(session, srUUID)
+		if err != nil {
+			return nil, newXAPIError(err)
+		}
+	}
+	vdiRecords, err := xenapi.VDI.GetAllRecords(session)
+	if err != nil {
+		return nil, newXAPIError(err)
+	}
+	var uuids []string
+	for _, vdiRecord := range vdiRecords {
+		if vdiRecord.IsASnapshot || vdiRecord.NameLabel != selectors["name"] || (srRef != "" && vdiRecord.SR != srRef) {
+			continue
+		}
+		uuids = append(uuids, vdiRecord.UUID)
+	}
+
+	return uuids, nil
+}
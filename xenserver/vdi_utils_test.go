@@ -0,0 +1,193 @@
+package xenserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"xenapi"
+)
+
+// hardDriveSet returns the hard_drive set of the VBD on the VDI.
+func hardDriveSet(vdiUUID string, vbdRef xenapi.VBDRef) types.Set {
+	return types.SetValueMust(types.ObjectType{AttrTypes: vbdResourceModelAttrTypes}, []attr.Value{
+		types.ObjectValueMust(vbdResourceModelAttrTypes, map[string]attr.Value{
+			"vdi_uuid": types.StringValue(vdiUUID),
+			"vbd_ref":  types.StringValue(string(vbdRef)),
+			"mode":     types.StringValue("RW"),
+			"bootable": types.BoolValue(false),
+			"size":     types.Int64Null(),
+			"sr_uuid":  types.StringNull(),
+		}),
+	})
+}
+
+// assertMovedVBD checks that the VBD has the record of the moved VBD on the
+// VDI.
+func assertMovedVBD(t *testing.T, session *xenapi.Session, vbdRef xenapi.VBDRef, movedRecord xenapi.VBDRecord, vdiRef xenapi.VDIRef) {
+	t.Helper()
+	vbdRecord, err := xenapi.VBD.GetRecord(session, vbdRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	movedRecord.UUID = vbdRecord.UUID
+	movedRecord.VDI = vdiRef
+	if !reflect.DeepEqual(vbdRecord, movedRecord) {
+		t.Fatalf("expected the VBD %+v, got %+v", movedRecord, vbdRecord)
+	}
+}
+
+func TestMigrateVDI(t *testing.T) {
+	fake := newFakeXAPI("root", "password")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	fake.mu.Lock()
+	sr := fake.createSR(fake.ref("host"), map[string]any{"device": "/dev/sdb"}, 536870912000, "Second storage", "", "ext", "user", false)
+	fake.set(sr, "allowed_operations", []any{"vdi_create"})
+	fake.mu.Unlock()
+	srUUID, err := xenapi.SR.GetUUID(session, xenapi.SRRef(sr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templateRef, err := getFirstTemplate(session, "Debian Bullseye 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.SetIsATemplate(session, vmRef, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localSR, err := xenapi.Pool.GetDefaultSR(session, mustGetPool(t, session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRef, err := xenapi.VDI.Create(session, xenapi.VDIRecord{NameLabel: "disk", SR: localSR, VirtualSize: 1073741824, Type: xenapi.VdiTypeUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbdRef, err := xenapi.VBD.Create(session, xenapi.VBDRecord{
+		VM: vmRef, VDI: vdiRef, Type: xenapi.VbdTypeDisk, Mode: xenapi.VbdModeRW, Userdevice: "0", Unpluggable: true,
+		OtherConfig: map[string]string{"owner": "true"}, QosAlgorithmType: "ionice", QosAlgorithmParams: map[string]string{"sched": "idle"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiUUID, err := xenapi.VDI.GetUUID(session, vdiRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := vdiResourceModel{SR: types.StringValue("local"), VirtualSize: types.Int64Unknown()}
+	plan := state
+	plan.SR = types.StringValue(srUUID)
+	diags := vdiResourceModelPlanCheck(session, plan, &state)
+	if diags.HasError() {
+		t.Fatalf("expected no error, got: %v", diags)
+	}
+
+	vmRefs, err := getVDIVMs(session, vdiRef)
+	if err != nil || len(vmRefs) != 1 || vmRefs[0] != vmRef {
+		t.Fatalf("expected the VM %s of the VDI, got %v: %v", vmRef, vmRefs, err)
+	}
+
+	// the VBD is restored on the VDI and the copy destroyed when the VBD
+	// can't be recreated on the copy
+	vbdRecord, err := xenapi.VBD.GetRecord(session, vbdRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockObject(ctx, string(vmRef))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	fault := fakeError("INTERNAL_ERROR", "VBD.create")
+	fault.once = true
+	fake.faults["vbd.create"] = fault
+	fake.mu.Unlock()
+	_, err = migrateVDI(ctx, session, vdiRef, xenapi.SRRef(sr))
+	unlock()
+	if err == nil {
+		t.Fatal("expected the failure of the VBD creation")
+	}
+	vdiRecord, err := xenapi.VDI.GetRecord(session, vdiRef)
+	if err != nil || vdiRecord.SR != localSR || len(vdiRecord.VBDs) != 1 {
+		t.Fatalf("expected the VDI on %s with a VBD, got %+v: %v", localSR, vdiRecord, err)
+	}
+	srVDIs, err := xenapi.SR.GetVDIs(session, xenapi.SRRef(sr))
+	if err != nil || len(srVDIs) != 0 {
+		t.Fatalf("expected the copy of the VDI to be destroyed, got %v: %v", srVDIs, err)
+	}
+	vbdRef = vdiRecord.VBDs[0]
+	assertMovedVBD(t, session, vbdRef, vbdRecord, vdiRef)
+
+	// the disk of the halted VM is copied and its VBD recreated, the VM is
+	// locked by the caller
+	unlock, err = lockObject(ctx, string(vmRef))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRef, err = migrateVDI(ctx, session, vdiRef, xenapi.SRRef(sr))
+	unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRecord, err = xenapi.VDI.GetRecord(session, vdiRef)
+	if err != nil || string(vdiRecord.SR) != sr || len(vdiRecord.VBDs) != 1 || vdiRecord.UUID == vdiUUID {
+		t.Fatalf("expected a new VDI on %s with a VBD, got %+v: %v", sr, vdiRecord, err)
+	}
+	assertMovedVBD(t, session, vdiRecord.VBDs[0], vbdRecord, vdiRef)
+	if _, err = xenapi.VBD.GetRecord(session, vbdRef); err == nil {
+		t.Fatal("expected the VBD of the copied VDI to be destroyed")
+	}
+	if _, err = xenapi.VDI.GetByUUID(session, vdiUUID); err == nil {
+		t.Fatal("expected the copied VDI to be destroyed")
+	}
+
+	// the disk attached to the running VM is migrated live, the VM keeps its
+	// hard drive
+	vbdRef = vdiRecord.VBDs[0]
+	vdiUUID = vdiRecord.UUID
+	err = xenapi.VM.Start(session, vmRef, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VBD.Plug(session, vbdRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRef, err = migrateVDI(ctx, session, vdiRef, localSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRecord, err = xenapi.VDI.GetRecord(session, vdiRef)
+	if err != nil || vdiRecord.SR != localSR || len(vdiRecord.VBDs) != 1 || vdiRecord.VBDs[0] != vbdRef {
+		t.Fatalf("expected the VBD %s on a VDI of %s, got %+v: %v", vbdRef, localSR, vdiRecord, err)
+	}
+
+	vmState := vmResourceModel{HardDrive: hardDriveSet(vdiUUID, vbdRef)}
+	vmPlan := vmResourceModel{HardDrive: hardDriveSet(vdiRecord.UUID, "")}
+	err = updateVBDs(ctx, vmPlan, vmState, vmRef, session, &vmPrivateState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbdRefs, err := getAllDiskTypeVBDs(session, vmRef)
+	if err != nil || len(vbdRefs) != 1 || vbdRefs[0] != string(vbdRef) {
+		t.Fatalf("expected the VBD %s, got %v: %v", vbdRef, vbdRefs, err)
+	}
+}
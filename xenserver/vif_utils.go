@@ -5,7 +5,6 @@ import (
 	"errors"
 	"regexp"
 	"slices"
-	"strings"
 
 	"xenapi"
 
@@ -95,7 +94,7 @@ func createVIF(ctx context.Context, vif vifResourceModel, vmRef xenapi.VMRef, se
 	var vifRef xenapi.VIFRef
 	networkRef, err := xenapi.Network.GetByUUID(session, vif.Network.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	setVIFDefaults(ctx, &vif)
@@ -120,17 +119,17 @@ func createVIF(ctx context.Context, vif vifResourceModel, vmRef xenapi.VMRef, se
 
 	vifRef, err = xenapi.VIF.Create(session, vifRecord)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	vmPowerState, err := xenapi.VM.GetPowerState(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	if vmPowerState == xenapi.VMPowerStateRunning {
 		if err = xenapi.VIF.Plug(session, vifRef); err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
@@ -147,18 +146,18 @@ func createVIFs(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef
 	// removed existing VIFs in VM template
 	existingVIFs, err := xenapi.VM.GetVIFs(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	for _, vif := range existingVIFs {
 		if err = xenapi.VIF.Destroy(session, vif); err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
 	for _, vif := range elements {
 		if err = createVIF(ctx, vif, vmRef, session); err != nil {
-			return errors.New(err.Error())
+			return err
 		}
 	}
 	return nil
@@ -199,7 +198,7 @@ func updateVIFs(ctx context.Context, plan vmResourceModel, state vmResourceModel
 
 	vmState, err := xenapi.VM.GetPowerState(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	// Destroy VIFs that are not in plan, destroy VIFs first to avoid error "DEVICE_ALREADY_EXISTS"
@@ -209,21 +208,21 @@ func updateVIFs(ctx context.Context, plan vmResourceModel, state vmResourceModel
 			if vmState == xenapi.VMPowerStateRunning {
 				allowedOps, err := xenapi.VIF.GetAllowedOperations(session, vifRef)
 				if err != nil {
-					return errors.New(err.Error())
+					return newXAPIError(err)
 				}
 				if slices.Contains(allowedOps, xenapi.VifOperationsUnplug) {
 					tflog.Debug(ctx, "---> Unplug VIF when VM is running.")
 					err = xenapi.VIF.Unplug(session, vifRef)
 					if err != nil {
-						return errors.New(err.Error())
+						return newXAPIError(err)
 					}
 				}
 			}
 			tflog.Debug(ctx, "---> Destroy VIF:	"+stateVIF.VIF.String())
 			err = xenapi.VIF.Destroy(session, vifRef)
 			if err != nil {
-				if !strings.Contains(err.Error(), "HANDLE_INVALID") {
-					return errors.New(err.Error())
+				if !isXAPIError(err, "HANDLE_INVALID") {
+					return newXAPIError(err)
 				}
 				tflog.Debug(ctx, "HANDLE_INVALID: VIF already been destroyed.")
 			}
@@ -257,7 +256,7 @@ func updateVIFs(ctx context.Context, plan vmResourceModel, state vmResourceModel
 
 				err = xenapi.VIF.SetOtherConfig(session, xenapi.VIFRef(stateVIF.VIF.ValueString()), otherConfig)
 				if err != nil {
-					return errors.New(err.Error())
+					return newXAPIError(err)
 				}
 			}
 		}
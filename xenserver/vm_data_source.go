@@ -480,10 +480,10 @@ func (d *vmDataSource) Read(ctx context.Context, req datasource.ReadRequest, res
 
 	vmRecords, err := xenapi.VM.GetAllRecords(d.session)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to read VM records",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -504,10 +504,10 @@ func (d *vmDataSource) Read(ctx context.Context, req datasource.ReadRequest, res
 		var vmItem vmRecordData
 		err := updateVMRecordData(ctx, d.session, vmRecord, &vmItem)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to update VM data",
-				err.Error(),
-			)
+				err,
+			))
 			return
 		}
 		vmItems = append(vmItems, vmItem)
@@ -7,6 +7,7 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 
 	"xenapi"
@@ -16,6 +17,8 @@ var (
 	_ resource.Resource                = &vmResource{}
 	_ resource.ResourceWithConfigure   = &vmResource{}
 	_ resource.ResourceWithImportState = &vmResource{}
+	_ resource.ResourceWithIdentity    = &vmResource{}
+	_ resource.ResourceWithModifyPlan  = &vmResource{}
 )
 
 func NewVMResource() resource.Resource {
@@ -30,13 +33,20 @@ func (r *vmResource) Metadata(_ context.Context, req resource.MetadataRequest, r
 	resp.TypeName = req.ProviderTypeName + "_vm"
 }
 
-func (r *vmResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *vmResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "Provides a virtual machine resource.",
 		Attributes:          vmSchema(),
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
+func (r *vmResource) IdentitySchema(_ context.Context, _ resource.IdentitySchemaRequest, resp *resource.IdentitySchemaResponse) {
+	resp.IdentitySchema = uuidIdentitySchema("virtual machine")
+}
+
 func (r *vmResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
 	if req.ProviderData == nil {
 		return
@@ -52,7 +62,47 @@ func (r *vmResource) Configure(_ context.Context, req resource.ConfigureRequest,
 	r.session = providerData.session
 }
 
+// ModifyPlan plans the power state to reach and checks the plan against the
+// pool, so that the invalid references are reported by terraform plan.
+func (r *vmResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// nothing to check when the resource is destroyed
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+	var plan vmResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	var state *vmResourceModel
+	if !req.State.Raw.IsNull() {
+		state = &vmResourceModel{}
+		resp.Diagnostics.Append(req.State.Get(ctx, state)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// power_state keeps the value of the state when it isn't set, but the VM is
+	// started when check_ip_timeout is set
+	var powerState types.String
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("power_state"), &powerState)...)
+	if powerState.IsNull() && !plan.CheckIPTimeout.IsUnknown() && plan.CheckIPTimeout.ValueInt64() > 0 {
+		plan.PowerState = types.StringValue("running")
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("power_state"), plan.PowerState)...)
+	}
+
+	// nothing to check against the pool when the provider isn't configured yet
+	if resp.Diagnostics.HasError() || r.session == nil {
+		return
+	}
+
+	resp.Diagnostics.Append(vmResourceModelPlanCheck(ctx, r.session, plan, state)...)
+}
+
 func (r *vmResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("create"))
+		return
+	}
+
 	tflog.Debug(ctx, "---> Create VM resource")
 	var plan vmResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
@@ -60,13 +110,21 @@ func (r *vmResource) Create(ctx context.Context, req resource.CreateRequest, res
 		return
 	}
 
+	createTimeout, diags := plan.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	// create new resource
 	templateRef, err := getFirstTemplate(r.session, plan.TemplateName.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get template Ref",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
@@ -74,46 +132,69 @@ func (r *vmResource) Create(ctx context.Context, req resource.CreateRequest, res
 	if !plan.SRForFullDiskCopy.IsUnknown() && plan.SRForFullDiskCopy.ValueString() != "" {
 		srRef, err := checkIfSupportFullCopy(r.session, templateRef, plan.SRForFullDiskCopy.ValueString())
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Use storage-level full disk copy but get error",
-				err.Error(),
-			)
+				err,
+				"sr_for_full_disk_copy",
+			))
 			return
 		}
 		tflog.Debug(ctx, "----> Copy VM from a template")
-		vmRef, err = xenapi.VM.Copy(r.session, templateRef, plan.NameLabel.ValueString(), srRef)
+		vmRef, err = copyVM(ctx, r.session, templateRef, plan.NameLabel.ValueString(), srRef)
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to copy VM from template",
-				err.Error(),
-			)
+				err,
+				"template_name",
+				"sr_for_full_disk_copy",
+			))
 			return
 		}
 	} else {
 		tflog.Debug(ctx, "----> Clone VM from a template")
-		vmRef, err = xenapi.VM.Clone(r.session, templateRef, plan.NameLabel.ValueString())
+		vmRef, err = cloneVM(ctx, r.session, templateRef, plan.NameLabel.ValueString())
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to clone VM from template",
-				err.Error(),
-			)
+				err,
+				"template_name",
+			))
 			return
 		}
 	}
 
-	err = setVMResourceModel(ctx, r.session, vmRef, plan)
+	unlock, err := lockObject(ctx, string(vmRef))
 	if err != nil {
-		resp.Diagnostics.AddError(
-			"Unable to set VM resource model",
-			err.Error(),
-		)
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock VM",
+			err,
+		))
+		return
+	}
+	defer unlock()
 
-		err = cleanupVMResource(r.session, vmRef)
+	var vmPrivate vmPrivateState
+	err = setVMResourceModel(ctx, r.session, vmRef, plan, &vmPrivate)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to set VM resource model",
+			err,
+			"hard_drive",
+			"network_interface",
+			"static_mem_max",
+			"dynamic_mem_min",
+			"power_state",
+			"resident_host",
+			"affinity_host",
+			"check_ip_timeout",
+		))
+
+		err = cleanupVMResource(r.session, vmRef, vmPrivate.ownedVBDs())
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to destroy VM",
-				err.Error(),
-			)
+				err,
+			))
 		}
 
 		return
@@ -122,41 +203,53 @@ func (r *vmResource) Create(ctx context.Context, req resource.CreateRequest, res
 	// Overwrite data with refreshed resource state
 	vmRecord, err := xenapi.VM.GetRecord(r.session, vmRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VM record",
-			err.Error(),
-		)
+			err,
+		))
 
-		err = cleanupVMResource(r.session, vmRef)
+		err = cleanupVMResource(r.session, vmRef, vmPrivate.ownedVBDs())
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to destroy VM",
-				err.Error(),
-			)
+				err,
+			))
 		}
 		return
 	}
 
-	err = updateVMResourceModelComputed(ctx, r.session, vmRecord, &plan)
+	err = updateVMResourceModelComputed(ctx, r.session, vmRecord, vmPrivate, &plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update VM resource model state",
-			err.Error(),
-		)
+			err,
+		))
 
-		err = cleanupVMResource(r.session, vmRef)
+		err = cleanupVMResource(r.session, vmRef, vmPrivate.ownedVBDs())
 		if err != nil {
-			resp.Diagnostics.AddError(
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
 				"Unable to destroy VM",
-				err.Error(),
-			)
+				err,
+			))
 		}
 
 		return
 	}
 
+	resp.Diagnostics.Append(removeConfigDriveAfterBoot(ctx, r.session, plan, &vmPrivate)...)
+
+	err = setVMPrivateState(ctx, resp.Private, vmPrivate)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to set VM private state",
+			err,
+		))
+		return
+	}
+
 	// Save plan into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, plan.UUID)...)
 }
 
 func (r *vmResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
@@ -168,39 +261,80 @@ func (r *vmResource) Read(ctx context.Context, req resource.ReadRequest, resp *r
 		return
 	}
 
+	readTimeout, diags := state.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	// Overwrite state with refreshed resource state
 	vmRef, err := xenapi.VM.GetByUUID(r.session, state.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VM ref",
-			err.Error(),
-		)
+			err,
+		))
+		return
+	}
+
+	vmPrivate, err := getVMPrivateState(ctx, req.Private, r.session, vmRef, &state)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to get VM private state",
+			err,
+		))
 		return
 	}
 
 	vmRecord, err := xenapi.VM.GetRecord(r.session, vmRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VM record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
-	err = updateVMResourceModel(ctx, r.session, vmRecord, &state)
+	err = updateVMResourceModel(ctx, r.session, vmRecord, vmPrivate, &state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update VM resource model state",
-			err.Error(),
-		)
+			err,
+		))
+		return
+	}
+
+	err = setVMPrivateState(ctx, resp.Private, vmPrivate)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to set VM private state",
+			err,
+		))
+		return
+	}
+
+	err = removeLegacyVMOtherConfig(ctx, r.session, vmRef)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to remove the legacy VM other config",
+			err,
+		))
 		return
 	}
 
 	// Save updated state into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, state.UUID)...)
 }
 
 func (r *vmResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("update"))
+		return
+	}
+
 	tflog.Debug(ctx, "---> Update VM resource")
 	var plan, state vmResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
@@ -209,58 +343,111 @@ func (r *vmResource) Update(ctx context.Context, req resource.UpdateRequest, res
 		return
 	}
 
+	updateTimeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	err := vmResourceModelUpdateCheck(plan, state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Error update xenserver_vm configuration",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
 	// Get existing vm record
 	vmRef, err := xenapi.VM.GetByUUID(r.session, plan.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VM ref",
-			err.Error(),
-		)
+			err,
+		))
+		return
+	}
+	// the snapshots of the VM wait for the update
+	unlock, err := lockObject(ctx, string(vmRef))
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock VM",
+			err,
+		))
 		return
 	}
+	defer unlock()
 
-	err = vmResourceModelUpdate(ctx, r.session, vmRef, plan, state)
+	vmPrivate, err := getVMPrivateState(ctx, req.Private, r.session, vmRef, &state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to get VM private state",
+			err,
+		))
+		return
+	}
+
+	err = vmResourceModelUpdate(ctx, r.session, vmRef, plan, state, &vmPrivate)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update VM",
-			err.Error(),
-		)
+			err,
+			"hard_drive",
+			"network_interface",
+			"static_mem_max",
+			"dynamic_mem_min",
+			"power_state",
+			"resident_host",
+			"affinity_host",
+			"check_ip_timeout",
+		))
 		return
 	}
 
 	// Overwrite computed data with refreshed resource state
 	vmRecord, err := xenapi.VM.GetRecord(r.session, vmRef)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VM record",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 
-	err = updateVMResourceModelComputed(ctx, r.session, vmRecord, &plan)
+	err = updateVMResourceModelComputed(ctx, r.session, vmRecord, vmPrivate, &plan)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to update VM resource model state",
-			err.Error(),
-		)
+			err,
+		))
+		return
+	}
+
+	// the drive is still attached when its removal after the creation failed
+	resp.Diagnostics.Append(removeConfigDriveAfterBoot(ctx, r.session, plan, &vmPrivate)...)
+
+	err = setVMPrivateState(ctx, resp.Private, vmPrivate)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to set VM private state",
+			err,
+		))
 		return
 	}
 
 	// Save updated plan into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	resp.Diagnostics.Append(setUUIDIdentity(ctx, resp.Identity, plan.UUID)...)
 }
 
 func (r *vmResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	if isReadOnlySession(r.session) {
+		resp.Diagnostics.Append(readOnlyDiagnostic("delete"))
+		return
+	}
+
 	tflog.Debug(ctx, "---> Delete VM resource")
 	var state vmResourceModel
 	// Read Terraform prior state state into the model
@@ -269,26 +456,76 @@ func (r *vmResource) Delete(ctx context.Context, req resource.DeleteRequest, res
 		return
 	}
 
+	deleteTimeout, diags := state.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
 	// delete resource
 	vmRef, err := xenapi.VM.GetByUUID(r.session, state.UUID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to get VM ref",
-			err.Error(),
-		)
+			err,
+		))
+		return
+	}
+	unlock, err := lockObject(ctx, string(vmRef))
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to lock VM",
+			err,
+		))
 		return
 	}
+	defer unlock()
 
-	err = cleanupVMResource(r.session, vmRef)
+	vmPrivate, err := getVMPrivateState(ctx, req.Private, r.session, vmRef, &state)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to get VM private state",
+			err,
+		))
+		return
+	}
+
+	// shut down the VM cleanly before it is destroyed
+	powerState, err := xenapi.VM.GetPowerState(r.session, vmRef)
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
+			"Unable to get VM power state",
+			err,
+		))
+		return
+	}
+	if powerState == xenapi.VMPowerStateRunning {
+		err = shutdownVM(ctx, r.session, vmRef, state)
+		if err != nil {
+			resp.Diagnostics.Append(xapiErrorDiagnostic(
+				"Unable to shut down VM",
+				err,
+				"hard_shutdown_fallback",
+				"clean_shutdown_timeout",
+			))
+			return
+		}
+	}
+
+	err = cleanupVMResource(r.session, vmRef, vmPrivate.ownedVBDs())
+	if err != nil {
+		resp.Diagnostics.Append(xapiErrorDiagnostic(
 			"Unable to destroy VM",
-			err.Error(),
-		)
+			err,
+		))
 		return
 	}
 }
 
 func (r *vmResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("uuid"), req, resp)
+	importStateByName(ctx, req, resp, "VM", []string{"name"}, func(selectors map[string]string) ([]string, error) {
+		return findVMsForImport(r.session, selectors)
+	})
 }
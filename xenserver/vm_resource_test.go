@@ -6,6 +6,9 @@ import (
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/statecheck"
+	"github.com/hashicorp/terraform-plugin-testing/tfjsonpath"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
 )
 
 func testAccVMResourceConfig(name_label string, template string, memory int, vcpu int, cores_per_socket int, boot_mode string, boot_order string, bootable string, mode string, mac string, device string) string {
@@ -162,6 +165,30 @@ func TestAccVMResource(t *testing.T) {
 	})
 }
 
+func TestAccVMResourceIdentity(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		// resource identity is supported since Terraform 1.12
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_12_0),
+		},
+		Steps: []resource.TestStep{
+			{
+				Config: providerConfig + testAccVMResourceConfig("test vm identity", "Windows 11", 4, 2, 2, "uefi", "cdn", "true", "RW", "11:22:33:44:55:66", "0"),
+				ConfigStateChecks: []statecheck.StateCheck{
+					statecheck.ExpectIdentityValueMatchesState("xenserver_vm.test_vm", tfjsonpath.New("uuid")),
+				},
+			},
+			// Import with an import block of the identity
+			{
+				ResourceName:    "xenserver_vm.test_vm",
+				ImportState:     true,
+				ImportStateKind: resource.ImportBlockWithResourceIdentity,
+			},
+		},
+	})
+}
+
 func TestAccLinuxVMResource(t *testing.T) {
 	resource.Test(t, resource.TestCase{
 		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
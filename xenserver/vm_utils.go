@@ -2,6 +2,7 @@ package xenserver
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -12,14 +13,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/setvalidator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
 	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/mapdefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/objectplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
@@ -133,26 +138,33 @@ type vmRecordData struct {
 
 // vmResourceModel describes the resource data model.
 type vmResourceModel struct {
-	NameLabel         types.String `tfsdk:"name_label"`
-	NameDescription   types.String `tfsdk:"name_description"`
-	TemplateName      types.String `tfsdk:"template_name"`
-	StaticMemMin      types.Int64  `tfsdk:"static_mem_min"`
-	StaticMemMax      types.Int64  `tfsdk:"static_mem_max"`
-	DynamicMemMin     types.Int64  `tfsdk:"dynamic_mem_min"`
-	DynamicMemMax     types.Int64  `tfsdk:"dynamic_mem_max"`
-	VCPUs             types.Int32  `tfsdk:"vcpus"`
-	BootMode          types.String `tfsdk:"boot_mode"`
-	BootOrder         types.String `tfsdk:"boot_order"`
-	CorePerSocket     types.Int32  `tfsdk:"cores_per_socket"`
-	OtherConfig       types.Map    `tfsdk:"other_config"`
-	HardDrive         types.Set    `tfsdk:"hard_drive"`
-	SRForFullDiskCopy types.String `tfsdk:"sr_for_full_disk_copy"`
-	NetworkInterface  types.Set    `tfsdk:"network_interface"`
-	CDROM             types.String `tfsdk:"cdrom"`
-	UUID              types.String `tfsdk:"uuid"`
-	ID                types.String `tfsdk:"id"`
-	DefaultIP         types.String `tfsdk:"default_ip"`
-	CheckIPTimeout    types.Int64  `tfsdk:"check_ip_timeout"`
+	NameLabel            types.String   `tfsdk:"name_label"`
+	NameDescription      types.String   `tfsdk:"name_description"`
+	TemplateName         types.String   `tfsdk:"template_name"`
+	StaticMemMin         types.Int64    `tfsdk:"static_mem_min"`
+	StaticMemMax         types.Int64    `tfsdk:"static_mem_max"`
+	DynamicMemMin        types.Int64    `tfsdk:"dynamic_mem_min"`
+	DynamicMemMax        types.Int64    `tfsdk:"dynamic_mem_max"`
+	VCPUs                types.Int32    `tfsdk:"vcpus"`
+	BootMode             types.String   `tfsdk:"boot_mode"`
+	BootOrder            types.String   `tfsdk:"boot_order"`
+	CorePerSocket        types.Int32    `tfsdk:"cores_per_socket"`
+	OtherConfig          types.Map      `tfsdk:"other_config"`
+	HardDrive            types.Set      `tfsdk:"hard_drive"`
+	SRForFullDiskCopy    types.String   `tfsdk:"sr_for_full_disk_copy"`
+	NetworkInterface     types.Set      `tfsdk:"network_interface"`
+	CDROM                types.String   `tfsdk:"cdrom"`
+	UUID                 types.String   `tfsdk:"uuid"`
+	ID                   types.String   `tfsdk:"id"`
+	DefaultIP            types.String   `tfsdk:"default_ip"`
+	CheckIPTimeout       types.Int64    `tfsdk:"check_ip_timeout"`
+	CloudInit            types.Object   `tfsdk:"cloud_init"`
+	PowerState           types.String   `tfsdk:"power_state"`
+	AffinityHost         types.String   `tfsdk:"affinity_host"`
+	ResidentHost         types.String   `tfsdk:"resident_host"`
+	HardShutdownFallback types.Bool     `tfsdk:"hard_shutdown_fallback"`
+	CleanShutdownTimeout types.Int64    `tfsdk:"clean_shutdown_timeout"`
+	Timeouts             timeouts.Value `tfsdk:"timeouts"`
 }
 
 func vmSchema() map[string]schema.Attribute {
@@ -251,11 +263,12 @@ func vmSchema() map[string]schema.Attribute {
 			},
 		},
 		"other_config": schema.MapAttribute{
-			MarkdownDescription: "The additional configuration of the virtual machine, default to be `{}`.",
-			Optional:            true,
-			Computed:            true,
-			ElementType:         types.StringType,
-			Default:             mapdefault.StaticValue(types.MapValueMust(types.StringType, map[string]attr.Value{})),
+			MarkdownDescription: "The additional configuration of the virtual machine, default to be `{}`." +
+				"\n\n-> **Note:** The `tf_*` keys set in the other_config by the previous versions of the provider are moved to the Terraform private state, and removed from the virtual machine by the next refresh.",
+			Optional:    true,
+			Computed:    true,
+			ElementType: types.StringType,
+			Default:     mapdefault.StaticValue(types.MapValueMust(types.StringType, map[string]attr.Value{})),
 		},
 		"check_ip_timeout": schema.Int64Attribute{
 			MarkdownDescription: "The duration for checking the IP address of the virtual machine. default is 0 seconds, once the value greater than 0, the provider will check the IP address of the virtual machine in the specified duration.",
@@ -266,6 +279,53 @@ func vmSchema() map[string]schema.Attribute {
 				int64validator.AtLeast(0),
 			},
 		},
+		"power_state": schema.StringAttribute{
+			MarkdownDescription: "The power state of the virtual machine, which the provider restores on create and update when it changed, default to be started when `check_ip_timeout` is set and kept as it is otherwise." + "<br />" +
+				"This value can be one of [`\"running\", \"halted\", \"paused\", \"suspended\"`].",
+			Optional: true,
+			Computed: true,
+			PlanModifiers: []planmodifier.String{
+				stringplanmodifier.UseStateForUnknown(),
+			},
+			Validators: []validator.String{
+				stringvalidator.OneOf(vmPowerStates...),
+			},
+		},
+		"affinity_host": schema.StringAttribute{
+			MarkdownDescription: "The UUID of the host the virtual machine prefers to start on, default inherited from the template. Set as `\"\"` to remove the affinity.",
+			Optional:            true,
+			Computed:            true,
+		},
+		"resident_host": schema.StringAttribute{
+			MarkdownDescription: "The UUID of the host the virtual machine runs on, or starts on when it isn't running. The virtual machine running on another host is migrated live to it.",
+			Optional:            true,
+			Computed:            true,
+		},
+		"hard_shutdown_fallback": schema.BoolAttribute{
+			MarkdownDescription: "Whether to hard shut down the virtual machine when its clean shutdown fails or doesn't complete in `clean_shutdown_timeout`, e.g. the VM tools aren't running, default to be `true`.",
+			Optional:            true,
+			Computed:            true,
+			Default:             booldefault.StaticBool(true),
+		},
+		"clean_shutdown_timeout": schema.Int64Attribute{
+			MarkdownDescription: "The duration in seconds of the clean shutdown of the virtual machine, default to be `120` seconds.",
+			Optional:            true,
+			Computed:            true,
+			Default:             int64default.StaticInt64(defaultCleanShutdownTimeout),
+			Validators: []validator.Int64{
+				int64validator.AtLeast(1),
+			},
+		},
+		"cloud_init": schema.SingleNestedAttribute{
+			MarkdownDescription: "The cloud-init configuration of the virtual machine, given to it by a NoCloud config drive, an ISO 9660 disk labelled `cidata`." +
+				"\n\n-> **Note:** `cloud_init` is only applied when the virtual machine is created, changing it replaces the virtual machine. " +
+				"It isn't imported, so add it to the configuration of an imported virtual machine only to recreate it.",
+			Attributes: cloudInitSchema(),
+			Optional:   true,
+			PlanModifiers: []planmodifier.Object{
+				objectplanmodifier.RequiresReplace(),
+			},
+		},
 		"default_ip": schema.StringAttribute{
 			MarkdownDescription: "The default IP address of the virtual machine.",
 			Computed:            true,
@@ -600,7 +660,7 @@ func getFirstTemplate(session *xenapi.Session, templateName string) (xenapi.VMRe
 	var vmRef xenapi.VMRef
 	records, err := xenapi.VM.GetAllRecords(session)
 	if err != nil {
-		return vmRef, errors.New(err.Error())
+		return vmRef, newXAPIError(err)
 	}
 
 	// Get the first VM template ref
@@ -617,7 +677,7 @@ func checkIfSupportFullCopy(session *xenapi.Session, templateRef xenapi.VMRef, s
 	// show error if choose the XS default template
 	isDefaultTemplate, err := xenapi.VM.GetIsDefaultTemplate(session, templateRef)
 	if err != nil {
-		return srRef, errors.New("can't get is_default_template. " + err.Error())
+		return srRef, fmt.Errorf("can't get is_default_template. %w", err)
 	}
 	if isDefaultTemplate {
 		return srRef, errors.New("don't support default template")
@@ -631,11 +691,11 @@ func checkIfSupportFullCopy(session *xenapi.Session, templateRef xenapi.VMRef, s
 	for _, vbdRefStr := range templateHardDrives {
 		vdiRef, err := xenapi.VBD.GetVDI(session, xenapi.VBDRef(vbdRefStr))
 		if err != nil {
-			return srRef, errors.New("can't get VDI ref. " + err.Error())
+			return srRef, fmt.Errorf("can't get VDI ref. %w", err)
 		}
 		allowedOps, err := xenapi.VDI.GetAllowedOperations(session, vdiRef)
 		if err != nil {
-			return srRef, errors.New("can't get VDI allowed_operations. " + err.Error())
+			return srRef, fmt.Errorf("can't get VDI allowed_operations. %w", err)
 		}
 		if !slices.Contains(allowedOps, xenapi.VdiOperationsCopy) {
 			return srRef, errors.New("template disk doesn't allow copy")
@@ -645,44 +705,290 @@ func checkIfSupportFullCopy(session *xenapi.Session, templateRef xenapi.VMRef, s
 	if srUUID != "origin" {
 		srRef, err = xenapi.SR.GetByUUID(session, srUUID)
 		if err != nil {
-			return srRef, errors.New("can't get SR ref. " + err.Error())
+			return srRef, fmt.Errorf("can't get SR ref. %w", err)
 		}
 	}
 	return srRef, nil
 }
 
-func setOtherConfigWhenCreate(session *xenapi.Session, vmRef xenapi.VMRef) error {
+// vmPrivateStateKey is the key of the VM bookkeeping in the private state of
+// the resource.
+const vmPrivateStateKey = "vm"
+
+// legacyVMOtherConfigKeys are the keys the previous versions of the provider
+// stored their bookkeeping in, in the other_config of the VMs.
+var legacyVMOtherConfigKeys = []string{
+	"tf_other_config_keys",
+	"tf_check_ip_timeout",
+	"tf_template_name",
+	"tf_sr_for_full_disk_copy",
+	"tf_template_vbds",
+}
+
+// vmPrivateState is the bookkeeping of the VM resource which is not part of
+// its schema. It is kept in the private state of Terraform rather than in the
+// VM other_config, which is shown in XenCenter and copied by the clones.
+type vmPrivateState struct {
+	// OtherConfigKeys are the keys of the VM other_config set by the resource.
+	OtherConfigKeys []string `json:"otherConfigKeys"`
+	// TemplateVBDs are the disk VBDs cloned from the template, which are not
+	// managed by the hard_drive attribute and are destroyed with the VM.
+	TemplateVBDs []xenapi.VBDRef `json:"templateVBDs"`
+	// ConfigDriveVBD is the VBD of the cloud-init config drive, until it is
+	// removed after the boot.
+	ConfigDriveVBD xenapi.VBDRef `json:"configDriveVBD,omitempty"`
+	// InlineVBDs are the VBDs of the hard_drive items created with their size,
+	// their VDIs are destroyed with the items or the VM.
+	InlineVBDs []xenapi.VBDRef `json:"inlineVBDs,omitempty"`
+}
+
+// unmanagedVBDs returns the disk VBDs which are not managed by the hard_drive
+// attribute, their VDIs are destroyed with the VM.
+func (p vmPrivateState) unmanagedVBDs() []xenapi.VBDRef {
+	vbds := slices.Clone(p.TemplateVBDs)
+	if p.ConfigDriveVBD != "" {
+		vbds = append(vbds, p.ConfigDriveVBD)
+	}
+	return vbds
+}
+
+// ownedVBDs returns the disk VBDs whose VDIs are destroyed with the VM.
+func (p vmPrivateState) ownedVBDs() []xenapi.VBDRef {
+	return append(p.unmanagedVBDs(), p.InlineVBDs...)
+}
+
+type privateStateGetter interface {
+	GetKey(ctx context.Context, key string) ([]byte, diag.Diagnostics)
+}
+
+type privateStateSetter interface {
+	SetKey(ctx context.Context, key string, value []byte) diag.Diagnostics
+}
+
+// getVMPrivateState returns the bookkeeping of the VM from the private state.
+// When the private state is empty, e.g. the VM was created by a previous
+// version of the provider or imported, it is read from the legacy tf_* keys
+// of the VM other_config, or from the template of the VM. The VM isn't
+// changed, the legacy keys are removed by removeLegacyVMOtherConfig once the
+// bookkeeping is saved in the private state.
+func getVMPrivateState(ctx context.Context, private privateStateGetter, session *xenapi.Session, vmRef xenapi.VMRef, data *vmResourceModel) (vmPrivateState, error) {
+	var vmPrivate vmPrivateState
+	value, diags := private.GetKey(ctx, vmPrivateStateKey)
+	if diags.HasError() {
+		return vmPrivate, errors.New("unable to read VM private state")
+	}
+	if len(value) != 0 {
+		err := json.Unmarshal(value, &vmPrivate)
+		if err != nil {
+			return vmPrivate, fmt.Errorf("unable to parse VM private state. %w", err)
+		}
+		return vmPrivate, nil
+	}
+
+	return getLegacyVMPrivateState(session, vmRef, data)
+}
+
+func setVMPrivateState(ctx context.Context, private privateStateSetter, vmPrivate vmPrivateState) error {
+	value, err := json.Marshal(vmPrivate)
+	if err != nil {
+		return fmt.Errorf("unable to encode VM private state. %w", err)
+	}
+	diags := private.SetKey(ctx, vmPrivateStateKey, value)
+	if diags.HasError() {
+		return errors.New("unable to set VM private state")
+	}
+	return nil
+}
+
+// getLegacyVMPrivateState reads the bookkeeping from the legacy tf_* keys of
+// the VM other_config. The attributes which are missing in the state, e.g.
+// after an import, are set from the legacy keys or to their default values.
+func getLegacyVMPrivateState(session *xenapi.Session, vmRef xenapi.VMRef, data *vmResourceModel) (vmPrivateState, error) {
+	vmPrivate := vmPrivateState{OtherConfigKeys: []string{}, TemplateVBDs: []xenapi.VBDRef{}}
 	vmOtherConfig, err := xenapi.VM.GetOtherConfig(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return vmPrivate, newXAPIError(err)
+	}
+
+	if data.SRForFullDiskCopy.IsNull() {
+		data.SRForFullDiskCopy = types.StringValue(vmOtherConfig["tf_sr_for_full_disk_copy"])
+	}
+	if data.CheckIPTimeout.IsNull() {
+		data.CheckIPTimeout = types.Int64Value(0)
+		if value, ok := vmOtherConfig["tf_check_ip_timeout"]; ok {
+			checkIPTimeout, err := strconv.Atoi(value)
+			if err != nil {
+				return vmPrivate, errors.New("unable to convert check_ip_timeout to an int value")
+			}
+			data.CheckIPTimeout = types.Int64Value(int64(checkIPTimeout))
+		}
+	}
+
+	if !slices.ContainsFunc(legacyVMOtherConfigKeys, func(key string) bool { _, ok := vmOtherConfig[key]; return ok }) {
+		return getImportedVMPrivateState(session, vmRef, vmOtherConfig, data)
+	}
+
+	for _, key := range strings.Split(vmOtherConfig["tf_other_config_keys"], ",") {
+		if key != "" {
+			vmPrivate.OtherConfigKeys = append(vmPrivate.OtherConfigKeys, key)
+		}
+	}
+	for _, ref := range strings.Split(vmOtherConfig["tf_template_vbds"], ",") {
+		if ref != "" {
+			vmPrivate.TemplateVBDs = append(vmPrivate.TemplateVBDs, xenapi.VBDRef(ref))
+		}
+	}
+	if value, ok := vmOtherConfig["tf_template_name"]; ok && data.TemplateName.IsNull() {
+		data.TemplateName = types.StringValue(value)
+	}
+
+	return vmPrivate, nil
+}
+
+// baseTemplateNameKey is the key of the VM other_config holding the name of
+// the template the VM was created from, which XAPI sets when a template is
+// cloned. The provider only reads it.
+const baseTemplateNameKey = "base_template_name"
+
+// xapiVMOtherConfigKeys are the keys of the VM other_config set by XAPI, which
+// are never part of the other_config attribute.
+var xapiVMOtherConfigKeys = []string{baseTemplateNameKey, "mac_seed", "import_task", "disks"}
+
+// getImportedVMPrivateState returns the bookkeeping of an imported VM, which
+// has neither private state nor legacy keys. It is found from the template of
+// the base_template_name key of the VM other_config:
+//   - the disks on the devices of the template disks were cloned with the VM,
+//     they are not managed by hard_drive and are destroyed with the VM;
+//   - the other_config keys which aren't inherited from the template are the
+//     keys of the other_config attribute.
+//
+// When the template can't be found, all the disks are managed by hard_drive
+// and the other_config attribute is empty.
+func getImportedVMPrivateState(session *xenapi.Session, vmRef xenapi.VMRef, vmOtherConfig map[string]string, data *vmResourceModel) (vmPrivateState, error) {
+	vmPrivate := vmPrivateState{OtherConfigKeys: []string{}, TemplateVBDs: []xenapi.VBDRef{}}
+	templateName, ok := vmOtherConfig[baseTemplateNameKey]
+	if !ok {
+		return vmPrivate, nil
+	}
+	if data.TemplateName.IsNull() {
+		data.TemplateName = types.StringValue(templateName)
+	}
+	templateRef, err := getFirstTemplate(session, templateName)
+	if err != nil {
+		return vmPrivate, nil //nolint:nilerr // the template was removed since the VM was created
+	}
+	templateRecord, err := xenapi.VM.GetRecord(session, templateRef)
+	if err != nil {
+		return vmPrivate, newXAPIError(err)
+	}
+
+	for key, value := range vmOtherConfig {
+		if slices.Contains(xapiVMOtherConfigKeys, key) {
+			continue
+		}
+		if templateValue, ok := templateRecord.OtherConfig[key]; !ok || templateValue != value {
+			vmPrivate.OtherConfigKeys = append(vmPrivate.OtherConfigKeys, key)
+		}
+	}
+	slices.Sort(vmPrivate.OtherConfigKeys)
+
+	var templateDevices []string
+	for _, vbdRef := range templateRecord.VBDs {
+		vbdRecord, err := xenapi.VBD.GetRecord(session, vbdRef)
+		if err != nil {
+			return vmPrivate, newXAPIError(err)
+		}
+		if vbdRecord.Type == xenapi.VbdTypeDisk {
+			templateDevices = append(templateDevices, vbdRecord.Userdevice)
+		}
+	}
+	vbdRefs, err := xenapi.VM.GetVBDs(session, vmRef)
+	if err != nil {
+		return vmPrivate, newXAPIError(err)
+	}
+	for _, vbdRef := range vbdRefs {
+		vbdRecord, err := xenapi.VBD.GetRecord(session, vbdRef)
+		if err != nil {
+			return vmPrivate, newXAPIError(err)
+		}
+		if vbdRecord.Type == xenapi.VbdTypeDisk && slices.Contains(templateDevices, vbdRecord.Userdevice) {
+			vmPrivate.TemplateVBDs = append(vmPrivate.TemplateVBDs, vbdRef)
+		}
+	}
+
+	return vmPrivate, nil
+}
+
+func setOtherConfigWhenCreate(session *xenapi.Session, vmRef xenapi.VMRef) (vmPrivateState, error) {
+	vmPrivate := vmPrivateState{OtherConfigKeys: []string{}, TemplateVBDs: []xenapi.VBDRef{}}
+	vmOtherConfig, err := xenapi.VM.GetOtherConfig(session, vmRef)
+	if err != nil {
+		return vmPrivate, newXAPIError(err)
 	}
 
 	// Remove "disks" from other-config for VM.Provision
-	_, ok := vmOtherConfig["disks"]
-	if ok {
-		delete(vmOtherConfig, "disks")
+	delete(vmOtherConfig, "disks")
+
+	// Remove the legacy keys copied from a template made of a VM of the provider
+	for _, key := range legacyVMOtherConfigKeys {
+		delete(vmOtherConfig, key)
 	}
 
 	// Get VM template Disk Type VBDs (which are not managed by the TF)
 	templateHardDrives, err := getAllDiskTypeVBDs(session, vmRef)
 	if err != nil {
-		return err
+		return vmPrivate, err
 	}
-	templateVBDs := strings.Join(templateHardDrives, ",")
-	// Set the template VBD refs only once after the VM is cloned from a template
-	if templateVBDs != "" {
-		vmOtherConfig["tf_template_vbds"] = templateVBDs
+	for _, ref := range templateHardDrives {
+		vmPrivate.TemplateVBDs = append(vmPrivate.TemplateVBDs, xenapi.VBDRef(ref))
 	}
 
 	err = xenapi.VM.SetOtherConfig(session, vmRef, vmOtherConfig)
 	if err != nil {
-		return errors.New(err.Error())
+		return vmPrivate, newXAPIError(err)
+	}
+
+	return vmPrivate, nil
+}
+
+// removeLegacyVMOtherConfig removes the legacy tf_* keys from the VM
+// other_config, once their bookkeeping is saved in the private state. Nothing
+// is changed in read-only mode, the keys are then removed by a later refresh.
+func removeLegacyVMOtherConfig(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef) error {
+	if isReadOnlySession(session) {
+		return nil
+	}
+
+	unlock, err := lockObject(ctx, string(vmRef))
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
+	vmOtherConfig, err := xenapi.VM.GetOtherConfig(session, vmRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	if !slices.ContainsFunc(legacyVMOtherConfigKeys, func(key string) bool { _, ok := vmOtherConfig[key]; return ok }) {
+		return nil
+	}
+	for _, key := range legacyVMOtherConfigKeys {
+		delete(vmOtherConfig, key)
+	}
+	tflog.Debug(ctx, "-----> remove the legacy other_config keys of the VM")
+
+	err = xenapi.VM.SetOtherConfig(session, vmRef, vmOtherConfig)
+	if err != nil {
+		return newXAPIError(err)
+	}
 	return nil
 }
 
-func updateOtherConfigFromPlan(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) error {
+// updateOtherConfigFromPlan sets the other_config keys of the plan and removes
+// the keys previously set by the resource which are not in the plan anymore.
+// The caller holds the lock of the VM, as other_config is read and written
+// again.
+func updateOtherConfigFromPlan(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel, vmPrivate *vmPrivateState) error {
 	planOtherConfig := make(map[string]string)
 	if !plan.OtherConfig.IsUnknown() {
 		diags := plan.OtherConfig.ElementsAs(ctx, &planOtherConfig, false)
@@ -693,32 +999,31 @@ func updateOtherConfigFromPlan(ctx context.Context, session *xenapi.Session, vmR
 
 	vmOtherConfig, err := xenapi.VM.GetOtherConfig(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
-	originalTFOtherConfigKeys := vmOtherConfig["tf_other_config_keys"]
-	// Remove all originalTFOtherConfigKeys
-	originalKeys := strings.Split(originalTFOtherConfigKeys, ",")
-	for _, key := range originalKeys {
+	// Remove all the keys set before, and the legacy keys of the VMs created
+	// by a previous version of the provider
+	for _, key := range vmPrivate.OtherConfigKeys {
+		delete(vmOtherConfig, key)
+	}
+	for _, key := range legacyVMOtherConfigKeys {
 		delete(vmOtherConfig, key)
 	}
 
-	var tfOtherConfigKeys []string
+	tfOtherConfigKeys := []string{}
 	for key, value := range planOtherConfig {
 		vmOtherConfig[key] = value
 		tfOtherConfigKeys = append(tfOtherConfigKeys, key)
 		tflog.Debug(ctx, "-----> setOtherConfig key: "+key+" value: "+value)
 	}
-
-	vmOtherConfig["tf_other_config_keys"] = strings.Join(tfOtherConfigKeys, ",")
-	vmOtherConfig["tf_check_ip_timeout"] = plan.CheckIPTimeout.String()
-	vmOtherConfig["tf_template_name"] = plan.TemplateName.ValueString()
-	vmOtherConfig["tf_sr_for_full_disk_copy"] = plan.SRForFullDiskCopy.ValueString()
+	slices.Sort(tfOtherConfigKeys)
 
 	err = xenapi.VM.SetOtherConfig(session, vmRef, vmOtherConfig)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
+	vmPrivate.OtherConfigKeys = tfOtherConfigKeys
 
 	return nil
 }
@@ -757,7 +1062,7 @@ func getCorePerSocket(vmRecord xenapi.VMRecord) (int32, error) {
 	return int32(socketInt), nil // #nosec G109
 }
 
-func updateVMResourceModelComputed(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord, data *vmResourceModel) error {
+func updateVMResourceModelComputed(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord, vmPrivate vmPrivateState, data *vmResourceModel) error {
 	var err error
 	data.NameDescription = types.StringValue(vmRecord.NameDescription)
 	data.UUID = types.StringValue(vmRecord.UUID)
@@ -777,7 +1082,7 @@ func updateVMResourceModelComputed(ctx context.Context, session *xenapi.Session,
 		return err
 	}
 
-	data.HardDrive, _, err = getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeDisk)
+	data.HardDrive, _, err = getVBDsFromVMRecord(ctx, session, vmRecord, xenapi.VbdTypeDisk, vmPrivate.unmanagedVBDs(), vmPrivate.InlineVBDs)
 	if err != nil {
 		return err
 	}
@@ -800,47 +1105,61 @@ func updateVMResourceModelComputed(ctx context.Context, session *xenapi.Session,
 	}
 	data.BootOrder = types.StringValue(bootOrder)
 
-	// only keep the key which configured by user
-	data.OtherConfig, err = getOtherConfigFromVMRecord(ctx, vmRecord)
+	// the power state changed outside of Terraform is reported as a drift
+	data.PowerState = types.StringValue(strings.ToLower(string(vmRecord.PowerState)))
+
+	affinityHost, err := getUUIDFromHostRef(session, vmRecord.Affinity)
 	if err != nil {
 		return err
 	}
+	data.AffinityHost = types.StringValue(affinityHost)
 
-	if _, ok := vmRecord.OtherConfig["tf_check_ip_timeout"]; ok {
-		checkIPDuration, err := strconv.Atoi(vmRecord.OtherConfig["tf_check_ip_timeout"])
-		if err != nil {
-			return errors.New("unable to convert check_ip_timeout to an int value")
-		}
-		data.CheckIPTimeout = types.Int64Value(int64(checkIPDuration))
+	residentHost, err := getUUIDFromHostRef(session, vmRecord.ResidentOn)
+	if err != nil {
+		return err
+	}
+	// the VM which isn't running keeps the host it starts on
+	if residentHost != "" || data.ResidentHost.IsUnknown() || data.ResidentHost.IsNull() {
+		data.ResidentHost = types.StringValue(residentHost)
+	}
 
-		ip, err := checkIP(ctx, session, vmRecord)
-		if err != nil {
-			return err
-		}
-		data.DefaultIP = types.StringValue(ip)
+	// only keep the key which configured by user
+	data.OtherConfig, err = getOtherConfigFromVMRecord(ctx, vmRecord, vmPrivate.OtherConfigKeys)
+	if err != nil {
+		return err
 	}
 
-	if _, ok := vmRecord.OtherConfig["tf_sr_for_full_disk_copy"]; ok {
-		data.SRForFullDiskCopy = types.StringValue(vmRecord.OtherConfig["tf_sr_for_full_disk_copy"])
+	ip, err := checkIP(ctx, session, vmRecord, data.CheckIPTimeout.ValueInt64())
+	if err != nil {
+		return err
 	}
+	data.DefaultIP = types.StringValue(ip)
 
 	return nil
 }
 
 // Update vmResourceModel base on new vmRecord, except uuid
-func updateVMResourceModel(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord, data *vmResourceModel) error {
+func updateVMResourceModel(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord, vmPrivate vmPrivateState, data *vmResourceModel) error {
 	data.NameLabel = types.StringValue(vmRecord.NameLabel)
-	data.TemplateName = types.StringValue(vmRecord.OtherConfig["tf_template_name"])
 	data.StaticMemMax = types.Int64Value(int64(vmRecord.MemoryStaticMax))
 	vcpusMax, err := ToInt32(vmRecord.VCPUsMax)
 	if err != nil {
 		return err
 	}
 	data.VCPUs = types.Int32Value(vcpusMax)
-	return updateVMResourceModelComputed(ctx, session, vmRecord, data)
+	// the VMs imported or created by a previous version of the provider
+	if data.HardShutdownFallback.IsNull() {
+		data.HardShutdownFallback = types.BoolValue(true)
+	}
+	if data.CleanShutdownTimeout.IsNull() {
+		data.CleanShutdownTimeout = types.Int64Value(defaultCleanShutdownTimeout)
+	}
+	return updateVMResourceModelComputed(ctx, session, vmRecord, vmPrivate, data)
 }
 
-func getVBDsFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord, vbdType xenapi.VbdType) (basetypes.SetValue, []vbdResourceModel, error) {
+// getVBDsFromVMRecord returns the VBDs of the type, except the unmanaged ones.
+// The size and the SR are only set for the inline hard drives.
+func getVBDsFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord, vbdType xenapi.VbdType, unmanagedVBDs []xenapi.VBDRef, inlineVBDs []xenapi.VBDRef) (basetypes.SetValue, []vbdResourceModel, error) {
 	vbdSet := []vbdResourceModel{}
 	var setValue basetypes.SetValue
 
@@ -850,10 +1169,17 @@ func getVBDsFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord
 			return setValue, vbdSet, errors.New("unable to get VBD record")
 		}
 
-		if vbdRecord.Type != vbdType || slices.Contains(getTemplateVBDRefListFromVMRecord(vmRecord), vbdRef) {
+		if vbdRecord.Type != vbdType || slices.Contains(unmanagedVBDs, vbdRef) {
 			continue
 		}
 
+		vbd := vbdResourceModel{
+			VBD:      types.StringValue(string(vbdRef)),
+			Bootable: types.BoolValue(vbdRecord.Bootable),
+			Mode:     types.StringValue(string(vbdRecord.Mode)),
+			Size:     types.Int64Null(),
+			SR:       types.StringNull(),
+		}
 		// for CD type VBD, VDI can be NULL
 		vdiUUID := ""
 		if string(vbdRecord.VDI) != "OpaqueRef:NULL" {
@@ -862,13 +1188,16 @@ func getVBDsFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord
 				return setValue, vbdSet, errors.New("unable to get VDI record")
 			}
 			vdiUUID = vdiRecord.UUID
+			if slices.Contains(inlineVBDs, vbdRef) {
+				srUUID, err := getUUIDFromSRRef(session, vdiRecord.SR)
+				if err != nil {
+					return setValue, vbdSet, err
+				}
+				vbd.Size = types.Int64Value(int64(vdiRecord.VirtualSize))
+				vbd.SR = types.StringValue(srUUID)
+			}
 		}
-		vbd := vbdResourceModel{
-			VDI:      types.StringValue(vdiUUID),
-			VBD:      types.StringValue(string(vbdRef)),
-			Bootable: types.BoolValue(vbdRecord.Bootable),
-			Mode:     types.StringValue(string(vbdRecord.Mode)),
-		}
+		vbd.VDI = types.StringValue(vdiUUID)
 		vbdSet = append(vbdSet, vbd)
 	}
 
@@ -881,10 +1210,10 @@ func getVBDsFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord
 	return setValue, vbdSet, nil
 }
 
-func getOtherConfigFromVMRecord(ctx context.Context, vmRecord xenapi.VMRecord) (basetypes.MapValue, error) {
+func getOtherConfigFromVMRecord(ctx context.Context, vmRecord xenapi.VMRecord, otherConfigKeys []string) (basetypes.MapValue, error) {
 	otherConfig := make(map[string]string)
 	for key := range vmRecord.OtherConfig {
-		if slices.Contains(strings.Split(vmRecord.OtherConfig["tf_other_config_keys"], ","), key) {
+		if slices.Contains(otherConfigKeys, key) {
 			otherConfig[key] = vmRecord.OtherConfig[key]
 		}
 	}
@@ -904,13 +1233,13 @@ func getVIFsFromVMRecord(ctx context.Context, session *xenapi.Session, vmRecord
 	for _, vifRef := range vmRecord.VIFs {
 		vifRecord, err := xenapi.VIF.GetRecord(session, vifRef)
 		if err != nil {
-			return setValue, errors.New(err.Error())
+			return setValue, newXAPIError(err)
 		}
 
 		// get network uuid
 		networkRecord, err := xenapi.Network.GetRecord(session, vifRecord.Network)
 		if err != nil {
-			return setValue, errors.New(err.Error())
+			return setValue, newXAPIError(err)
 		}
 
 		vif := vifResourceModel{
@@ -966,7 +1295,7 @@ func setVMMemory(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceMod
 	memorySetting := getVMMemory(plan)
 	err := xenapi.VM.SetMemoryLimits(session, vmRef, memorySetting.staticMemMin, memorySetting.staticMemMax, memorySetting.dynamicMemMin, memorySetting.dynamicMemMax)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
@@ -981,14 +1310,14 @@ func updateVMMemory(ctx context.Context, session *xenapi.Session, vmRef xenapi.V
 	}
 	vmState, err := xenapi.VM.GetPowerState(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	if vmState == xenapi.VMPowerStateRunning {
 		return errors.New("unable to change memory for a running VM")
 	}
 	err = xenapi.VM.SetMemoryLimits(session, vmRef, planMemorySetting.staticMemMin, planMemorySetting.staticMemMax, planMemorySetting.dynamicMemMin, planMemorySetting.dynamicMemMax)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
@@ -997,7 +1326,7 @@ func updateVMMemory(ctx context.Context, session *xenapi.Session, vmRef xenapi.V
 func changeVCPUSettings(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) error {
 	vmPowerState, err := xenapi.VM.GetPowerState(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	if vmPowerState == xenapi.VMPowerStateRunning {
 		return errors.New("unable to change vcpus for a running VM")
@@ -1006,28 +1335,28 @@ func changeVCPUSettings(session *xenapi.Session, vmRef xenapi.VMRef, plan vmReso
 	vcpus := int(plan.VCPUs.ValueInt32())
 	vcpusAtStartup, err := xenapi.VM.GetVCPUsAtStartup(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	// VCPU values must satisfy: 0 < VCPUs_at_startup ≤ VCPUs_max
 	if vcpusAtStartup > vcpus {
 		// reducing VCPUs_at_startup: we need to change this value first, and then the VCPUs_max
 		err := xenapi.VM.SetVCPUsAtStartup(session, vmRef, vcpus)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 		err = xenapi.VM.SetVCPUsMax(session, vmRef, vcpus)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	} else {
 		// increasing VCPUs_at_startup: we need to change the VCPUs_max first
 		err := xenapi.VM.SetVCPUsMax(session, vmRef, vcpus)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 		err = xenapi.VM.SetVCPUsAtStartup(session, vmRef, vcpus)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
@@ -1045,7 +1374,7 @@ func updateVMCPUs(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMR
 func updateCorePerSocket(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) error {
 	platform, err := xenapi.VM.GetPlatform(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	if plan.CorePerSocket.IsUnknown() {
 		// if user doesn't set cores-per-socket and it is not found in template, set it to VCPUs num as the default value
@@ -1053,7 +1382,7 @@ func updateCorePerSocket(session *xenapi.Session, vmRef xenapi.VMRef, plan vmRes
 			platform["cores-per-socket"] = plan.VCPUs.String()
 			err := xenapi.VM.SetPlatform(session, vmRef, platform)
 			if err != nil {
-				return errors.New(err.Error())
+				return newXAPIError(err)
 			}
 		}
 	} else {
@@ -1065,7 +1394,7 @@ func updateCorePerSocket(session *xenapi.Session, vmRef xenapi.VMRef, plan vmRes
 		platform["cores-per-socket"] = strconv.Itoa(coresPerSocket)
 		err := xenapi.VM.SetPlatform(session, vmRef, platform)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
@@ -1080,12 +1409,12 @@ func updateBootOrder(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourc
 
 	hvmBootParams, err := xenapi.VM.GetHVMBootParams(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 	hvmBootParams["order"] = plan.BootOrder.ValueString()
 	err = xenapi.VM.SetHVMBootParams(session, vmRef, hvmBootParams)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
@@ -1099,7 +1428,7 @@ func updateBootMode(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResource
 
 	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	secureBoot := "false"
@@ -1113,37 +1442,45 @@ func updateBootMode(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResource
 	platform["secureboot"] = secureBoot
 	err = xenapi.VM.SetPlatform(session, vmRef, platform)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	hvmBootParams := vmRecord.HVMBootParams
 	hvmBootParams["firmware"] = bootMode
 	err = xenapi.VM.SetHVMBootParams(session, vmRef, hvmBootParams)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
 }
 
-func vmResourceModelUpdate(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel, state vmResourceModel) error {
-	// set other config before getting the VM record for tf_ fields update
-	err := updateOtherConfigFromPlan(ctx, session, vmRef, plan)
+func vmResourceModelUpdate(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel, state vmResourceModel, vmPrivate *vmPrivateState) error {
+	err := updateOtherConfigFromPlan(ctx, session, vmRef, plan, vmPrivate)
 	if err != nil {
 		return err
 	}
 
 	err = xenapi.VM.SetNameLabel(session, vmRef, plan.NameLabel.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	err = xenapi.VM.SetNameDescription(session, vmRef, plan.NameDescription.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
-	err = updateVBDs(ctx, plan, state, vmRef, session)
+	// the VM is shut down before the settings that can't change while it is
+	// running, e.g. the memory, and started again after them otherwise
+	if !plan.PowerState.IsUnknown() && plan.PowerState.ValueString() == "halted" {
+		err = setVMPowerState(ctx, session, vmRef, plan)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = updateVBDs(ctx, plan, state, vmRef, session, vmPrivate)
 	if err != nil {
 		return err
 	}
@@ -1183,7 +1520,17 @@ func vmResourceModelUpdate(ctx context.Context, session *xenapi.Session, vmRef x
 		return err
 	}
 
-	err = startVM(session, vmRef, plan)
+	err = updateAffinityHost(session, vmRef, plan)
+	if err != nil {
+		return err
+	}
+
+	err = setVMPowerState(ctx, session, vmRef, plan)
+	if err != nil {
+		return err
+	}
+
+	err = migrateVM(ctx, session, vmRef, plan)
 	if err != nil {
 		return err
 	}
@@ -1191,27 +1538,27 @@ func vmResourceModelUpdate(ctx context.Context, session *xenapi.Session, vmRef x
 	return nil
 }
 
-func setVMResourceModel(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) error {
-	err := setOtherConfigWhenCreate(session, vmRef)
+func setVMResourceModel(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel, vmPrivate *vmPrivateState) error {
+	var err error
+	*vmPrivate, err = setOtherConfigWhenCreate(session, vmRef)
 	if err != nil {
 		return err
 	}
 
-	// set other config before getting the VM record for tf_ fields update
-	err = updateOtherConfigFromPlan(ctx, session, vmRef, plan)
+	err = updateOtherConfigFromPlan(ctx, session, vmRef, plan, vmPrivate)
 	if err != nil {
 		return err
 	}
 
 	err = xenapi.VM.SetNameLabel(session, vmRef, plan.NameLabel.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	// set name description
 	err = xenapi.VM.SetNameDescription(session, vmRef, plan.NameDescription.ValueString())
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	// set memory
@@ -1243,8 +1590,13 @@ func setVMResourceModel(ctx context.Context, session *xenapi.Session, vmRef xena
 		return err
 	}
 
+	err = updateAffinityHost(session, vmRef, plan)
+	if err != nil {
+		return err
+	}
+
 	// add hard_drive
-	err = createVBDs(ctx, session, vmRef, plan, xenapi.VbdTypeDisk)
+	err = createVBDs(ctx, session, vmRef, plan, xenapi.VbdTypeDisk, vmPrivate)
 	if err != nil {
 		return err
 	}
@@ -1261,18 +1613,28 @@ func setVMResourceModel(ctx context.Context, session *xenapi.Session, vmRef xena
 		return err
 	}
 
-	err = xenapi.VM.Provision(session, vmRef)
+	// add the config drive of cloud_init
+	vmPrivate.ConfigDriveVBD, err = createConfigDrive(ctx, session, vmRef, plan)
 	if err != nil {
-		return errors.New(err.Error())
+		return err
+	}
+
+	taskRef, err := xenapi.VM.AsyncProvision(session, vmRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	_, err = waitForTask(ctx, session, taskRef)
+	if err != nil {
+		return fmt.Errorf("unable to provision VM. %w", err)
 	}
 
 	// reset template flag
 	err = xenapi.VM.SetIsATemplate(session, vmRef, false)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
-	err = startVM(session, vmRef, plan)
+	err = setVMPowerState(ctx, session, vmRef, plan)
 	if err != nil {
 		return err
 	}
@@ -1286,6 +1648,30 @@ func isValidIpAddress(ip net.IP) bool {
 	return ip.IsGlobalUnicast()
 }
 
+func cloneVM(ctx context.Context, session *xenapi.Session, templateRef xenapi.VMRef, nameLabel string) (xenapi.VMRef, error) {
+	taskRef, err := xenapi.VM.AsyncClone(session, templateRef, nameLabel)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	result, err := waitForTask(ctx, session, taskRef)
+	if err != nil {
+		return "", err
+	}
+	return xenapi.VMRef(result), nil
+}
+
+func copyVM(ctx context.Context, session *xenapi.Session, templateRef xenapi.VMRef, nameLabel string, srRef xenapi.SRRef) (xenapi.VMRef, error) {
+	taskRef, err := xenapi.VM.AsyncCopy(session, templateRef, nameLabel, srRef)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+	result, err := waitForTask(ctx, session, taskRef)
+	if err != nil {
+		return "", err
+	}
+	return xenapi.VMRef(result), nil
+}
+
 func startVM(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) error {
 	// start a VM automatically if the check_ip_timeout is set and not equal to 0
 	if plan.CheckIPTimeout.IsUnknown() || plan.CheckIPTimeout.ValueInt64() == 0 {
@@ -1293,51 +1679,246 @@ func startVM(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel)
 	}
 	vmPowerState, err := xenapi.VM.GetPowerState(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	if vmPowerState != xenapi.VMPowerStateRunning {
-		err := xenapi.VM.Start(session, vmRef, false, true)
+		err := startVMOnResidentHost(session, vmRef, vmPowerState, plan, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startVMOnResidentHost starts or resumes the VM on resident_host when it is
+// set, on the host chosen by XAPI otherwise, e.g. the affinity host.
+func startVMOnResidentHost(session *xenapi.Session, vmRef xenapi.VMRef, powerState xenapi.VMPowerState, plan vmResourceModel, startPaused bool) error {
+	hostRef, err := getHostRefFromUUID(session, plan.ResidentHost)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case powerState == xenapi.VMPowerStateSuspended && hostRef != "OpaqueRef:NULL":
+		err = xenapi.VM.ResumeOn(session, vmRef, hostRef, startPaused, true)
+	case powerState == xenapi.VMPowerStateSuspended:
+		err = xenapi.VM.Resume(session, vmRef, startPaused, true)
+	case hostRef != "OpaqueRef:NULL":
+		err = xenapi.VM.StartOn(session, vmRef, hostRef, startPaused, true)
+	default:
+		err = xenapi.VM.Start(session, vmRef, startPaused, true)
+	}
+	if err != nil {
+		return newXAPIError(err)
+	}
+	return nil
+}
+
+// getHostRefFromUUID returns the host of an attribute, OpaqueRef:NULL when it
+// is unknown or empty.
+func getHostRefFromUUID(session *xenapi.Session, uuid types.String) (xenapi.HostRef, error) {
+	if uuid.IsUnknown() || uuid.ValueString() == "" {
+		return "OpaqueRef:NULL", nil
+	}
+	hostRef, err := xenapi.Host.GetByUUID(session, uuid.ValueString())
+	if err != nil {
+		return hostRef, newXAPIError(err)
+	}
+	return hostRef, nil
+}
+
+func updateAffinityHost(session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) error {
+	if plan.AffinityHost.IsUnknown() {
+		return nil
+	}
+	hostRef, err := getHostRefFromUUID(session, plan.AffinityHost)
+	if err != nil {
+		return err
+	}
+	err = xenapi.VM.SetAffinity(session, vmRef, hostRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	return nil
+}
+
+// migrateVM moves the running VM live to resident_host when it runs on
+// another host.
+func migrateVM(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) error {
+	hostRef, err := getHostRefFromUUID(session, plan.ResidentHost)
+	if err != nil || hostRef == "OpaqueRef:NULL" {
+		return err
+	}
+	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		return newXAPIError(err)
+	}
+	if vmRecord.PowerState != xenapi.VMPowerStateRunning || vmRecord.ResidentOn == hostRef {
+		return nil
+	}
+
+	tflog.Debug(ctx, "-----> Migrate the VM to the host "+plan.ResidentHost.ValueString())
+	taskRef, err := xenapi.VM.AsyncPoolMigrate(session, vmRef, hostRef, map[string]string{"live": "true"})
+	if err != nil {
+		return newXAPIError(err)
+	}
+	_, err = waitForTask(ctx, session, taskRef)
+	if err != nil {
+		return fmt.Errorf("unable to migrate VM. %w", err)
+	}
+	return nil
+}
+
+// vmPowerStates are the values of power_state, the XAPI power states in lower
+// case.
+var vmPowerStates = []string{"running", "halted", "paused", "suspended"}
+
+const defaultCleanShutdownTimeout = 120
+
+// maxPowerStateSteps bounds the operations to reach a power state, e.g. a
+// paused VM is unpaused then shut down.
+const maxPowerStateSteps = 3
+
+// setVMPowerState brings the VM to the power_state of the plan. When it isn't
+// set, the VM is started if check_ip_timeout is set.
+func setVMPowerState(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, plan vmResourceModel) error {
+	if plan.PowerState.IsUnknown() || plan.PowerState.IsNull() {
+		return startVM(session, vmRef, plan)
+	}
+	target := plan.PowerState.ValueString()
+
+	for step := 0; ; step++ {
+		powerState, err := xenapi.VM.GetPowerState(session, vmRef)
+		if err != nil {
+			return newXAPIError(err)
+		}
+		if strings.EqualFold(string(powerState), target) {
+			return nil
+		}
+		if step == maxPowerStateSteps {
+			return errors.New("unable to change the power state of the VM to " + target + ", it is " + string(powerState))
+		}
+		tflog.Debug(ctx, "-----> Change the VM power state from "+string(powerState)+" to "+target)
+		err = changeVMPowerState(ctx, session, vmRef, powerState, target, plan)
 		if err != nil {
-			return errors.New(err.Error())
+			return err
 		}
 	}
+}
 
+// changeVMPowerState does one operation towards the target power state.
+func changeVMPowerState(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, powerState xenapi.VMPowerState, target string, plan vmResourceModel) error {
+	var err error
+	switch powerState {
+	case xenapi.VMPowerStateHalted:
+		return startVMOnResidentHost(session, vmRef, powerState, plan, target == "paused")
+	case xenapi.VMPowerStateRunning:
+		switch target {
+		case "halted":
+			return shutdownVM(ctx, session, vmRef, plan)
+		case "paused":
+			err = xenapi.VM.Pause(session, vmRef)
+		case "suspended":
+			err = xenapi.VM.Suspend(session, vmRef)
+		}
+	case xenapi.VMPowerStatePaused:
+		err = xenapi.VM.Unpause(session, vmRef)
+	case xenapi.VMPowerStateSuspended:
+		if target == "halted" {
+			// the memory image of the VM is discarded
+			err = xenapi.VM.HardShutdown(session, vmRef)
+		} else {
+			return startVMOnResidentHost(session, vmRef, powerState, plan, target == "paused")
+		}
+	case xenapi.VMPowerStateUnrecognized:
+		return errors.New("unable to change the power state of the VM, it is unrecognized")
+	}
+	if err != nil {
+		return newXAPIError(err)
+	}
 	return nil
 }
 
-func checkIP(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord) (string, error) {
-	checkIPTimeout, err := strconv.Atoi(vmRecord.OtherConfig["tf_check_ip_timeout"])
+// shutdownVM shuts down the VM cleanly, falling back to a hard shutdown when
+// hard_shutdown_fallback is set and the clean shutdown fails or times out. It
+// is also used to destroy the VM, with the values of the state, which are null
+// before the VM is read by this version of the provider.
+func shutdownVM(ctx context.Context, session *xenapi.Session, vmRef xenapi.VMRef, data vmResourceModel) error {
+	timeout := int64(defaultCleanShutdownTimeout)
+	if !data.CleanShutdownTimeout.IsNull() && !data.CleanShutdownTimeout.IsUnknown() {
+		timeout = data.CleanShutdownTimeout.ValueInt64()
+	}
+	fallback := data.HardShutdownFallback.IsNull() || data.HardShutdownFallback.IsUnknown() || data.HardShutdownFallback.ValueBool()
+
+	taskRef, err := xenapi.VM.AsyncCleanShutdown(session, vmRef)
+	if err == nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+		_, err = waitForTask(shutdownCtx, session, taskRef)
+	}
+	if err == nil {
+		return nil
+	}
+	if !fallback {
+		return fmt.Errorf("unable to shut down the VM cleanly. %w", err)
+	}
+
+	tflog.Debug(ctx, "-----> Hard shut down the VM, the clean shutdown failed. "+err.Error())
+	err = xenapi.VM.HardShutdown(session, vmRef)
 	if err != nil {
-		return "", errors.New(err.Error())
+		return newXAPIError(err)
 	}
+	return nil
+}
 
+func checkIP(ctx context.Context, session *xenapi.Session, vmRecord xenapi.VMRecord, checkIPTimeout int64) (string, error) {
 	// check_ip_timeout is 0 that means won't need to checkIP, return directly
 	if checkIPTimeout == 0 {
 		return "", nil
 	}
 
-	// set timeout channel to check if IP address is available
-	timeoutChan := time.After(time.Duration(checkIPTimeout) * time.Second)
-	for {
-		select {
-		case <-timeoutChan:
-			return "", errors.New("get IP timeout in " + vmRecord.OtherConfig["tf_check_ip_timeout"] + " seconds")
-		default:
-			ip, _ := getIPAddressFromMetrics(session, vmRecord)
-			if ip != "" {
-				return ip, nil
-			}
-			tflog.Debug(ctx, "-----> Retry getIPAddressFromMetrics")
-			time.Sleep(5 * time.Second)
+	vmRef, err := xenapi.VM.GetByUUID(session, vmRecord.UUID)
+	if err != nil {
+		return "", newXAPIError(err)
+	}
+
+	// wait for the guest metrics to report an IP address
+	checkIPCtx, cancel := context.WithTimeout(ctx, time.Duration(checkIPTimeout)*time.Second)
+	defer cancel()
+	var ip string
+	err = waitForEvents(checkIPCtx, session, func() (bool, []string, error) {
+		// the guest metrics are created once the VM has booted
+		vmRecord.GuestMetrics, err = xenapi.VM.GetGuestMetrics(session, vmRef)
+		if err != nil {
+			return false, nil, newXAPIError(err)
+		}
+		ip, _ = getIPAddressFromMetrics(session, vmRecord)
+		if ip != "" {
+			return true, nil, nil
+		}
+		tflog.Debug(ctx, "-----> Waiting for the IP address in the guest metrics")
+		subscriptions := []string{"vm/" + string(vmRef)}
+		if vmRecord.GuestMetrics != "OpaqueRef:NULL" {
+			subscriptions = append(subscriptions, "vm_guest_metrics/"+string(vmRecord.GuestMetrics))
 		}
+		return false, subscriptions, nil
+	})
+	if err != nil {
+		if checkIPCtx.Err() != nil && ctx.Err() == nil {
+			return "", errors.New("get IP timeout in " + strconv.FormatInt(checkIPTimeout, 10) + " seconds")
+		}
+		return "", err
 	}
+
+	return ip, nil
 }
 
 func getIPAddressFromMetrics(session *xenapi.Session, vmRecord xenapi.VMRecord) (string, error) {
 	vmGuestMetricRecord, err := xenapi.VMGuestMetrics.GetRecord(session, vmRecord.GuestMetrics)
 	if err != nil {
-		return "", errors.New(err.Error())
+		return "", newXAPIError(err)
 	}
 
 	for k, v := range vmGuestMetricRecord.Networks {
@@ -1351,60 +1932,63 @@ func getIPAddressFromMetrics(session *xenapi.Session, vmRecord xenapi.VMRecord)
 	return "", errors.New("unable to get IP address from metrics")
 }
 
-func cleanupVMResource(session *xenapi.Session, vmRef xenapi.VMRef) error {
+// cleanupVMResource destroys the VM with its VIFs and VBDs, and the VDIs of the
+// unmanaged disks, i.e. cloned from the template or the config drive.
+func cleanupVMResource(session *xenapi.Session, vmRef xenapi.VMRef, ownedVBDs []xenapi.VBDRef) error {
 	// delete VIFs and VBDs, then destroy VM
 	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
-	// if VM is runing, stop it first
-	if vmRecord.PowerState == xenapi.VMPowerStateRunning {
+	// if VM isn't halted, stop it first
+	if vmRecord.PowerState != xenapi.VMPowerStateHalted {
 		err := xenapi.VM.HardShutdown(session, vmRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
 	for _, vifRef := range vmRecord.VIFs {
 		err := xenapi.VIF.Destroy(session, vifRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
 	var vdiRefs []xenapi.VDIRef
 	for _, vbdRef := range vmRecord.VBDs {
-		if slices.Contains(getTemplateVBDRefListFromVMRecord(vmRecord), vbdRef) {
+		if slices.Contains(ownedVBDs, vbdRef) {
 			vdiRef, err := xenapi.VBD.GetVDI(session, vbdRef)
 			if err != nil {
-				return errors.New(err.Error())
+				return newXAPIError(err)
 			}
 			vdiRefs = append(vdiRefs, vdiRef)
 		}
 		err := xenapi.VBD.Destroy(session, vbdRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
 	for _, vdiRef := range vdiRefs {
 		err := xenapi.VDI.Destroy(session, vdiRef)
 		if err != nil {
-			return errors.New(err.Error())
+			return newXAPIError(err)
 		}
 	}
 
 	err = xenapi.VM.Destroy(session, vmRef)
 	if err != nil {
-		return errors.New(err.Error())
+		return newXAPIError(err)
 	}
 
 	return nil
 }
 
 func vmResourceModelUpdateCheck(plan vmResourceModel, state vmResourceModel) error {
-	if plan.TemplateName != state.TemplateName {
+	// the template name is unknown after importing a VM
+	if !state.TemplateName.IsNull() && plan.TemplateName != state.TemplateName {
 		return errors.New(`"template_name" doesn't expected to be updated`)
 	}
 	if !plan.BootMode.IsUnknown() && plan.BootMode != state.BootMode {
@@ -1422,3 +2006,146 @@ func ToInt32(n int) (int32, error) {
 	}
 	return int32(n), nil
 }
+
+// vmResourceModelPlanCheck resolves the references of the plan against the
+// pool, so that the errors are reported by terraform plan rather than during
+// the apply. The state is nil when the VM is going to be created.
+func vmResourceModelPlanCheck(ctx context.Context, session *xenapi.Session, plan vmResourceModel, state *vmResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	vmUUID := ""
+	if state != nil {
+		vmUUID = state.UUID.ValueString()
+	}
+
+	if state == nil && !plan.TemplateName.IsUnknown() {
+		templateRef, err := getFirstTemplate(session, plan.TemplateName.ValueString())
+		if err != nil {
+			diags.AddAttributeError(path.Root("template_name"), "Unable to get template Ref", err.Error())
+		} else if !plan.SRForFullDiskCopy.IsUnknown() && plan.SRForFullDiskCopy.ValueString() != "" {
+			_, err = checkIfSupportFullCopy(session, templateRef, plan.SRForFullDiskCopy.ValueString())
+			if err != nil {
+				diags.AddAttributeError(path.Root("sr_for_full_disk_copy"), "Use storage-level full disk copy but get error", err.Error())
+			}
+		}
+	}
+
+	if !plan.HardDrive.IsUnknown() {
+		var hardDrives []vbdResourceModel
+		diags.Append(plan.HardDrive.ElementsAs(ctx, &hardDrives, false)...)
+		for _, hardDrive := range hardDrives {
+			if isInlineHardDrive(hardDrive) && !hardDrive.SR.IsUnknown() {
+				_, err := xenapi.SR.GetByUUID(session, hardDrive.SR.ValueString())
+				if err != nil {
+					diags.AddAttributeError(path.Root("hard_drive"), "Invalid hard drive", "unable to find the SR "+hardDrive.SR.ValueString()+". "+err.Error())
+				}
+			}
+			if hardDrive.VDI.IsUnknown() || hardDrive.VDI.IsNull() {
+				continue
+			}
+			err := checkVDIAvailable(session, hardDrive.VDI.ValueString(), vmUUID)
+			if err != nil {
+				diags.AddAttributeError(path.Root("hard_drive"), "Invalid hard drive", err.Error())
+			}
+		}
+	}
+
+	if !plan.NetworkInterface.IsUnknown() {
+		var networkInterfaces []vifResourceModel
+		diags.Append(plan.NetworkInterface.ElementsAs(ctx, &networkInterfaces, false)...)
+		for _, networkInterface := range networkInterfaces {
+			if networkInterface.Network.IsUnknown() {
+				continue
+			}
+			_, err := xenapi.Network.GetByUUID(session, networkInterface.Network.ValueString())
+			if err != nil {
+				diags.AddAttributeError(path.Root("network_interface"), "Invalid network interface", "unable to find the network "+networkInterface.Network.ValueString()+". "+err.Error())
+			}
+		}
+	}
+
+	diags.Append(cloudInitPlanCheck(ctx, session, plan, state)...)
+	diags.Append(hostPlanCheck(session, plan, state)...)
+
+	if !plan.PowerState.IsUnknown() && !plan.PowerState.IsNull() && plan.PowerState.ValueString() != "running" &&
+		!plan.CheckIPTimeout.IsUnknown() && plan.CheckIPTimeout.ValueInt64() > 0 {
+		diags.AddAttributeError(path.Root("power_state"), "Unable to check the IP address of the VM",
+			"check_ip_timeout waits for the IP address of a running VM, unset it or set power_state to running.")
+	}
+
+	if !plan.StaticMemMax.IsUnknown() {
+		maxMemory, err := getMaxHostMemory(session)
+		if err != nil {
+			tflog.Debug(ctx, "-----> Unable to get the memory of the hosts. "+err.Error())
+		} else if plan.StaticMemMax.ValueInt64() > maxMemory {
+			diags.AddAttributeWarning(path.Root("static_mem_max"), "Memory exceeds the host capacity",
+				"static_mem_max is greater than the memory of every host of the pool ("+strconv.FormatInt(maxMemory, 10)+" bytes), the VM won't be able to start.")
+		}
+	}
+
+	return diags
+}
+
+// getMaxHostMemory returns the total memory of the largest host of the pool.
+func getMaxHostMemory(session *xenapi.Session) (int64, error) {
+	var maxMemory int64
+	hostRecords, err := xenapi.Host.GetAllRecords(session)
+	if err != nil {
+		return maxMemory, newXAPIError(err)
+	}
+	for _, hostRecord := range hostRecords {
+		memory, err := xenapi.HostMetrics.GetMemoryTotal(session, hostRecord.Metrics)
+		if err != nil {
+			return maxMemory, newXAPIError(err)
+		}
+		maxMemory = max(maxMemory, int64(memory))
+	}
+	return maxMemory, nil
+}
+
+// findVMsForImport returns the UUIDs of the VMs with the name of the
+// selectors, excluding the templates, the snapshots and the control domains.
+func findVMsForImport(session *xenapi.Session, selectors map[string]string) ([]string, error) {
+	vmRecords, err := xenapi.VM.GetAllRecords(session)
+	if err != nil {
+		return nil, newXAPIError(err)
+	}
+	var uuids []string
+	for _, vmRecord := range vmRecords {
+		if vmRecord.IsATemplate || vmRecord.IsASnapshot || vmRecord.IsControlDomain || vmRecord.NameLabel != selectors["name"] {
+			continue
+		}
+		uuids = append(uuids, vmRecord.UUID)
+	}
+
+	return uuids, nil
+}
+
+// hostPlanCheck checks the hosts of affinity_host and resident_host, and that
+// the existing VM can run on the new resident_host.
+func hostPlanCheck(session *xenapi.Session, plan vmResourceModel, state *vmResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	_, err := getHostRefFromUUID(session, plan.AffinityHost)
+	if err != nil {
+		diags.AddAttributeError(path.Root("affinity_host"), "Invalid affinity host", "unable to find the host "+plan.AffinityHost.ValueString()+". "+err.Error())
+	}
+
+	hostRef, err := getHostRefFromUUID(session, plan.ResidentHost)
+	if err != nil {
+		diags.AddAttributeError(path.Root("resident_host"), "Invalid resident host", "unable to find the host "+plan.ResidentHost.ValueString()+". "+err.Error())
+		return diags
+	}
+	if hostRef == "OpaqueRef:NULL" || state == nil || plan.ResidentHost.Equal(state.ResidentHost) {
+		return diags
+	}
+	vmRef, err := xenapi.VM.GetByUUID(session, state.UUID.ValueString())
+	if err != nil {
+		// the VM is recreated by the refresh
+		return diags
+	}
+	err = xenapi.VM.AssertCanBootHere(session, vmRef, hostRef)
+	if err != nil {
+		diags.Append(xapiErrorDiagnostic("The VM can't run on the resident host", err, "resident_host"))
+	}
+
+	return diags
+}
@@ -0,0 +1,512 @@
+package xenserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"xenapi"
+)
+
+type fakePrivateState map[string][]byte
+
+func (p fakePrivateState) GetKey(_ context.Context, key string) ([]byte, diag.Diagnostics) {
+	return p[key], nil
+}
+
+func (p fakePrivateState) SetKey(_ context.Context, key string, value []byte) diag.Diagnostics {
+	p[key] = value
+	return nil
+}
+
+func TestGetVMPrivateState(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	templateRef, err := getFirstTemplate(session, "Windows 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "legacy vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bookkeeping of the previous versions of the provider
+	err = xenapi.VM.SetOtherConfig(session, vmRef, map[string]string{
+		"flag":                     "1",
+		"tf_other_config_keys":     "flag",
+		"tf_check_ip_timeout":      "60",
+		"tf_template_name":         "Windows 11",
+		"tf_sr_for_full_disk_copy": "",
+		"tf_template_vbds":         "OpaqueRef:vbd1,OpaqueRef:vbd2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := fakePrivateState{}
+	// the state after an import
+	data := vmResourceModel{
+		TemplateName:      types.StringNull(),
+		SRForFullDiskCopy: types.StringNull(),
+		CheckIPTimeout:    types.Int64Null(),
+	}
+	vmPrivate, err := getVMPrivateState(ctx, private, session, vmRef, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(vmPrivate.OtherConfigKeys, []string{"flag"}) || !slices.Equal(vmPrivate.TemplateVBDs, []xenapi.VBDRef{"OpaqueRef:vbd1", "OpaqueRef:vbd2"}) {
+		t.Fatalf("unexpected VM private state: %+v", vmPrivate)
+	}
+	if data.TemplateName.ValueString() != "Windows 11" || data.CheckIPTimeout.ValueInt64() != 60 || data.SRForFullDiskCopy.ValueString() != "" {
+		t.Fatalf("unexpected VM resource model: %+v", data)
+	}
+	// the VM isn't changed until the private state is saved
+	otherConfig, err := xenapi.VM.GetOtherConfig(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(otherConfig) != 6 {
+		t.Fatalf("expected the legacy keys to be kept while reading, got: %v", otherConfig)
+	}
+
+	err = setVMPrivateState(ctx, private, vmPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = removeLegacyVMOtherConfig(ctx, session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherConfig, err = xenapi.VM.GetOtherConfig(session, vmRef)
+	if err != nil || len(otherConfig) != 1 || otherConfig["flag"] != "1" {
+		t.Fatalf("expected only the legacy keys to be removed, got %v: %v", otherConfig, err)
+	}
+	err = updateOtherConfigFromPlan(ctx, session, vmRef, vmResourceModel{OtherConfig: types.MapValueMust(types.StringType, nil)}, &vmPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherConfig, err = xenapi.VM.GetOtherConfig(session, vmRef)
+	if err != nil || len(otherConfig) != 0 || len(vmPrivate.OtherConfigKeys) != 0 {
+		t.Fatalf("expected the other config keys and the legacy keys to be removed, got %v: %v", otherConfig, err)
+	}
+
+	stored, err := getVMPrivateState(ctx, private, session, vmRef, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(stored.TemplateVBDs, vmPrivate.TemplateVBDs) || !slices.Equal(stored.OtherConfigKeys, []string{"flag"}) {
+		t.Fatalf("unexpected stored VM private state: %+v", stored)
+	}
+}
+
+func TestGetImportedVMPrivateState(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// a template with a disk and an other_config key
+	templateRef, err := getFirstTemplate(session, "Debian Bullseye 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srRef, err := xenapi.Pool.GetDefaultSR(session, mustGetPool(t, session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRef, err := xenapi.VDI.Create(session, xenapi.VDIRecord{NameLabel: "template disk", SR: srRef, VirtualSize: 1073741824, Type: xenapi.VdiTypeUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.VBD.Create(session, xenapi.VBDRecord{VM: templateRef, VDI: vdiRef, Userdevice: "0", Mode: xenapi.VbdModeRW, Type: xenapi.VbdTypeDisk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.SetOtherConfig(session, templateRef, map[string]string{"install-methods": "cdrom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vmRef, err := cloneVM(ctx, session, templateRef, "imported vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmPrivate, err := setOtherConfigWhenCreate(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateOtherConfigFromPlan(ctx, session, vmRef, vmResourceModel{OtherConfig: types.MapValueMust(types.StringType, map[string]attr.Value{"flag": types.StringValue("1")})}, &vmPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userVDIRef, err := xenapi.VDI.Create(session, xenapi.VDIRecord{NameLabel: "user disk", SR: srRef, VirtualSize: 1073741824, Type: xenapi.VdiTypeUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.VBD.Create(session, xenapi.VBDRecord{VM: vmRef, VDI: userVDIRef, Userdevice: "1", Mode: xenapi.VbdModeRW, Type: xenapi.VbdTypeDisk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the state after an import
+	data := vmResourceModel{
+		TemplateName:      types.StringNull(),
+		SRForFullDiskCopy: types.StringNull(),
+		CheckIPTimeout:    types.Int64Null(),
+	}
+	imported, err := getVMPrivateState(ctx, fakePrivateState{}, session, vmRef, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(imported.TemplateVBDs, vmPrivate.TemplateVBDs) || len(imported.TemplateVBDs) != 1 || !slices.Equal(imported.OtherConfigKeys, []string{"flag"}) {
+		t.Fatalf("expected the VM private state %+v, got %+v", vmPrivate, imported)
+	}
+	if data.TemplateName.ValueString() != "Debian Bullseye 11" || data.CheckIPTimeout.ValueInt64() != 0 || data.SRForFullDiskCopy.ValueString() != "" {
+		t.Fatalf("unexpected VM resource model: %+v", data)
+	}
+}
+
+func TestVMResourceModelPlanCheck(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	templateRef, err := getFirstTemplate(session, "Windows 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "other vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srRef, err := xenapi.Pool.GetDefaultSR(session, mustGetPool(t, session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiRef, err := xenapi.VDI.Create(session, xenapi.VDIRecord{NameLabel: "disk", SR: srRef, VirtualSize: 1073741824, Type: xenapi.VdiTypeUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xenapi.VBD.Create(session, xenapi.VBDRecord{VM: vmRef, VDI: vdiRef, Userdevice: "0", Mode: xenapi.VbdModeRW, Type: xenapi.VbdTypeDisk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdiUUID, err := xenapi.VDI.GetUUID(session, vdiRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hardDrive, diags := types.SetValueFrom(ctx, types.ObjectType{AttrTypes: vbdResourceModelAttrTypes}, []vbdResourceModel{
+		{VDI: types.StringValue(vdiUUID), VBD: types.StringUnknown(), Mode: types.StringValue("RW"), Bootable: types.BoolValue(true)},
+	})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	networkInterface, diags := types.SetValueFrom(ctx, types.ObjectType{AttrTypes: vifResourceModelAttrTypes}, []vifResourceModel{
+		{Network: types.StringValue("00000000-0000-0000-0000-000000000000"), Device: types.StringValue("0"), VIF: types.StringUnknown(), MAC: types.StringUnknown(), OtherConfig: types.MapNull(types.StringType)},
+	})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	plan := vmResourceModel{
+		TemplateName:      types.StringValue("Windows 2000"),
+		SRForFullDiskCopy: types.StringValue(""),
+		StaticMemMax:      types.Int64Value(128 * 1024 * 1024 * 1024),
+		HardDrive:         hardDrive,
+		NetworkInterface:  networkInterface,
+	}
+
+	diags = vmResourceModelPlanCheck(ctx, session, plan, nil)
+	if diags.ErrorsCount() != 3 || diags.WarningsCount() != 1 {
+		t.Fatalf("expected 3 errors and 1 warning, got: %v", diags)
+	}
+
+	// the VDI is attached to the VM of the state
+	vmUUID, err := xenapi.VM.GetUUID(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.StaticMemMax = types.Int64Value(4 * 1024 * 1024 * 1024)
+	plan.NetworkInterface = types.SetUnknown(types.ObjectType{AttrTypes: vifResourceModelAttrTypes})
+	diags = vmResourceModelPlanCheck(ctx, session, plan, &vmResourceModel{UUID: types.StringValue(vmUUID)})
+	if diags.HasError() || diags.WarningsCount() != 0 {
+		t.Fatalf("expected no diagnostics, got: %v", diags)
+	}
+}
+
+func mustGetPool(t *testing.T, session *xenapi.Session) xenapi.PoolRef {
+	t.Helper()
+	poolRefs, err := xenapi.Pool.GetAll(session)
+	if err != nil || len(poolRefs) != 1 {
+		t.Fatalf("expected 1 pool, got %d: %v", len(poolRefs), err)
+	}
+	return poolRefs[0]
+}
+
+func TestSetVMPowerState(t *testing.T) {
+	fake := newFakeXAPI("root", "password")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	templateRef, err := getFirstTemplate(session, "Debian Bullseye 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.SetIsATemplate(session, vmRef, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := vmResourceModel{CheckIPTimeout: types.Int64Value(0), HardShutdownFallback: types.BoolValue(false), CleanShutdownTimeout: types.Int64Value(10)}
+	for _, powerState := range []string{"paused", "suspended", "running", "halted", "suspended", "halted"} {
+		plan.PowerState = types.StringValue(powerState)
+		err = setVMPowerState(ctx, session, vmRef, plan)
+		if err != nil {
+			t.Fatalf("unable to change the power state to %s: %v", powerState, err)
+		}
+		actual, err := xenapi.VM.GetPowerState(session, vmRef)
+		if err != nil || !strings.EqualFold(string(actual), powerState) {
+			t.Fatalf("expected the power state %s, got %s: %v", powerState, actual, err)
+		}
+	}
+
+	// the clean shutdown fails without the VM tools
+	plan.PowerState = types.StringValue("running")
+	err = setVMPowerState(ctx, session, vmRef, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	fake.faults["vm.clean_shutdown"] = fakeError("VM_MISSING_PV_DRIVERS", string(vmRef))
+	fake.mu.Unlock()
+	plan.PowerState = types.StringValue("halted")
+	err = setVMPowerState(ctx, session, vmRef, plan)
+	if err == nil || !strings.Contains(err.Error(), "VM_MISSING_PV_DRIVERS") {
+		t.Fatalf("expected the failure of the clean shutdown, got: %v", err)
+	}
+	plan.HardShutdownFallback = types.BoolValue(true)
+	err = setVMPowerState(ctx, session, vmRef, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := xenapi.VM.GetPowerState(session, vmRef)
+	if err != nil || actual != xenapi.VMPowerStateHalted {
+		t.Fatalf("expected the VM to be hard shut down, got %s: %v", actual, err)
+	}
+
+	// the IP address is only checked on a running VM
+	plan.CheckIPTimeout = types.Int64Value(60)
+	plan.TemplateName = types.StringUnknown()
+	plan.HardDrive = types.SetUnknown(types.ObjectType{AttrTypes: vbdResourceModelAttrTypes})
+	plan.NetworkInterface = types.SetUnknown(types.ObjectType{AttrTypes: vifResourceModelAttrTypes})
+	plan.StaticMemMax = types.Int64Unknown()
+	diags := vmResourceModelPlanCheck(ctx, session, plan, nil)
+	if !diags.Contains(diag.NewAttributeErrorDiagnostic(path.Root("power_state"), "Unable to check the IP address of the VM",
+		"check_ip_timeout waits for the IP address of a running VM, unset it or set power_state to running.")) {
+		t.Fatalf("expected the error of power_state, got: %v", diags)
+	}
+}
+
+func TestVMResourceModelUpdateHalt(t *testing.T) {
+	server := httptest.NewServer(newFakeXAPI("root", "password"))
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	templateRef, err := getFirstTemplate(session, "Debian Bullseye 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.SetIsATemplate(session, vmRef, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the settings of a VM created by the provider
+	err = xenapi.VM.SetPlatform(session, vmRef, map[string]string{"cores-per-socket": "1", "secureboot": "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.SetHVMBootParams(session, vmRef, map[string]string{"order": "cdn", "firmware": "bios"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.Start(session, vmRef, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := vmResourceModel{
+		CheckIPTimeout:       types.Int64Value(0),
+		HardShutdownFallback: types.BoolValue(true),
+		CleanShutdownTimeout: types.Int64Value(10),
+		ResidentHost:         types.StringNull(),
+	}
+	vmPrivate := vmPrivateState{}
+	err = updateVMResourceModel(ctx, session, vmRecord, vmPrivate, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the memory and the vcpus change once the VM is halted
+	plan := state
+	plan.PowerState = types.StringValue("halted")
+	plan.StaticMemMax = types.Int64Value(state.StaticMemMax.ValueInt64() * 2)
+	plan.DynamicMemMax = plan.StaticMemMax
+	plan.VCPUs = types.Int32Value(state.VCPUs.ValueInt32() + 1)
+	err = vmResourceModelUpdate(ctx, session, vmRef, plan, state, &vmPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRecord, err = xenapi.VM.GetRecord(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vmRecord.PowerState != xenapi.VMPowerStateHalted || int64(vmRecord.MemoryStaticMax) != plan.StaticMemMax.ValueInt64() || vmRecord.VCPUsMax != int(plan.VCPUs.ValueInt32()) {
+		t.Fatalf("expected the halted VM with %d bytes and %d vcpus, got %s with %d bytes and %d vcpus",
+			plan.StaticMemMax.ValueInt64(), plan.VCPUs.ValueInt32(), vmRecord.PowerState, vmRecord.MemoryStaticMax, vmRecord.VCPUsMax)
+	}
+}
+
+func TestVMHostPlacement(t *testing.T) {
+	fake := newFakeXAPI("root", "password")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	session, err := loginServer(server.URL, "root", "password", &clientConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	fake.mu.Lock()
+	firstHost := fake.ref("host")
+	secondHost := fake.add("host", map[string]any{"name_label": "xenserver-fake-2", "enabled": true})
+	fake.mu.Unlock()
+	hostUUIDs := map[string]string{}
+	for _, host := range []string{firstHost, secondHost} {
+		hostUUIDs[host], err = xenapi.Host.GetUUID(session, xenapi.HostRef(host))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templateRef, err := getFirstTemplate(session, "Debian Bullseye 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRef, err := cloneVM(ctx, session, templateRef, "vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xenapi.VM.SetIsATemplate(session, vmRef, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmUUID, err := xenapi.VM.GetUUID(session, vmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := vmResourceModel{
+		PowerState:     types.StringValue("running"),
+		AffinityHost:   types.StringValue(hostUUIDs[firstHost]),
+		ResidentHost:   types.StringValue(hostUUIDs[secondHost]),
+		CheckIPTimeout: types.Int64Value(0),
+	}
+	err = updateAffinityHost(session, vmRef, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setVMPowerState(ctx, session, vmRef, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmRecord, err := xenapi.VM.GetRecord(session, vmRef)
+	if err != nil || string(vmRecord.Affinity) != firstHost || string(vmRecord.ResidentOn) != secondHost {
+		t.Fatalf("expected the VM to start on %s with the affinity %s, got %s and %s: %v", secondHost, firstHost, vmRecord.ResidentOn, vmRecord.Affinity, err)
+	}
+
+	// the VM moves to the new resident host
+	state := plan
+	state.UUID = types.StringValue(vmUUID)
+	plan.ResidentHost = types.StringValue(hostUUIDs[firstHost])
+	diags := hostPlanCheck(session, plan, &state)
+	if diags.HasError() {
+		t.Fatalf("expected no error, got: %v", diags)
+	}
+	err = migrateVM(ctx, session, vmRef, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	residentOn, err := xenapi.VM.GetResidentOn(session, vmRef)
+	if err != nil || string(residentOn) != firstHost {
+		t.Fatalf("expected the VM to be migrated to %s, got %s: %v", firstHost, residentOn, err)
+	}
+
+	// the plan is checked in read-only mode
+	readOnlySession, err := loginServer(server.URL, "root", "password", &clientConf{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.ResidentHost = types.StringValue(hostUUIDs[firstHost])
+	plan.ResidentHost = types.StringValue(hostUUIDs[secondHost])
+	diags = hostPlanCheck(readOnlySession, plan, &state)
+	if diags.HasError() {
+		t.Fatalf("expected no error in read-only mode, got: %v", diags)
+	}
+	state.ResidentHost = plan.ResidentHost
+	plan.ResidentHost = types.StringValue(hostUUIDs[firstHost])
+
+	// the plan checks the hosts
+	fake.mu.Lock()
+	fake.faults["vm.assert_can_boot_here"] = fakeError("HOST_NOT_ENOUGH_FREE_MEMORY", "4294967296", "1073741824")
+	fake.mu.Unlock()
+	diags = hostPlanCheck(session, plan, &state)
+	if diags.ErrorsCount() != 1 || !diags.Errors()[0].(diag.DiagnosticWithPath).Path().Equal(path.Root("resident_host")) {
+		t.Fatalf("expected the error of resident_host, got: %v", diags)
+	}
+	plan.AffinityHost = types.StringValue("00000000-0000-0000-0000-000000000000")
+	plan.ResidentHost = types.StringValue("00000000-0000-0000-0000-000000000000")
+	diags = hostPlanCheck(session, plan, &state)
+	if diags.ErrorsCount() != 2 {
+		t.Fatalf("expected the errors of the unknown hosts, got: %v", diags)
+	}
+}